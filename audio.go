@@ -1,37 +1,79 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net/url"
-	"os"
-	"os/exec"
-	"regexp"
 	"strconv"
 	"strings"
-	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/polly"
 	"github.com/aws/aws-sdk-go-v2/service/polly/types"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/ebitengine/oto/v3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/hajimehoshi/go-mp3"
 )
 
 var (
-	errInvalidS3Path       = errors.New("s3 path is not three elements")
-	errFfmpegNoDuration    = errors.New("unable to get duration from ffmpeg")
-	errFfmpegParseDuration = errors.New("could not parse duration")
+	errInvalidS3Path = errors.New("s3 uri has no object key")
 )
 
+// synthesisPollInterval is how long to wait between GetSpeechSynthesisTask calls.
+var synthesisPollInterval = 5 * time.Second
+
+// synthesisOpts are the polly and s3 settings shared by every synthesis task in a run.
+type synthesisOpts struct {
+	bucket       string
+	keyPrefix    string // OutputS3KeyPrefix, polly writes <keyPrefix><task id>.mp3
+	kmsKeyID     string // when set the output is re-encrypted in place with SSE-KMS
+	voiceID      string
+	engine       types.Engine       // empty uses polly's default
+	rate         int                // prosody rate of every section in percent, 0 is normal speed
+	languageCode types.LanguageCode // set for segments read in another language, see languageSegments
+	format       audioFormat
+	keepS3       bool                   // leave the output in the bucket instead of deleting it
+	marks        []types.SpeechMarkType // when set a speech marks task is run alongside each audio task
+	ssml         bool                   // the text of each section is sent as ssml, see toSSML
+	fitCues      bool                   // speed up subtitle cues that overrun, see timeCue
+	lexicons     []string               // names of lexicons stored in polly
+	pause        time.Duration          // silence before the section, sent as an ssml break
+}
+
 // synthesizeText takes text and sends it to AWS polly for processing, the polly object containing the audio.
-func synthesizeText(ctx context.Context, pollyClient *polly.Client, s3Client *s3.Client, logs chan string, bucket, voiceID, text string) (*s3.GetObjectOutput, string, error) {
+func synthesizeText(ctx context.Context, pollyClient *polly.Client, s3Client *s3.Client, logs chan string, synth synthesisOpts, text string) (*s3.GetObjectOutput, string, error) {
 
-	inputTask := &polly.StartSpeechSynthesisTaskInput{OutputFormat: "mp3", OutputS3BucketName: aws.String(bucket), Text: aws.String(text), VoiceId: types.VoiceId(voiceID)}
+	inputTask := &polly.StartSpeechSynthesisTaskInput{OutputFormat: types.OutputFormatMp3, OutputS3BucketName: aws.String(synth.bucket), Text: aws.String(text), VoiceId: types.VoiceId(synth.voiceID)}
+	if synth.format.output != "" {
+		inputTask.OutputFormat = synth.format.output
+	}
+	if synth.format.sampleRate != 0 {
+		inputTask.SampleRate = aws.String(strconv.Itoa(synth.format.sampleRate))
+	}
+	if synth.keyPrefix != "" {
+		inputTask.OutputS3KeyPrefix = aws.String(synth.keyPrefix)
+	}
+	if synth.ssml {
+		inputTask.TextType = types.TextTypeSsml
+	}
+	if len(synth.lexicons) > 0 {
+		inputTask.LexiconNames = synth.lexicons
+	}
+	if synth.languageCode != "" {
+		inputTask.LanguageCode = synth.languageCode
+	}
+	if synth.engine != "" {
+		inputTask.Engine = synth.engine
+	}
+	return runSynthesisTask(ctx, pollyClient, s3Client, logs, synth, inputTask)
+}
+
+// runSynthesisTask starts the task, waits for polly to finish it and returns the output object along with its key.
+func runSynthesisTask(ctx context.Context, pollyClient *polly.Client, s3Client *s3.Client, logs chan string, synth synthesisOpts, inputTask *polly.StartSpeechSynthesisTaskInput) (*s3.GetObjectOutput, string, error) {
 	task, err := pollyClient.StartSpeechSynthesisTask(ctx, inputTask)
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to convert to speech, %w", err)
@@ -53,124 +95,98 @@ func synthesizeText(ctx context.Context, pollyClient *polly.Client, s3Client *s3
 
 		logs <- fmt.Sprintf("Synthesis running... status: %s, id: %s \n", sTask.SynthesisTask.TaskStatus, *sTask.SynthesisTask.TaskId)
 
-		time.Sleep(time.Second * 5)
+		time.Sleep(synthesisPollInterval)
 	}
 
-	s3File, err := url.Parse(fileURI)
+	key, err := parseS3OutputURI(fileURI, synth.bucket)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to parse s3 uri, %w", err)
+		return nil, "", err
 	}
 
-	var path = strings.Split(s3File.Path, "/")
-	if len(path) != 3 {
-		return nil, "", fmt.Errorf("%w: got %d elements: %+v", errInvalidS3Path, len(path), path)
+	if synth.kmsKeyID != "" {
+		if err := encryptS3File(ctx, s3Client, synth.bucket, key, synth.kmsKeyID); err != nil {
+			return nil, "", err
+		}
 	}
 
 	voice, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    &path[2],
+		Bucket: aws.String(synth.bucket),
+		Key:    aws.String(key),
 	})
 	if err != nil {
 		return nil, "", fmt.Errorf("s3 get object: %w", err)
 	}
 
-	return voice, path[2], nil
+	return voice, key, nil
 }
 
-// play does just that (using oto). paused is a shared atomic flag: true = paused, false = playing.
-func play(sound io.Reader, paused *atomic.Bool) error {
-
-	// Decode file
-	var decodedMp3, err = mp3.NewDecoder(sound)
+// parseS3OutputURI returns the object key from the OutputUri polly reports for a task. Polly normally
+// returns path style uris (https://s3.<region>.amazonaws.com/<bucket>/<key>) but virtual hosted style
+// uris (https://<bucket>.s3.<region>.amazonaws.com/<key>) are handled as well, and the key may be any depth.
+func parseS3OutputURI(fileURI, bucket string) (string, error) {
+	s3File, err := url.Parse(fileURI)
 	if err != nil {
-		panic("mp3.NewDecoder failed: " + err.Error())
+		return "", fmt.Errorf("failed to parse s3 uri, %w", err)
 	}
 
-	var options = &oto.NewContextOptions{
-		SampleRate:   decodedMp3.SampleRate(),
-		ChannelCount: 2,
-		Format:       oto.FormatSignedInt16LE,
+	var key = strings.TrimPrefix(s3File.Path, "/")
+	if !strings.HasPrefix(s3File.Host, bucket+".") {
+		key = strings.TrimPrefix(key, bucket+"/")
 	}
-	otoCtx, ready, err := oto.NewContext(options)
-	if err != nil {
-		return fmt.Errorf("oto.NewContext: %w", err)
+	if key == "" || strings.HasSuffix(key, "/") {
+		return "", fmt.Errorf("%w: %s", errInvalidS3Path, fileURI)
 	}
-	<-ready
+	return key, nil
+}
 
-	var player = otoCtx.NewPlayer(decodedMp3)
-	player.Play()
-	var isPaused bool
-	for {
-		if p := paused.Load(); p != isPaused {
-			isPaused = p
-			if isPaused {
-				player.Pause()
-			} else {
-				player.Play()
-			}
-		}
-		if !isPaused && !player.IsPlaying() {
-			break
-		}
-		time.Sleep(time.Millisecond)
+// encryptS3File copies the object onto itself with SSE-KMS. Polly has no way to request KMS
+// encryption of its output so this is done before the object is read back.
+func encryptS3File(ctx context.Context, s3Client *s3.Client, bucket, key, kmsKeyID string) error {
+	var source = url.URL{Path: bucket + "/" + key}
+	var _, err = s3Client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:               aws.String(bucket),
+		Key:                  aws.String(key),
+		CopySource:           aws.String(source.EscapedPath()),
+		ServerSideEncryption: s3types.ServerSideEncryptionAwsKms,
+		SSEKMSKeyId:          aws.String(kmsKeyID),
+	})
+	if err != nil {
+		return fmt.Errorf("s3 copy object with sse-kms: %w", err)
 	}
-
 	return nil
 }
 
-// getDuration uses ffmpeg to get the duration of the audio because its not
-// returned by polly. The return value is seconds. Hopefully polly adds the
-// duration in the return data and we dont have to do this anymore.
-func getDuration(filename string) (int, error) {
-
-	if _, err := os.Stat(filename); err != nil {
-		return 0, fmt.Errorf("stat %s: %w", filename, err)
+// decodeMP3 decodes the mp3 to 16 bit pcm and returns its duration in seconds, rounded down.
+// go-mp3 always produces two channels, mono mp3s (which is what polly returns) are mixed back down
+// so the sink is opened with the real channel count.
+func decodeMP3(body []byte) (io.Reader, pcmFormat, int, error) {
+	decodedMp3, err := mp3.NewDecoder(bytes.NewReader(body))
+	if err != nil {
+		return nil, pcmFormat{}, 0, fmt.Errorf("mp3.NewDecoder: %w", err)
 	}
+	var decoded = pcmFormat{sampleRate: decodedMp3.SampleRate(), channels: 2}
+	var seconds = int(decodedMp3.Length() / int64(decoded.bytesPerSecond()))
+	if mp3Channels(body) == 1 {
+		var mono = pcmFormat{sampleRate: decoded.sampleRate, channels: 1}
+		return newResampler(decodedMp3, decoded, mono), mono, seconds, nil
+	}
+	return decodedMp3, decoded, seconds, nil
+}
 
-	var imagePath = EscapeFilePath(filename)
-	//nolint:gosec
-	out, err := exec.Command("bash", "-c", fmt.Sprintf("ffmpeg -hide_banner -i %s -f null /dev/null", imagePath)).CombinedOutput()
-	if err != nil {
-		return 0, fmt.Errorf("ffmpeg on %s: %w: output: %s", imagePath, err, out)
-	}
-
-	// create regex and find the duration in the ffmpeg output
-	var durationRegex = regexp.MustCompile(`Duration:\s\d\d:\d\d:\d\d.\d\d`)
-	var match = durationRegex.FindString(string(out))
-	if match == "" {
-		return 0, errFfmpegNoDuration
-	}
-	match = strings.ReplaceAll(match, "Duration: ", "")
-
-	// iterate through the string and parse the time: e.g. (00:04:40.66)
-	var durationArr = strings.Split(match, ":")
-	var duration int
-	for i, digits := range durationArr {
-		switch i {
-		case 0:
-			// hours
-			num, err := strconv.Atoi(digits)
-			if err != nil {
-				return 0, fmt.Errorf("parsing hours: %w", err)
-			}
-			duration = num * 3600
-		case 1:
-			// minutes
-			num, err := strconv.Atoi(digits)
-			if err != nil {
-				return 0, fmt.Errorf("parsing minutes: %w", err)
-			}
-			duration += num * 60
-		case 2:
-			// seconds
-			num, err := strconv.ParseFloat(digits, 32)
-			if err != nil {
-				return 0, fmt.Errorf("parsing seconds: %w", err)
+// mp3Channels reads the channel mode from the first frame header, skipping any ID3v2 tag.
+func mp3Channels(body []byte) int {
+	if len(body) >= 10 && string(body[:3]) == "ID3" {
+		// the tag size is a 28 bit syncsafe integer that excludes the 10 byte header
+		var size = int(body[6])<<21 | int(body[7])<<14 | int(body[8])<<7 | int(body[9])
+		body = body[min(10+size, len(body)):]
+	}
+	for i := 0; i+3 < len(body); i++ {
+		if body[i] == 0xFF && body[i+1]&0xE0 == 0xE0 {
+			if body[i+3]>>6 == 3 {
+				return 1
 			}
-			duration += int(num)
-		default:
-			return 0, errFfmpegParseDuration
+			return 2
 		}
 	}
-	return duration, nil
+	return 2
 }
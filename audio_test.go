@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/kmulvey/text2speech/internal/fakeaws"
+	"github.com/stretchr/testify/assert"
+)
+
+// newFakeAWS starts the fake polly/s3 server and speeds up task polling for the duration of the test.
+func newFakeAWS(t *testing.T) *fakeaws.Server {
+	t.Helper()
+
+	var srv = fakeaws.New()
+	t.Cleanup(srv.Close)
+
+	var interval = synthesisPollInterval
+	synthesisPollInterval = time.Millisecond
+	t.Cleanup(func() { synthesisPollInterval = interval })
+
+	return srv
+}
+
+func TestParseS3OutputURI(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		uri string
+		key string
+		err error
+	}{
+		{uri: "https://s3.us-west-2.amazonaws.com/bucket/abc.mp3", key: "abc.mp3"},
+		{uri: "https://s3.us-west-2.amazonaws.com/bucket/a/b/c/abc.mp3", key: "a/b/c/abc.mp3"},
+		{uri: "https://bucket.s3.us-west-2.amazonaws.com/bucket/abc.mp3", key: "bucket/abc.mp3"},
+		{uri: "http://localhost:4566/bucket/with%20space/abc.mp3", key: "with space/abc.mp3"},
+		{uri: "https://s3.us-west-2.amazonaws.com/bucket/", err: errInvalidS3Path},
+	}
+	for _, tt := range tests {
+		key, err := parseS3OutputURI(tt.uri, "bucket")
+		assert.ErrorIs(t, err, tt.err, tt.uri)
+		assert.Equal(t, tt.key, key, tt.uri)
+	}
+}
+
+func TestSynthesizeText(t *testing.T) {
+	var srv = newFakeAWS(t)
+	srv.Script(fakeaws.Task{Pending: 2})
+
+	var logs = make(chan string, 10)
+	var synth = synthesisOpts{bucket: "bucket", keyPrefix: "runs/today/", voiceID: "Joanna", format: audioFormat{sampleRate: 24000}}
+	voice, key, err := synthesizeText(context.Background(), srv.PollyClient(), srv.S3Client(), logs, synth, "hello")
+	assert.NoError(t, err)
+	assert.Equal(t, "runs/today/00000000-0000-4000-8000-000000000001.mp3", key)
+	assert.Len(t, logs, 2)
+
+	body, err := io.ReadAll(voice.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, fakeaws.SilentMP3(time.Second, 24000), body)
+
+	var started = srv.Started()
+	assert.Len(t, started, 1)
+	assert.Equal(t, "hello", started[0].Text)
+	assert.Equal(t, "Joanna", started[0].VoiceID)
+	assert.Equal(t, "mp3", started[0].OutputFormat)
+	assert.Equal(t, "runs/today/", started[0].OutputS3KeyPrefix)
+	assert.Equal(t, "24000", started[0].SampleRate)
+}
+
+func TestDecodeMP3(t *testing.T) {
+	t.Parallel()
+
+	pcm, format, seconds, err := decodeMP3(fakeaws.SilentMP3(2*time.Second, 24000))
+	assert.NoError(t, err)
+	assert.Equal(t, pcmFormat{sampleRate: 24000, channels: 1}, format)
+	assert.Equal(t, 2, seconds)
+	b, err := io.ReadAll(pcm)
+	assert.NoError(t, err)
+	assert.InDelta(t, 24000*2*2, len(b), 24000*2*0.05)
+
+	// an ID3 tag in front of the first frame is skipped
+	var tagged = append([]byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 2, 0xFF, 0xFF}, fakeaws.SilentMP3(time.Second, 22050)...)
+	assert.Equal(t, 1, mp3Channels(tagged))
+}
+
+func TestSynthesizeTextErrors(t *testing.T) {
+	var srv = newFakeAWS(t)
+	srv.Script(
+		fakeaws.Task{StartError: "text too long"},
+		fakeaws.Task{Pending: 1, FailReason: "voice unavailable"},
+		fakeaws.Task{DenyGet: true},
+	)
+
+	var logs = make(chan string, 10)
+	var synth = synthesisOpts{bucket: "bucket", voiceID: "Matthew"}
+	for _, want := range []string{"text too long", "voice unavailable", "AccessDenied"} {
+		_, _, err := synthesizeText(context.Background(), srv.PollyClient(), srv.S3Client(), logs, synth, "hello")
+		assert.ErrorContains(t, err, want)
+	}
+}
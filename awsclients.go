@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/polly"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+var errInvalidEndpointURL = errors.New("endpoint url must include a scheme and host")
+
+// awsOpts are the settings used to build the polly and s3 clients. The endpoint overrides allow
+// pointing the tool at S3-compatible storage (MinIO), LocalStack or a test double.
+type awsOpts struct {
+	profile          string
+	region           string
+	endpointURL      string // used for both services unless overridden below
+	pollyEndpointURL string
+	s3EndpointURL    string
+	s3PathStyle      bool
+}
+
+// registerFlags adds the aws flags to fs so every command builds its clients the same way.
+func (o *awsOpts) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.profile, "profile", "default", "aws profile to use, empty to use the sdk defaults (env vars, AWS_PROFILE)")
+	fs.StringVar(&o.region, "region", "us-west-2", "aws region to use")
+	fs.StringVar(&o.endpointURL, "endpoint-url", "", "override the endpoint for both polly and s3, e.g. http://localhost:4566 for LocalStack")
+	fs.StringVar(&o.pollyEndpointURL, "polly-endpoint-url", "", "override the polly endpoint, takes precedence over -endpoint-url")
+	fs.StringVar(&o.s3EndpointURL, "s3-endpoint-url", "", "override the s3 endpoint, takes precedence over -endpoint-url")
+	fs.BoolVar(&o.s3PathStyle, "s3-path-style", false, "use path style s3 addressing (http://host/bucket/key), required by most S3-compatible stores")
+}
+
+// validate checks that any endpoint overrides are absolute urls.
+func (o awsOpts) validate() error {
+	for _, endpoint := range []string{o.endpointURL, o.pollyEndpointURL, o.s3EndpointURL} {
+		if endpoint == "" {
+			continue
+		}
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return fmt.Errorf("invalid endpoint url %s: %w", endpoint, err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("%w: %s", errInvalidEndpointURL, endpoint)
+		}
+	}
+	return nil
+}
+
+// newAWSClients loads the shared aws config and builds the polly and s3 clients with any endpoint overrides applied.
+func newAWSClients(ctx context.Context, o awsOpts) (*polly.Client, *s3.Client, error) {
+	var loadOpts = []func(*config.LoadOptions) error{config.WithRegion(o.region)}
+	if strings.TrimSpace(o.profile) != "" {
+		loadOpts = append(loadOpts, config.WithSharedConfigProfile(o.profile))
+	}
+	awsConfig, err := config.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load SDK configuration, %w", err)
+	}
+
+	var pollyEndpoint = firstNonEmpty(o.pollyEndpointURL, o.endpointURL)
+	var s3Endpoint = firstNonEmpty(o.s3EndpointURL, o.endpointURL)
+
+	var pollyClient = polly.NewFromConfig(awsConfig, func(po *polly.Options) {
+		if pollyEndpoint != "" {
+			po.BaseEndpoint = aws.String(pollyEndpoint)
+		}
+	})
+	var s3Client = s3.NewFromConfig(awsConfig, func(so *s3.Options) {
+		if s3Endpoint != "" {
+			so.BaseEndpoint = aws.String(s3Endpoint)
+		}
+		so.UsePathStyle = o.s3PathStyle
+	})
+	return pollyClient, s3Client, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
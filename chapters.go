@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// maxChapterTitleLen is the longest line that is considered a heading, longer lines are prose.
+const maxChapterTitleLen = 80
+
+var (
+	markdownHeadingRegex = regexp.MustCompile(`^#{1,6}\s+(.+?)\s*#*$`)
+	chapterHeadingRegex  = regexp.MustCompile(`(?i)^((chapter|part|book)\s+([0-9]+|[ivxlcdm]+|one|two|three|four|five|six|seven|eight|nine|ten|eleven|twelve|thirteen|fourteen|fifteen|sixteen|seventeen|eighteen|nineteen|twenty)|prologue|epilogue)([\s.:-]|$)`)
+)
+
+// Chapter is a heading found in the input text.
+type Chapter struct {
+	Title string
+	Start int // byte offset of the heading line in the text
+}
+
+// parseChapterRegex compiles the -chapter-regex flag, an empty pattern returns nil.
+func parseChapterRegex(pattern string) (*regexp.Regexp, error) {
+	if strings.TrimSpace(pattern) == "" {
+		return nil, nil //nolint:nilnil // no custom pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chapter regex: %w", err)
+	}
+	return re, nil
+}
+
+// detectChapters finds the chapter headings in text. A line is a heading when it is a markdown
+// heading (# Title) or matches custom. When custom has a capture group the first group is the title.
+// A line that starts with "Chapter N" (or Part, Book, Prologue, Epilogue), where N is a number or
+// a numeral, or a short all upper case line of two words or more are only headings when they stand
+// alone between blank lines, prose starts with those words as well.
+func detectChapters(text string, custom *regexp.Regexp) []Chapter {
+	var chapters []Chapter
+	var offset int
+	var lines = strings.SplitAfter(text, "\n")
+	var standsAlone = func(i int) bool {
+		return (i == 0 || strings.TrimSpace(lines[i-1]) == "") && (i == len(lines)-1 || strings.TrimSpace(lines[i+1]) == "")
+	}
+	for i, line := range lines {
+		var start = offset
+		offset += len(line)
+		var trimmed = strings.TrimSpace(line)
+		if trimmed == "" || len(trimmed) > maxChapterTitleLen {
+			continue
+		}
+		start += strings.Index(line, trimmed)
+
+		if custom != nil {
+			if m := custom.FindStringSubmatch(trimmed); m != nil {
+				var title = trimmed
+				if len(m) > 1 && strings.TrimSpace(m[1]) != "" {
+					title = strings.TrimSpace(m[1])
+				}
+				chapters = append(chapters, Chapter{Title: title, Start: start})
+			}
+			continue
+		}
+
+		switch m := markdownHeadingRegex.FindStringSubmatch(trimmed); {
+		case m != nil:
+			chapters = append(chapters, Chapter{Title: m[1], Start: start})
+		case !standsAlone(i):
+			// the first line of a paragraph
+		case chapterHeadingRegex.MatchString(trimmed), isAllCaps(trimmed) && len(strings.Fields(trimmed)) > 1:
+			chapters = append(chapters, Chapter{Title: trimmed, Start: start})
+		}
+	}
+	return chapters
+}
+
+// isAllCaps reports whether line has at least two letters and none of them are lower case.
+func isAllCaps(line string) bool {
+	var letters int
+	for _, r := range line {
+		if unicode.IsLower(r) {
+			return false
+		}
+		if unicode.IsLetter(r) {
+			letters++
+		}
+	}
+	return letters >= 2
+}
+
+// chapterStarts returns when each chapter starts on the timeline, for the chapters that have been
+// synthesized so far.
+func chapterStarts(chapters []Chapter, timeline *Timeline) []time.Duration {
+	var starts []time.Duration
+	for _, c := range chapters {
+		start, ok := timeline.TextTime(c.Start)
+		if !ok {
+			break
+		}
+		starts = append(starts, start)
+	}
+	return starts
+}
+
+// currentChapter returns the index of the chapter playing at position, or -1 before the first chapter.
+func currentChapter(starts []time.Duration, position time.Duration) int {
+	var current = -1
+	for i, start := range starts {
+		if start <= position {
+			current = i
+		}
+	}
+	return current
+}
+
+// chapterMarker is a chapter placed on the timeline of the output file.
+type chapterMarker struct {
+	title      string
+	start, end time.Duration
+	sections   [2]int // the sections the chapter is in, end exclusive, with sectionPerChapter no other chapter shares them
+}
+
+// chapterMarkers places the chapters on the timeline of the synthesized audio. Without chapters
+// every section becomes one, text before the first chapter becomes an introduction.
+func chapterMarkers(chapters []Chapter, timeline *Timeline) []chapterMarker {
+	var sections = timeline.Sections()
+	if len(sections) == 0 {
+		return nil
+	}
+	var markers []chapterMarker
+	if len(chapters) == 0 {
+		for i, s := range sections {
+			markers = append(markers, chapterMarker{title: fmt.Sprintf("Part %d", i+1), start: s.Start, end: s.End, sections: [2]int{i, i + 1}})
+		}
+		return markers
+	}
+
+	if chapters[0].Start > sections[0].TextStart {
+		markers = append(markers, chapterMarker{title: "Introduction"})
+	}
+	for i, start := range chapterStarts(chapters, timeline) {
+		var marker = chapterMarker{title: chapters[i].Title, start: start}
+		for section, s := range sections {
+			if s.TextStart <= chapters[i].Start {
+				marker.sections[0] = section
+			}
+		}
+		markers = append(markers, marker)
+	}
+	// each chapter runs until the next one starts
+	for i := range markers {
+		if i+1 < len(markers) {
+			markers[i].end = markers[i+1].start
+			markers[i].sections[1] = markers[i+1].sections[0]
+			if markers[i+1].start > sections[markers[i+1].sections[0]].Start {
+				// the next chapter starts part way into its section
+				markers[i].sections[1]++
+			}
+		} else {
+			markers[i].end = sections[len(sections)-1].End
+			markers[i].sections[1] = len(sections)
+		}
+	}
+	return markers
+}
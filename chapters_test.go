@@ -0,0 +1,100 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/polly/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectChapters(t *testing.T) {
+	t.Parallel()
+
+	var text = "Preface text.\n\n# The Beginning\nIt was a dark night.\n\nChapter 2\n\nThe Middle part.\n\n  THE END  \n\nDone. NOT A HEADING because it is prose.\n"
+	var chapters = detectChapters(text, nil)
+	assert.Equal(t, []Chapter{
+		{Title: "The Beginning", Start: strings.Index(text, "# The")},
+		{Title: "Chapter 2", Start: strings.Index(text, "Chapter 2")},
+		{Title: "THE END", Start: strings.Index(text, "THE END")},
+	}, chapters)
+
+	// prose that starts like a heading, single words in capitals and headings within a paragraph are not chapters
+	for _, text := range []string{
+		"Intro.\n\nPart of the problem is cost.\n\nMore text.",
+		"Intro.\n\nBook the venue early.\n\nMore text.",
+		"Intro.\n\nNOTE\n\nMore text.",
+		"Intro.\nChapter 3\nMore text.",
+		"Intro.\n\nTHE END\nMore text.",
+	} {
+		assert.Empty(t, detectChapters(text, nil), text)
+	}
+	chapters = detectChapters("Part IV: The Return\n\nText.\n\nChapter twelve\n\nText.\n\nEpilogue\n", nil)
+	assert.Equal(t, []string{"Part IV: The Return", "Chapter twelve", "Epilogue"}, []string{chapters[0].Title, chapters[1].Title, chapters[2].Title})
+
+	// a custom regex replaces the built in rules, its first group is the title
+	chapters = detectChapters("=== One ===\ntext\n# not this\n=== Two ===\ntext", regexp.MustCompile(`^=== (.+) ===$`))
+	assert.Equal(t, []string{"One", "Two"}, []string{chapters[0].Title, chapters[1].Title})
+	assert.Len(t, chapters, 2)
+
+	_, err := parseChapterRegex("(")
+	assert.Error(t, err)
+	re, err := parseChapterRegex("")
+	assert.NoError(t, err)
+	assert.Nil(t, re)
+}
+
+func TestSplitInputChapters(t *testing.T) {
+	t.Parallel()
+
+	// chapters share a section as long as they fit in it
+	var text = "Intro.\n# One\nFirst chapter.\n# Two\nSecond chapter."
+	var chapters = detectChapters(text, nil)
+	assert.Equal(t, []string{text}, splitInput(text, chapters))
+	// unless every chapter needs a file of its own
+	assert.Equal(t, []string{"Intro.", "# One\nFirst chapter.", "# Two\nSecond chapter."}, splitAtChapters(text, chapters))
+
+	// a section that has to be cut is cut where the last chapter in it starts
+	var sentence = "The quick brown fox jumps over the lazy dog. "
+	var half = strings.Repeat(sentence, MAX_CHAR_COUNT/len(sentence)/2)
+	text = "\n# One\n" + half + "\n# Two\n" + half + "\n# Three\nThe end."
+	var sections = splitInput(text, detectChapters(text, nil))
+	assert.Len(t, sections, 2)
+	assert.True(t, strings.HasPrefix(sections[1], "# Two\n"))
+	assert.True(t, strings.HasSuffix(sections[1], "# Three\nThe end."))
+
+	// and at a sentence without one
+	text = "# One\n" + strings.Repeat(sentence, MAX_CHAR_COUNT/len(sentence)+1)
+	sections = splitInput(text, detectChapters(text, nil))
+	assert.Len(t, sections, 2)
+	assert.LessOrEqual(t, len(sections[0]), MAX_CHAR_COUNT)
+	assert.True(t, strings.HasSuffix(sections[0], "lazy dog."))
+}
+
+func TestChapterStarts(t *testing.T) {
+	t.Parallel()
+
+	var chapters = []Chapter{{Title: "One", Start: 0}, {Title: "Two", Start: 50}, {Title: "Three", Start: 150}}
+	var timeline = &Timeline{}
+	assert.Empty(t, chapterStarts(chapters, timeline))
+
+	// without marks a chapter is placed as far into the audio as it is into the text
+	timeline.addSection(nil, 10*time.Second, 0, 0, 100)
+	assert.Equal(t, []time.Duration{0, 5 * time.Second}, chapterStarts(chapters, timeline))
+
+	// with them it starts at the first sentence or word from its heading on
+	timeline.addSection([]SpeechMark{
+		{Time: 11 * time.Second, Type: types.SpeechMarkTypeWord, Start: 120, End: 125, Section: 1},
+		{Time: 16 * time.Second, Type: types.SpeechMarkTypeViseme, Start: 150, End: 150, Section: 1},
+		{Time: 17 * time.Second, Type: types.SpeechMarkTypeWord, Start: 152, End: 157, Section: 1},
+	}, 20*time.Second, 0, 100, 200)
+	var starts = chapterStarts(chapters, timeline)
+	assert.Equal(t, []time.Duration{0, 5 * time.Second, 17 * time.Second}, starts)
+
+	assert.Equal(t, -1, currentChapter(starts, -time.Second))
+	assert.Equal(t, 0, currentChapter(starts, 0))
+	assert.Equal(t, 1, currentChapter(starts, 16*time.Second))
+	assert.Equal(t, 2, currentChapter(starts, time.Minute))
+}
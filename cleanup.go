@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/dustin/go-humanize"
+	log "github.com/sirupsen/logrus"
+)
+
+// pollyOutputKeyRegex matches the object names polly generates for a synthesis task: <task uuid>.<format>
+var pollyOutputKeyRegex = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\.(mp3|ogg|pcm|marks)$`)
+
+// errCleanupPrefix is returned for an empty prefix. The names polly gives its outputs are only uuids,
+// so without a prefix that is used for nothing else any uuid named object in the bucket would match.
+var errCleanupPrefix = errors.New("s3-cleanup needs the -s3-prefix polly writes under, a prefix nothing else writes to")
+
+type cleanupOpts struct {
+	s3Bucket  string
+	s3Prefix  string
+	aws       awsOpts
+	olderThan time.Duration
+	dryRun    bool
+}
+
+// orphanedObject is a polly output left behind in the bucket.
+type orphanedObject struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+func parseCleanupFlags(args []string) cleanupOpts {
+	var opts cleanupOpts
+	var fs = flag.NewFlagSet("s3-cleanup", flag.ExitOnError)
+	fs.Usage = commandUsage(fs, "s3-cleanup")
+	fs.StringVar(&opts.s3Bucket, "bucket", "", "s3 bucket polly writes the mp3 files to")
+	fs.StringVar(&opts.s3Prefix, "s3-prefix", "", "key prefix polly writes under, required and only used by text2speech, e.g. text2speech/")
+	opts.aws.registerFlags(fs)
+	fs.DurationVar(&opts.olderThan, "older-than", 24*time.Hour, "only delete objects older than this")
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "list the objects that would be deleted without deleting them")
+	//nolint:errcheck // ExitOnError
+	fs.Parse(args)
+	if err := applyConfig(fs, "", false); err != nil {
+		log.Fatal(err)
+	}
+
+	if strings.TrimSpace(opts.s3Bucket) == "" {
+		log.Fatal("s3 bucket not spcecified")
+	}
+	if strings.TrimSpace(opts.s3Prefix) == "" {
+		log.Fatal(errCleanupPrefix)
+	}
+	if err := opts.aws.validate(); err != nil {
+		log.Fatal(err)
+	}
+	return opts
+}
+
+// runS3Cleanup is the entry point for the s3-cleanup subcommand. It finds polly outputs left behind by
+// runs that did not make it to the delete in handleOutput and removes the ones older than the threshold.
+func runS3Cleanup(ctx context.Context, args []string) {
+	var opts = parseCleanupFlags(args)
+
+	_, s3Client, err := newAWSClients(ctx, opts.aws)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	objects, err := listOrphanedObjects(ctx, s3Client, opts.s3Bucket, opts.s3Prefix)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var cutoff = time.Now().Add(-opts.olderThan)
+	var expired = printOrphanedObjects(os.Stdout, objects, cutoff, opts.dryRun)
+
+	if opts.dryRun {
+		log.Infof("dry run: %d of %d objects would be deleted", len(expired), len(objects))
+		return
+	}
+	for _, obj := range expired {
+		if err := deleteS3File(ctx, s3Client, opts.s3Bucket, obj.Key); err != nil {
+			log.Fatalf("error deleting %s: %v", obj.Key, err)
+		}
+	}
+	log.Infof("deleted %d of %d objects", len(expired), len(objects))
+}
+
+// listOrphanedObjects lists every object directly under prefix whose name looks like a polly output.
+func listOrphanedObjects(ctx context.Context, s3Client *s3.Client, bucket, prefix string) ([]orphanedObject, error) {
+	if strings.TrimSpace(prefix) == "" {
+		return nil, errCleanupPrefix
+	}
+	var objects []orphanedObject
+	var paginator = s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("s3 list objects: %w", err)
+		}
+		for _, obj := range page.Contents {
+			var key = aws.ToString(obj.Key)
+			if !pollyOutputKeyRegex.MatchString(strings.TrimPrefix(key, prefix)) {
+				continue
+			}
+			objects = append(objects, orphanedObject{
+				Key:          key,
+				Size:         aws.ToInt64(obj.Size),
+				LastModified: aws.ToTime(obj.LastModified),
+			})
+		}
+	}
+	return objects, nil
+}
+
+// printOrphanedObjects writes a table of the objects with their age and size and returns the ones older than cutoff.
+func printOrphanedObjects(w io.Writer, objects []orphanedObject, cutoff time.Time, dryRun bool) []orphanedObject {
+	var expired []orphanedObject
+	var tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tAGE\tSIZE\tACTION")
+	for _, obj := range objects {
+		var action = "keep"
+		if obj.LastModified.Before(cutoff) {
+			expired = append(expired, obj)
+			action = "delete"
+			if dryRun {
+				action = "delete (dry run)"
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", obj.Key, time.Since(obj.LastModified).Round(time.Second), humanize.Bytes(uint64(max(obj.Size, 0))), action)
+	}
+	tw.Flush()
+	return expired
+}
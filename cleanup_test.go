@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListOrphanedObjects(t *testing.T) {
+	var srv = newFakeAWS(t)
+	var old = time.Now().Add(-48 * time.Hour)
+	const id = "0f8fad5b-d9cb-469f-a165-70867728950e"
+	srv.Put("bucket/text2speech/"+id+".mp3", []byte("old"), old)
+	srv.Put("bucket/text2speech/"+id+".marks", []byte("new"), time.Now())
+	// only polly's names directly under the prefix are orphans
+	srv.Put("bucket/text2speech/archive/"+id+".mp3", []byte("kept"), old)
+	srv.Put("bucket/text2speech/notes.mp3", []byte("kept"), old)
+	srv.Put("bucket/"+id+".mp3", []byte("someone else's"), old)
+
+	objects, err := listOrphanedObjects(context.Background(), srv.S3Client(), "bucket", "text2speech/")
+	assert.NoError(t, err)
+	var keys []string
+	for _, obj := range objects {
+		keys = append(keys, obj.Key)
+	}
+	assert.Equal(t, []string{"text2speech/" + id + ".marks", "text2speech/" + id + ".mp3"}, keys)
+
+	var out bytes.Buffer
+	var expired = printOrphanedObjects(&out, objects, time.Now().Add(-24*time.Hour), true)
+	assert.Len(t, expired, 1)
+	assert.Equal(t, "text2speech/"+id+".mp3", expired[0].Key)
+	var lines = strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 3)
+	assert.Contains(t, lines[1], "keep")
+	assert.Contains(t, lines[2], "delete (dry run)")
+
+	// without a prefix every uuid named object in the bucket would match
+	_, err = listOrphanedObjects(context.Background(), srv.S3Client(), "bucket", "")
+	assert.ErrorIs(t, err, errCleanupPrefix)
+}
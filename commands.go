@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/polly"
+	"github.com/aws/aws-sdk-go-v2/service/polly/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	log "github.com/sirupsen/logrus"
+	"go.szostok.io/version"
+	"go.szostok.io/version/printer"
+)
+
+// command is a subcommand as it is listed in the usage.
+type command struct {
+	name        string
+	args        string
+	description string
+}
+
+// commands are the subcommands in the order they are listed, speak is run when none is given.
+var commands = []command{
+	{"speak", "[flags]", "synthesize -input, -url or STDIN and play it, or save it with -output or -output-dir"},
+	{"synth", "[flags]", "synthesize to a file without playing, -output defaults to output.mp3"},
+	{"play", "[flags] <file>", "play an mp3, ogg vorbis, ogg opus or 16 bit pcm wav file through the sink"},
+	{"voices", "[flags]", "list the polly voices, optionally only those of a language or engine"},
+	{"version", "", "print the version"},
+	{"lexicon", "<command> [flags] [args]", "manage pronunciation lexicons in polly, see text2speech lexicon"},
+	{"s3-cleanup", "[flags]", "delete polly outputs left behind in the bucket"},
+	{"help", "[command]", "print this help, or the flags of a command"},
+}
+
+// usage lists the commands.
+var usage = func() string {
+	var b strings.Builder
+	b.WriteString("usage: text2speech [command] [flags]\n\ncommands:\n")
+	var tw = tabwriter.NewWriter(&b, 0, 0, 3, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", c.name, c.args, c.description)
+	}
+	tw.Flush()
+	b.WriteString("\nwithout a command the flags are those of speak, run text2speech help <command> for the flags of a command\n")
+	return b.String()
+}()
+
+// splitCommand returns the command and its arguments. Arguments that start with a flag are the
+// flat invocation from before there were commands and run speak.
+func splitCommand(args []string) (string, []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return "speak", args
+	}
+	return args[0], args[1:]
+}
+
+// commandUsage prints the usage line and description of the named command followed by its flags.
+func commandUsage(fs *flag.FlagSet, name string) func() {
+	return func() {
+		for _, c := range commands {
+			if c.name == name {
+				fmt.Fprintf(fs.Output(), "usage: text2speech %s %s\n\n%s\n\nflags:\n", c.name, c.args, c.description)
+			}
+		}
+		fs.PrintDefaults()
+	}
+}
+
+// runCommand runs the named command with its arguments.
+func runCommand(ctx context.Context, cancel context.CancelFunc, name string, args []string) {
+	switch name {
+	case "speak", "synth":
+		var fs = flag.NewFlagSet(name, flag.ExitOnError)
+		fs.Usage = commandUsage(fs, name)
+		runSpeak(ctx, cancel, parseFlags(fs, args, name == "speak"))
+	case "play":
+		runPlay(args)
+	case "voices":
+		runVoices(ctx, args)
+	case "version":
+		printVersion()
+	case "lexicon":
+		runLexicon(ctx, args)
+	case "s3-cleanup":
+		runS3Cleanup(ctx, args)
+	case "help":
+		runHelp(ctx, cancel, args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %s\n\n%s", name, usage)
+		os.Exit(2)
+	}
+}
+
+// runHelp prints the usage, or the flags of the named command by running it with -h.
+func runHelp(ctx context.Context, cancel context.CancelFunc, args []string) {
+	switch {
+	case len(args) == 0, args[0] == "version", args[0] == "help":
+		fmt.Print(usage)
+	case args[0] == "lexicon":
+		fmt.Print(lexiconCommandUsage)
+	default:
+		runCommand(ctx, cancel, args[0], []string{"-h"})
+	}
+}
+
+// printVersion prints the version, commit and build date of the binary.
+func printVersion() {
+	var verPrinter = printer.New()
+	var info = version.Get()
+	if err := verPrinter.PrintInfo(os.Stdout, info); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runPlay is the entry point for the play subcommand.
+func runPlay(args []string) {
+	var opts sinkOpts
+	var fs = flag.NewFlagSet("play", flag.ExitOnError)
+	fs.Usage = commandUsage(fs, "play")
+	opts.registerFlags(fs)
+	//nolint:errcheck // ExitOnError
+	fs.Parse(args)
+	if err := applyConfig(fs, "", false); err != nil {
+		log.Fatal(err)
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := playFile(fs.Arg(0), opts); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// playFile plays an audio file through the sink the same way synthesized audio is played. wav files
+// are mixed down to mono, the layout of polly's pcm, and other files are read by their extension.
+func playFile(path string, opts sinkOpts) error {
+	//nolint:gosec
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read audio file: %w", err)
+	}
+	var format = audioFormat{output: formatFromExtension(path)}
+	if strings.EqualFold(filepath.Ext(path), ".wav") {
+		wavFormat, pcm, err := readWAV(body)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		format.sampleRate = wavFormat.sampleRate
+		var mono = pcmFormat{sampleRate: wavFormat.sampleRate, channels: 1}
+		if body, err = io.ReadAll(newResampler(bytes.NewReader(pcm), wavFormat, mono)); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if !format.playable() {
+		return fmt.Errorf("%w: %s", errUnsupportedPlayback, format.output)
+	}
+
+	sink, err := newAudioSink(opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := sink.Close(); err != nil {
+			log.Error(err)
+		}
+	}()
+	var audioChan = make(chan *s3.GetObjectOutput, 1)
+	var playbackProgress = make(chan PlaybackProgress)
+	var playErrors = make(chan error)
+	var pauseChan = make(chan bool)
+	defer close(pauseChan)
+	// there is nothing to log besides the progress
+	var logs = make(chan string)
+	close(logs)
+	audioChan <- &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(body))}
+	close(audioChan)
+
+	var done = make(chan struct{})
+	go func() {
+		defer close(done)
+		logOutput(playbackProgress, logs)
+	}()
+	go playWithProgressBar(sink, format, audioChan, playbackProgress, playErrors, pauseChan, nil)
+	err = <-playErrors
+	<-done
+	return err
+}
+
+// runVoices is the entry point for the voices subcommand.
+func runVoices(ctx context.Context, args []string) {
+	var awsFlags awsOpts
+	var language, engine string
+	var fs = flag.NewFlagSet("voices", flag.ExitOnError)
+	fs.Usage = commandUsage(fs, "voices")
+	awsFlags.registerFlags(fs)
+	fs.StringVar(&language, "language", "", "only list the voices that speak this language code, e.g. de-DE")
+	fs.StringVar(&engine, "engine", "", "only list the voices that support this engine: standard, neural, long-form or generative")
+	//nolint:errcheck // ExitOnError
+	fs.Parse(args)
+	if err := applyConfig(fs, "", false); err != nil {
+		log.Fatal(err)
+	}
+	if err := awsFlags.validate(); err != nil {
+		log.Fatal(err)
+	}
+	pollyClient, _, err := newAWSClients(ctx, awsFlags)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := listVoices(ctx, pollyClient, os.Stdout, types.LanguageCode(language), types.Engine(engine)); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// listVoices writes a table of the polly voices, voices that speak the language as an additional
+// language are included.
+func listVoices(ctx context.Context, pollyClient *polly.Client, w io.Writer, language types.LanguageCode, engine types.Engine) error {
+	var tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VOICE\tGENDER\tLANGUAGE\tENGINES")
+	var input = &polly.DescribeVoicesInput{LanguageCode: language, Engine: engine, IncludeAdditionalLanguageCodes: language != ""}
+	for {
+		output, err := pollyClient.DescribeVoices(ctx, input)
+		if err != nil {
+			return fmt.Errorf("polly describe voices: %w", err)
+		}
+		for _, v := range output.Voices {
+			var engines = make([]string, len(v.SupportedEngines))
+			for i, e := range v.SupportedEngines {
+				engines[i] = string(e)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s (%s)\t%s\n", v.Id, v.Gender, v.LanguageCode, aws.ToString(v.LanguageName), strings.Join(engines, ","))
+		}
+		if aws.ToString(output.NextToken) == "" {
+			break
+		}
+		input.NextToken = output.NextToken
+	}
+	return tw.Flush()
+}
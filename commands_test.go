@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kmulvey/text2speech/internal/fakeaws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitCommand(t *testing.T) {
+	t.Parallel()
+
+	for args, expected := range map[string][]string{
+		"":                                {"speak"},
+		"-input notes.txt":                {"speak", "-input", "notes.txt"},
+		"synth -input notes.txt":          {"synth", "-input", "notes.txt"},
+		"play -sink null talk.mp3":        {"play", "-sink", "null", "talk.mp3"},
+		"lexicon upload -name x file.pls": {"lexicon", "upload", "-name", "x", "file.pls"},
+	} {
+		command, rest := splitCommand(strings.Fields(args))
+		assert.Equal(t, expected[0], command, args)
+		assert.Equal(t, expected[1:], append([]string{}, rest...), args)
+	}
+}
+
+func TestPlayFile(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+	var mp3File = filepath.Join(dir, "talk.mp3")
+	assert.NoError(t, os.WriteFile(mp3File, fakeaws.SilentMP3(time.Second, 0), 0o600))
+	assert.NoError(t, playFile(mp3File, sinkOpts{name: "null"}))
+
+	// a stereo wav is played as mono at its own rate
+	var stereo = pcmFormat{sampleRate: 16000, channels: 2}
+	var wav bytes.Buffer
+	assert.NoError(t, writeWAVHeader(&wav, stereo, int64(stereo.bytesPerSecond())))
+	wav.Write(make([]byte, stereo.bytesPerSecond()))
+	var wavFile = filepath.Join(dir, "talk.wav")
+	assert.NoError(t, os.WriteFile(wavFile, wav.Bytes(), 0o600))
+	var recording = filepath.Join(dir, "playback.wav")
+	assert.NoError(t, playFile(wavFile, sinkOpts{name: "wav", file: recording}))
+	played, err := os.ReadFile(recording)
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(1), binary.LittleEndian.Uint16(played[22:24]))
+	assert.Equal(t, uint32(16000), binary.LittleEndian.Uint32(played[24:28]))
+	assert.Len(t, played, 44+16000*2)
+
+	assert.NoError(t, playFile("testdata/vorbis.ogg", sinkOpts{name: "null"}))
+	var oggFile = filepath.Join(dir, "talk.ogg")
+	assert.NoError(t, os.WriteFile(oggFile, []byte("OggS"), 0o600))
+	assert.ErrorIs(t, playFile(oggFile, sinkOpts{name: "null"}), errInvalidOgg)
+
+	var badFile = filepath.Join(dir, "bad.wav")
+	assert.NoError(t, os.WriteFile(badFile, []byte("not a wav"), 0o600))
+	assert.ErrorIs(t, playFile(badFile, sinkOpts{name: "null"}), errInvalidWAV)
+}
+
+func TestListVoices(t *testing.T) {
+	t.Parallel()
+
+	var srv = newFakeAWS(t)
+	var out bytes.Buffer
+	assert.NoError(t, listVoices(context.Background(), srv.PollyClient(), &out, "", ""))
+	assert.Len(t, strings.Split(strings.TrimSpace(out.String()), "\n"), len(fakeaws.Voices)+1)
+	assert.Contains(t, out.String(), "Joanna   Female  en-US (US English)  neural,standard")
+
+	// voices that speak the language as an additional language are included
+	out.Reset()
+	assert.NoError(t, listVoices(context.Background(), srv.PollyClient(), &out, "en-IN", ""))
+	assert.Contains(t, out.String(), "Aditi")
+	assert.NotContains(t, out.String(), "Joanna")
+
+	out.Reset()
+	assert.NoError(t, listVoices(context.Background(), srv.PollyClient(), &out, "de-DE", "neural"))
+	assert.Contains(t, out.String(), "Vicki")
+	assert.NotContains(t, out.String(), "Hans")
+}
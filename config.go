@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// projectConfigFile is read from the current directory, its settings override the user's config file.
+const projectConfigFile = ".text2speech.yaml"
+
+var (
+	errInvalidConfig  = errors.New("invalid config file")
+	errUnknownSetting = errors.New("unknown setting")
+	errUnknownPreset  = errors.New("unknown preset")
+	errInvalidSpeed   = errors.New("speed must be between 20 and 200 percent")
+)
+
+// settings are flag values by flag name.
+type settings map[string]string
+
+// configFile holds the settings of the config files: flag values by flag name, e.g. "bucket: my-bucket",
+// and named presets of them under "presets". A "preset" setting picks the preset used when -preset is not given.
+type configFile struct {
+	settings settings
+	presets  map[string]settings
+}
+
+// configPaths are the config files read in order, later ones override the earlier ones.
+func configPaths() []string {
+	var paths []string
+	if dir, err := os.UserConfigDir(); err == nil {
+		paths = append(paths, filepath.Join(dir, "text2speech", "config.yaml"))
+	}
+	return append(paths, projectConfigFile)
+}
+
+// loadConfig reads and merges the config files that exist.
+func loadConfig(paths ...string) (configFile, error) {
+	var config = configFile{settings: settings{}, presets: map[string]settings{}}
+	for _, path := range paths {
+		file, err := readConfigFile(path)
+		if err != nil {
+			return config, err
+		}
+		maps.Copy(config.settings, file.settings)
+		for name, preset := range file.presets {
+			if config.presets[name] == nil {
+				config.presets[name] = settings{}
+			}
+			maps.Copy(config.presets[name], preset)
+		}
+	}
+	return config, nil
+}
+
+// readConfigFile reads a yaml config file, a file that does not exist has no settings.
+func readConfigFile(path string) (configFile, error) {
+	var config = configFile{settings: settings{}, presets: map[string]settings{}}
+	//nolint:gosec
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return config, fmt.Errorf("cannot read config file: %w", err)
+	}
+	var raw map[string]any
+	if err := yaml.Unmarshal(content, &raw); err != nil {
+		return config, fmt.Errorf("%w %s: %w", errInvalidConfig, path, err)
+	}
+	for name, value := range raw {
+		if name != "presets" {
+			if config.settings[name], err = settingValue(value); err != nil {
+				return config, fmt.Errorf("%w %s: %s %w", errInvalidConfig, path, name, err)
+			}
+			continue
+		}
+		presets, ok := value.(map[string]any)
+		if !ok {
+			return config, fmt.Errorf("%w %s: presets must map names to settings", errInvalidConfig, path)
+		}
+		for preset, values := range presets {
+			values, ok := values.(map[string]any)
+			if !ok {
+				return config, fmt.Errorf("%w %s: preset %s must map flag names to values", errInvalidConfig, path, preset)
+			}
+			config.presets[preset] = settings{}
+			for name, value := range values {
+				if config.presets[preset][name], err = settingValue(value); err != nil {
+					return config, fmt.Errorf("%w %s: preset %s: %s %w", errInvalidConfig, path, preset, name, err)
+				}
+			}
+		}
+	}
+	return config, nil
+}
+
+// settingValue is a yaml value as it would be given on the command line, lists are comma separated.
+func settingValue(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case []any:
+		var items = make([]string, len(v))
+		for i, item := range v {
+			var err error
+			if items[i], err = settingValue(item); err != nil {
+				return "", err
+			}
+		}
+		return strings.Join(items, ","), nil
+	case map[string]any:
+		return "", errors.New("must be a value or a list")
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+// apply sets the flags of fs that were not given on the command line from the preset and then the
+// config's own settings. An empty preset uses the config's preset setting, if any. The names of
+// settings fs has no flag for are returned.
+func (c configFile) apply(fs *flag.FlagSet, preset string) ([]string, error) {
+	var values = maps.Clone(c.settings)
+	if preset == "" {
+		preset = values["preset"]
+	}
+	delete(values, "preset")
+	if preset != "" {
+		var p, ok = c.presets[preset]
+		if !ok {
+			return nil, fmt.Errorf("%w %s, the config files have %v", errUnknownPreset, preset, slices.Sorted(maps.Keys(c.presets)))
+		}
+		maps.Copy(values, p)
+	}
+
+	var given = make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	var unknown []string
+	for _, name := range slices.Sorted(maps.Keys(values)) {
+		switch {
+		case given[name]:
+		case fs.Lookup(name) == nil:
+			unknown = append(unknown, name)
+		default:
+			if err := fs.Set(name, values[name]); err != nil {
+				return nil, fmt.Errorf("%w: %s: %w", errInvalidConfig, name, err)
+			}
+		}
+	}
+	return unknown, nil
+}
+
+// applyConfig loads the config files and applies them to the flags of fs not given on the command
+// line. The main flags must know every setting, other commands only pick out the ones they have.
+func applyConfig(fs *flag.FlagSet, preset string, strict bool) error {
+	config, err := loadConfig(configPaths()...)
+	if err != nil {
+		return err
+	}
+	unknown, err := config.apply(fs, preset)
+	if err != nil {
+		return err
+	}
+	if strict && len(unknown) > 0 {
+		return fmt.Errorf("%w in the config files: %s", errUnknownSetting, strings.Join(unknown, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/kmulvey/text2speech/internal/fakeaws"
+	"github.com/stretchr/testify/assert"
+)
+
+const userConfig = `bucket: my-bucket
+profile: work
+region: eu-west-1
+voice: Joanna
+presets:
+  podcast:
+    voice: Matthew
+    engine: neural
+    format: mp3
+    speed: 110
+    output: episode.mp3
+  notes:
+    voice: Amy
+`
+
+const projectConfig = `bucket: project-bucket
+speech-marks: [word, sentence]
+presets:
+  podcast:
+    output: project.mp3
+`
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	var path = filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+// testFlagSet has the flags the config tests set.
+func testFlagSet(args ...string) (*flag.FlagSet, map[string]*string) {
+	var fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	var values = make(map[string]*string)
+	for _, name := range []string{"bucket", "profile", "region", "voice", "engine", "format", "output", "speech-marks"} {
+		values[name] = fs.String(name, "", "")
+	}
+	fs.Int("speed", 100, "")
+	//nolint:errcheck // ContinueOnError, the args are valid
+	fs.Parse(args)
+	return fs, values
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Parallel()
+
+	config, err := loadConfig(writeConfig(t, userConfig), filepath.Join(t.TempDir(), "missing.yaml"), writeConfig(t, projectConfig))
+	assert.NoError(t, err)
+	assert.Equal(t, settings{"bucket": "project-bucket", "profile": "work", "region": "eu-west-1", "voice": "Joanna", "speech-marks": "word,sentence"}, config.settings)
+	assert.Equal(t, settings{"voice": "Matthew", "engine": "neural", "format": "mp3", "speed": "110", "output": "project.mp3"}, config.presets["podcast"])
+
+	for content, problem := range map[string]string{
+		"bucket: [":                     "yaml",
+		"presets: podcast":              "presets must map names to settings",
+		"presets:\n  podcast: neural":   "preset podcast must map flag names to values",
+		"aws:\n  profile: work":         "aws must be a value or a list",
+		"presets:\n  p:\n    a: {b: c}": "preset p: a must be a value or a list",
+	} {
+		_, err = readConfigFile(writeConfig(t, content))
+		assert.ErrorIs(t, err, errInvalidConfig, content)
+		assert.ErrorContains(t, err, problem, content)
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	t.Parallel()
+
+	config, err := loadConfig(writeConfig(t, userConfig), writeConfig(t, projectConfig))
+	assert.NoError(t, err)
+
+	// flags on the command line win over the preset, which wins over the files' own settings
+	var fs, values = testFlagSet("-voice", "Ivy")
+	unknown, err := config.apply(fs, "podcast")
+	assert.NoError(t, err)
+	assert.Empty(t, unknown)
+	assert.Equal(t, "Ivy", *values["voice"])
+	assert.Equal(t, "project-bucket", *values["bucket"])
+	assert.Equal(t, "neural", *values["engine"])
+	assert.Equal(t, "project.mp3", *values["output"])
+	assert.Equal(t, "110", fs.Lookup("speed").Value.String())
+
+	// the preset setting picks the default preset
+	config.settings["preset"] = "notes"
+	fs, values = testFlagSet()
+	_, err = config.apply(fs, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "Amy", *values["voice"])
+
+	_, err = config.apply(fs, "audiobook")
+	assert.ErrorIs(t, err, errUnknownPreset)
+
+	// settings without a flag are reported
+	config.settings["older-than"] = "1h"
+	unknown, err = config.apply(flag.NewFlagSet("empty", flag.ContinueOnError), "")
+	assert.NoError(t, err)
+	assert.Contains(t, unknown, "older-than")
+
+	// values are checked by their flag
+	config.settings["speed"] = "fast"
+	fs, _ = testFlagSet()
+	_, err = config.apply(fs, "")
+	assert.ErrorIs(t, err, errInvalidConfig)
+}
+
+func TestHandleOutputEngineAndSpeed(t *testing.T) {
+	var srv = newFakeAWS(t)
+	srv.Script(fakeaws.Task{Duration: time.Second})
+
+	var synth = synthesisOpts{bucket: "bucket", voiceID: "Matthew", engine: "neural", rate: 110}
+	assert.NoError(t, handleOutput(context.Background(), srv.PollyClient(), srv.S3Client(), make(chan *s3.GetObjectOutput, 5), make(chan string, 100), synth, document{text: "Welcome to the show."}, filepath.Join(t.TempDir(), "episode.mp3"), nil))
+
+	var started = srv.Started()
+	assert.Len(t, started, 1)
+	assert.Equal(t, "neural", started[0].Engine)
+	assert.Equal(t, "ssml", started[0].TextType)
+	assert.Equal(t, `<speak><prosody rate="110%">Welcome to the show.</prosody></speak>`, started[0].Text)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/polly"
+	"github.com/aws/aws-sdk-go-v2/service/polly/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// maxCueRate is the fastest -fit-cues speeds up speech, as a percentage of normal speed.
+const maxCueRate = 150
+
+var (
+	errInvalidCue   = errors.New("invalid subtitle cue")
+	errCuesNeedPCM  = errors.New("subtitle input is assembled from pcm and not encoded to mp3 or ogg, play it or write it to a .wav file")
+	cueTimingRegex  = regexp.MustCompile(`^\s*(\S+)\s+-->\s+(\S+)`)
+	cueTimeRegex    = regexp.MustCompile(`^(?:(\d+):)?(\d{1,2}):(\d{2})[,.](\d{1,3})$`)
+	cueTagRegex     = regexp.MustCompile(`<[^>]*>|\{\\[^}]*\}`) // html style and ass style ({\an8}) tags
+	srtStartRegex   = regexp.MustCompile(`^\d+\s*\r?\n[^\n]*-->`)
+	vttHeaderRegex  = regexp.MustCompile(`^WEBVTT(\s|$)`)
+	vttSkippedBlock = regexp.MustCompile(`^(NOTE|STYLE|REGION)(\s|$)`)
+	blankLineRegex  = regexp.MustCompile(`\n\s*\n`)
+)
+
+// isSubtitles reports whether raw starts like an srt or vtt file.
+func isSubtitles(raw string) bool {
+	raw = strings.TrimPrefix(strings.TrimSpace(raw), "\ufeff")
+	return vttHeaderRegex.MatchString(raw) || srtStartRegex.MatchString(raw)
+}
+
+// parseCues reads the cues of an srt or vtt file in order of their start time. Styling tags are
+// removed and the lines of a cue are joined, cues without text are dropped.
+func parseCues(raw string) ([]cue, error) {
+	raw = strings.ReplaceAll(strings.TrimPrefix(raw, "\ufeff"), "\r\n", "\n")
+	var vtt = vttHeaderRegex.MatchString(raw)
+	var cues []cue
+	for i, block := range blankLineRegex.Split(strings.TrimSpace(raw), -1) {
+		if vtt && (i == 0 || vttSkippedBlock.MatchString(block)) {
+			continue
+		}
+		var lines = strings.Split(block, "\n")
+		// the timing line follows an optional index (srt) or identifier (vtt)
+		var timing = slices.IndexFunc(lines, func(line string) bool { return strings.Contains(line, "-->") })
+		if timing < 0 || timing > 1 {
+			return nil, fmt.Errorf("%w: no timing in %q", errInvalidCue, block)
+		}
+		var m = cueTimingRegex.FindStringSubmatch(lines[timing])
+		if m == nil {
+			return nil, fmt.Errorf("%w: %q", errInvalidCue, lines[timing])
+		}
+		start, err := parseCueTime(m[1])
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseCueTime(m[2])
+		if err != nil {
+			return nil, err
+		}
+		if end <= start {
+			return nil, fmt.Errorf("%w: %q ends before it starts", errInvalidCue, lines[timing])
+		}
+
+		var text = collapseSpace(html.UnescapeString(cueTagRegex.ReplaceAllString(strings.Join(lines[timing+1:], " "), "")))
+		if text != "" {
+			cues = append(cues, cue{start: start, end: end, text: text})
+		}
+	}
+	slices.SortStableFunc(cues, func(a, b cue) int { return int(a.start - b.start) })
+	return cues, nil
+}
+
+// parseCueTime parses hh:mm:ss,mmm (srt) or [hh:]mm:ss.mmm (vtt).
+func parseCueTime(value string) (time.Duration, error) {
+	var m = cueTimeRegex.FindStringSubmatch(value)
+	if m == nil {
+		return 0, fmt.Errorf("%w: timestamp %q", errInvalidCue, value)
+	}
+	var parts [4]time.Duration
+	for i, part := range m[1:] {
+		var n int
+		for _, digit := range part {
+			n = n*10 + int(digit-'0')
+		}
+		parts[i] = time.Duration(n)
+	}
+	// a fraction of one or two digits is tenths or hundredths
+	for range 3 - len(m[4]) {
+		parts[3] *= 10
+	}
+	return parts[0]*time.Hour + parts[1]*time.Minute + parts[2]*time.Second + parts[3]*time.Millisecond, nil
+}
+
+// subtitleDocument reads subtitles as a document of their cues, a paragraph each.
+func subtitleDocument(raw string) (document, error) {
+	cues, err := parseCues(raw)
+	if err != nil {
+		return document{}, err
+	}
+	var texts = make([]string, len(cues))
+	for i, c := range cues {
+		texts[i] = c.text
+	}
+	return document{text: strings.Join(texts, "\n\n"), cues: cues}, nil
+}
+
+// cueFormat is the format subtitle input is synthesized in. The cues are padded with silence, which
+// takes raw pcm, so playing switches to it and saving needs a .wav file.
+func cueFormat(opts cliOpts) (audioFormat, error) {
+	switch {
+	case opts.format.output == types.OutputFormatPcm:
+		return opts.format, nil
+	case opts.playing():
+		return audioFormat{output: types.OutputFormatPcm}, nil
+	default:
+		return opts.format, errCuesNeedPCM
+	}
+}
+
+// timeCue places the synthesized audio of a cue at the cue's start on the track, position is where
+// the track ends so far. The gap is filled with silence. Speech that runs past the end of the cue
+// is synthesized again faster with -fit-cues, what still overruns is reported and pushes back the
+// cues after it.
+func timeCue(ctx context.Context, pollyClient *polly.Client, s3Client *s3.Client, logs chan string, synth synthesisOpts, c cue, index int, text string, position time.Duration, audio sectionAudio) (sectionAudio, error) {
+	if synth.format.output != types.OutputFormatPcm {
+		return audio, errCuesNeedPCM
+	}
+	body, length, err := readAudio(audio.voice, synth.format)
+	audio.voice.Body.Close()
+	if err != nil {
+		return audio, err
+	}
+
+	var slot = c.end - c.start
+	if length > slot && synth.fitCues {
+		var speed = 100
+		if synth.rate > 0 {
+			speed = synth.rate
+		}
+		var rate = min(int(math.Ceil(float64(length)*float64(speed)/float64(slot))), maxCueRate)
+		logs <- fmt.Sprintf("Cue %d is %s long for its %s, reading it at %d%% speed \n", index+1, length.Round(time.Millisecond), slot, rate)
+		faster, err := synthesizeSection(ctx, pollyClient, s3Client, logs, synth, text, nil, rate)
+		if err != nil {
+			return audio, fmt.Errorf("error from synthesisText: %w", err)
+		}
+		if err := cleanupS3Files(ctx, s3Client, logs, synth, audio.keys...); err != nil {
+			return audio, err
+		}
+		audio = faster
+		body, length, err = readAudio(audio.voice, synth.format)
+		audio.voice.Body.Close()
+		if err != nil {
+			return audio, err
+		}
+	}
+	if length > slot {
+		logs <- fmt.Sprintf("WARNING: cue %d at %s overruns its slot by %s \n", index+1, subtitleTimestamp(c.start, ','), (length - slot).Round(time.Millisecond))
+	}
+	if position > c.start {
+		logs <- fmt.Sprintf("WARNING: cue %d starts %s late, after the cue before it \n", index+1, (position - c.start).Round(time.Millisecond))
+	}
+
+	audio.lead = max(c.start-position, 0)
+	var silence = make([]byte, pcmSize(audio.lead, synth.format.pcmFormat()))
+	audio.voice.Body = io.NopCloser(io.MultiReader(bytes.NewReader(silence), bytes.NewReader(body)))
+	return audio, nil
+}
+
+// pcmSize is the size of d of pcm audio, whole frames only.
+func pcmSize(d time.Duration, format pcmFormat) int {
+	var frames = int(d * time.Duration(format.sampleRate) / time.Second)
+	return frames * format.channels * 2
+}
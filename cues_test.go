@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/polly/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/kmulvey/text2speech/internal/fakeaws"
+	"github.com/stretchr/testify/assert"
+)
+
+const srtInput = "1\r\n00:00:01,000 --> 00:00:03,000\r\nHello <i>there</i>.\r\n\r\n" +
+	"2\r\n00:00:05,000 --> 00:00:06,000 X1:40\r\n{\\an8}This one\r\nruns long.\r\n\r\n" +
+	"3\r\n00:00:06,500 --> 00:00:08,000\r\nLate &amp; fast.\r\n"
+
+func TestParseCues(t *testing.T) {
+	t.Parallel()
+
+	cues, err := parseCues(srtInput)
+	assert.NoError(t, err)
+	assert.Equal(t, []cue{
+		{start: time.Second, end: 3 * time.Second, text: "Hello there."},
+		{start: 5 * time.Second, end: 6 * time.Second, text: "This one runs long."},
+		{start: 6500 * time.Millisecond, end: 8 * time.Second, text: "Late & fast."},
+	}, cues)
+
+	var vtt = "WEBVTT - dubbing\n\nNOTE written by hand\n\nSTYLE\n::cue { color: red }\n\n" +
+		"intro\n00:01.5 --> 00:02.000 align:start\n<v Bob>Hi!</v>\n\n" +
+		"01:00:00.000 --> 01:00:01.000\n\n\n00:00.000 --> 00:00.500\nFirst."
+	cues, err = parseCues(vtt)
+	assert.NoError(t, err)
+	// empty cues are dropped and the rest sorted by start
+	assert.Equal(t, []cue{
+		{start: 0, end: 500 * time.Millisecond, text: "First."},
+		{start: 1500 * time.Millisecond, end: 2 * time.Second, text: "Hi!"},
+	}, cues)
+
+	_, err = parseCues("1\n00:00:02,000 --> 00:00:01,000\nBackwards.")
+	assert.ErrorIs(t, err, errInvalidCue)
+	_, err = parseCues("1\n2\n3\n00:00:01,000 --> 00:00:02,000\nMisplaced.")
+	assert.ErrorIs(t, err, errInvalidCue)
+
+	assert.True(t, isSubtitles(srtInput))
+	assert.True(t, isSubtitles("\ufeffWEBVTT\n"))
+	assert.False(t, isSubtitles("1984 was a year.\nIt came --> and went."))
+}
+
+func TestHandleOutputCues(t *testing.T) {
+	for _, fit := range []bool{false, true} {
+		var srv = newFakeAWS(t)
+		srv.Script(fakeaws.Task{Duration: time.Second}, fakeaws.Task{Duration: 2 * time.Second}, fakeaws.Task{Duration: time.Second})
+		if fit {
+			// the overrunning cue is read again faster
+			srv.Script(fakeaws.Task{Duration: time.Second})
+		}
+
+		doc, err := parseDocument(srtInput, "talk.srt", documentOpts{format: "auto", chapters: true})
+		assert.NoError(t, err)
+		var timeline = &Timeline{}
+		var logs = make(chan string, 100)
+		var outputFile = filepath.Join(t.TempDir(), "dub.wav")
+		var synth = synthesisOpts{bucket: "bucket", voiceID: "Matthew", format: audioFormat{output: types.OutputFormatPcm}, fitCues: fit, marks: []types.SpeechMarkType{types.SpeechMarkTypeWord}}
+		assert.NoError(t, handleOutput(context.Background(), srv.PollyClient(), srv.S3Client(), make(chan *s3.GetObjectOutput, 5), logs, synth, doc, outputFile, timeline))
+
+		var messages strings.Builder
+		for msg := range logs {
+			messages.WriteString(msg)
+		}
+		// each cue starts at its time, unless the one before it ran over
+		var starts []time.Duration
+		for _, w := range timeline.Marks(types.SpeechMarkTypeWord) {
+			if w.Value == "Hello" || w.Value == "This" || w.Value == "Late" {
+				starts = append(starts, w.Time)
+			}
+		}
+		info, err := os.Stat(outputFile)
+		assert.NoError(t, err)
+		if fit {
+			assert.Equal(t, []time.Duration{time.Second, 5 * time.Second, 6500 * time.Millisecond}, starts)
+			assert.Equal(t, 7500*time.Millisecond, timeline.Duration())
+			assert.Contains(t, messages.String(), "at 150% speed")
+			var started = srv.Started()
+			assert.Contains(t, started[len(started)-3].Text+started[len(started)-4].Text, `<prosody rate="150%">This one runs long.</prosody>`)
+			assert.Len(t, srv.Objects(), 0)
+		} else {
+			assert.Equal(t, []time.Duration{time.Second, 5 * time.Second, 7 * time.Second}, starts)
+			assert.Equal(t, 8*time.Second, timeline.Duration())
+			assert.Contains(t, messages.String(), "WARNING: cue 2 at 00:00:05,000 overruns its slot by 1s")
+			assert.Contains(t, messages.String(), "WARNING: cue 3 starts 500ms late")
+		}
+		assert.Equal(t, int64(44+pcmSize(timeline.Duration(), synth.format.pcmFormat())), info.Size())
+	}
+}
+
+func TestHandleOutputCuesPlay(t *testing.T) {
+	var srv = newFakeAWS(t)
+	srv.Script(fakeaws.Task{Duration: time.Second}, fakeaws.Task{Duration: 2 * time.Second}, fakeaws.Task{Duration: time.Second})
+
+	doc, err := parseDocument(srtInput, "talk.srt", documentOpts{format: "auto", chapters: true})
+	assert.NoError(t, err)
+	// played without speech marks the cues are still placed at their time
+	var synth = synthesisOpts{bucket: "bucket", voiceID: "Matthew", format: audioFormat{output: types.OutputFormatPcm}}
+	var audioChan = make(chan *s3.GetObjectOutput, 5)
+	assert.NoError(t, handleOutput(context.Background(), srv.PollyClient(), srv.S3Client(), audioChan, make(chan string, 100), synth, doc, DEFAULT_OUTPUT, &Timeline{}))
+
+	var played int
+	for voice := range audioChan {
+		body, err := io.ReadAll(voice.Body)
+		assert.NoError(t, err)
+		played += len(body)
+	}
+	assert.Equal(t, pcmSize(8*time.Second, synth.format.pcmFormat()), played)
+}
+
+func TestCueFormat(t *testing.T) {
+	t.Parallel()
+
+	format, err := cueFormat(cliOpts{outputFile: DEFAULT_OUTPUT, format: audioFormat{output: types.OutputFormatMp3}})
+	assert.NoError(t, err)
+	assert.Equal(t, types.OutputFormatPcm, format.output)
+	_, err = cueFormat(cliOpts{outputFile: "dub.mp3", format: audioFormat{output: types.OutputFormatMp3}})
+	assert.ErrorIs(t, err, errCuesNeedPCM)
+}
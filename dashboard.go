@@ -5,6 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/service/polly/types"
 
 	"github.com/charmbracelet/bubbles/progress"
 	tea "github.com/charmbracelet/bubbletea"
@@ -16,8 +21,22 @@ var (
 	hintStyle   = lipgloss.NewStyle().Faint(true)
 	pausedStyle = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("11"))
 	logStyle    = lipgloss.NewStyle().Faint(true)
+	// text pane
+	textStyle     = lipgloss.NewStyle().Faint(true)
+	sentenceStyle = lipgloss.NewStyle()
+	wordStyle     = lipgloss.NewStyle().Bold(true).Reverse(true)
 )
 
+// textPaneLines is how many lines of the source text the dashboard shows around the current sentence.
+const textPaneLines = 6
+
+// chapterListLines is how many chapter titles the dashboard lists around the current chapter.
+const chapterListLines = 5
+
+// restartChapterAfter is how far into a chapter the previous chapter key restarts it instead of
+// going back to the one before.
+const restartChapterAfter = 3 // seconds
+
 // bubbletea message types
 type progressMsg PlaybackProgress
 type logMsg string
@@ -33,8 +52,15 @@ type model struct {
 	pauseChan    chan<- bool
 	progressCh   <-chan PlaybackProgress
 	logsCh       <-chan string
-	width        int
 	cancel       context.CancelFunc
+	position     time.Duration // exact playback position, where the highlighting is taken from
+	timeline     *Timeline
+	text         string
+	lines        []textLine // text wrapped to the window, wrapped again when it is resized
+	chapters     []Chapter
+	starts       []time.Duration // when the chapters synthesized so far start
+	placed       int             // timeline sections the starts were placed with
+	seekChan     chan<- time.Duration
 }
 
 func waitForProgress(ch <-chan PlaybackProgress) tea.Cmd {
@@ -67,8 +93,8 @@ func (m model) Init() tea.Cmd {
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
-		m.width = msg.Width
 		m.progress.Width = msg.Width - 4
+		m.lines = wrapText(m.text, textPaneWidth(msg.Width))
 		return m, nil
 
 	case tea.KeyMsg:
@@ -78,6 +104,20 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.paused = !m.paused
 				m.pauseChan <- m.paused
 			}
+		case "n", "N":
+			if next := currentChapter(m.starts, m.position) + 1; next < len(m.starts) {
+				m = m.seek(m.starts[next])
+			}
+		case "p", "P":
+			var chapter = currentChapter(m.starts, m.position)
+			switch {
+			case chapter < 0:
+				m = m.seek(0)
+			case m.position-m.starts[chapter] >= restartChapterAfter*time.Second || chapter == 0:
+				m = m.seek(m.starts[chapter])
+			default:
+				m = m.seek(m.starts[chapter-1])
+			}
 		case "q", "Q", "ctrl+c":
 			m.cancel()
 			return m, tea.Quit
@@ -86,6 +126,11 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case progressMsg:
 		m.grandElapsed = msg.GrandElapsed
 		m.grandTotal = msg.GrandTotal
+		m.position = msg.Position
+		// chapters are placed as their sections are synthesized
+		if placed := len(m.timeline.Sections()); placed != m.placed {
+			m.starts, m.placed = chapterStarts(m.chapters, m.timeline), placed
+		}
 		var pct float64
 		if m.grandTotal > 0 {
 			pct = float64(m.grandElapsed) / float64(m.grandTotal)
@@ -130,9 +175,12 @@ func (m model) View() string {
 	}
 
 	var hint string
-	if m.grandTotal == 0 {
+	switch {
+	case m.grandTotal == 0:
 		hint = hintStyle.Render("synthesizing...   [Q] quit")
-	} else {
+	case len(m.chapters) > 0:
+		hint = hintStyle.Render("[SPACE] pause/resume   [N]/[P] next/previous chapter   [Q] quit")
+	default:
 		hint = hintStyle.Render("[SPACE] pause/resume   [Q] quit")
 	}
 	header := titleStyle.Render("text2speech") + "  " + hint
@@ -147,12 +195,169 @@ func (m model) View() string {
 		logBuf.WriteString(l)
 	}
 
+	var pane string
+	if chapters := m.chapterList(); chapters != "" {
+		pane = chapters + "\n\n"
+	}
+	if text := m.textPane(); text != "" {
+		pane += text + "\n\n"
+	}
+
 	return "\n" + header + "\n\n" +
 		m.progress.View() + "\n" +
 		timeStr + "\n\n" +
+		pane +
 		logStyle.Render(logBuf.String())
 }
 
+// seek asks the player to jump to position, playback resumes if it was paused.
+func (m model) seek(position time.Duration) model {
+	select {
+	case m.seekChan <- position:
+	default:
+		// a seek is already waiting to be picked up
+		return m
+	}
+	if m.paused {
+		m.paused = false
+		m.pauseChan <- false
+	}
+	return m
+}
+
+// chapterList renders the title of the current chapter followed by the chapters around it.
+func (m model) chapterList() string {
+	if len(m.chapters) == 0 {
+		return ""
+	}
+	var current = currentChapter(m.starts, m.position)
+	var list strings.Builder
+	if current >= 0 {
+		list.WriteString(titleStyle.Render(fmt.Sprintf("Chapter %d/%d: %s", current+1, len(m.chapters), m.chapters[current].Title)))
+	} else {
+		list.WriteString(titleStyle.Render(fmt.Sprintf("%d chapters", len(m.chapters))))
+	}
+	var first = max(min(current-chapterListLines/2, len(m.chapters)-chapterListLines), 0)
+	for i := first; i < min(first+chapterListLines, len(m.chapters)); i++ {
+		if i == current {
+			list.WriteString("\n" + sentenceStyle.Render("> "+m.chapters[i].Title))
+		} else {
+			list.WriteString("\n" + textStyle.Render("  "+m.chapters[i].Title))
+		}
+	}
+	return list.String()
+}
+
+// textPane renders the lines of the source text around the sentence being spoken with that
+// sentence and the current word highlighted. Everything is looked up from the playback position
+// so the highlighting stops when playback is paused and jumps along with it. It is empty until
+// the first speech marks arrive.
+func (m model) textPane() string {
+	sentence, ok := m.timeline.At(m.position, types.SpeechMarkTypeSentence)
+	if !ok {
+		return ""
+	}
+	word, ok := m.timeline.At(m.position, types.SpeechMarkTypeWord)
+	if !ok || word.Start < sentence.Start || word.End > sentence.End {
+		word = SpeechMark{Start: -1, End: -1}
+	}
+
+	var lines = m.lines
+	var current = 0
+	for i, l := range lines {
+		if l.start <= sentence.Start {
+			current = i
+		}
+	}
+	// keep a line of context above the current sentence
+	var first = max(min(current-1, len(lines)-textPaneLines), 0)
+
+	var styles = []lipgloss.Style{textStyle, sentenceStyle, wordStyle}
+	var styleAt = func(offset int) int {
+		switch {
+		case offset >= word.Start && offset < word.End:
+			return 2
+		case offset >= sentence.Start && offset < sentence.End:
+			return 1
+		default:
+			return 0
+		}
+	}
+	var pane strings.Builder
+	for i, l := range lines[first:min(first+textPaneLines, len(lines))] {
+		if i > 0 {
+			pane.WriteString("\n")
+		}
+		// render runs of text that share a style together
+		for start := l.start; start < l.end; {
+			var style = styleAt(start)
+			var end = start + 1
+			for end < l.end && styleAt(end) == style {
+				end++
+			}
+			pane.WriteString(styles[style].Render(m.text[start:end]))
+			start = end
+		}
+	}
+	return pane.String()
+}
+
+// textPaneWidth is how wide the lines of the text pane are in a window of the width.
+func textPaneWidth(windowWidth int) int {
+	if windowWidth <= 4 {
+		return 76
+	}
+	return windowWidth - 4
+}
+
+// textLine is a line of wrapped text, as byte offsets into the text.
+type textLine struct {
+	start, end int
+}
+
+// wrapText wraps text at spaces to lines of at most width characters, longer words get a line to
+// themselves. Line breaks in the text are kept, blank lines are dropped.
+func wrapText(text string, width int) []textLine {
+	var lines []textLine
+	var offset int
+	for _, paragraph := range strings.SplitAfter(text, "\n") {
+		var line = textLine{start: -1}
+		var lineWidth int
+		for start, end := nextField(paragraph, 0); start < len(paragraph); start, end = nextField(paragraph, end) {
+			var wordWidth = utf8.RuneCountInString(paragraph[start:end])
+			if line.start >= 0 && lineWidth+1+wordWidth > width {
+				lines = append(lines, line)
+				line.start = -1
+			}
+			if line.start < 0 {
+				line.start, lineWidth = offset+start, wordWidth
+			} else {
+				lineWidth += 1 + wordWidth
+			}
+			line.end = offset + end
+		}
+		if line.start >= 0 {
+			lines = append(lines, line)
+		}
+		offset += len(paragraph)
+	}
+	return lines
+}
+
+// nextField returns the byte range of the next whitespace separated field at or after from.
+func nextField(text string, from int) (int, int) {
+	var start = strings.IndexFunc(text[from:], func(r rune) bool { return !unicode.IsSpace(r) })
+	if start < 0 {
+		return len(text), len(text)
+	}
+	start += from
+	var end = strings.IndexFunc(text[start:], unicode.IsSpace)
+	if end < 0 {
+		return start, len(text)
+	}
+	return start, start + end
+}
+
 func formatDuration(seconds int) string {
 	m := seconds / 60
 	s := seconds % 60
@@ -160,9 +365,15 @@ func formatDuration(seconds int) string {
 }
 
 // NewDashboard creates and runs the bubbletea TUI. It blocks until the user
-// quits or playback completes.
-func NewDashboard(ctx context.Context, cancel context.CancelFunc, playbackProgress <-chan PlaybackProgress, logs <-chan string, pauseChan chan<- bool) error {
+// quits or playback completes. The text pane follows the speech marks added to timeline and the
+// chapter keys send where each chapter starts on the timeline on seekChan.
+func NewDashboard(ctx context.Context, cancel context.CancelFunc, playbackProgress <-chan PlaybackProgress, logs <-chan string, pauseChan chan<- bool, seekChan chan<- time.Duration, timeline *Timeline, text string, chapters []Chapter) error {
 	m := model{
+		timeline:   timeline,
+		text:       text,
+		lines:      wrapText(text, textPaneWidth(0)),
+		chapters:   chapters,
+		seekChan:   seekChan,
 		progress:   progress.New(progress.WithDefaultGradient()),
 		progressCh: playbackProgress,
 		logsCh:     logs,
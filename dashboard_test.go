@@ -0,0 +1,119 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/polly/types"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrapText(t *testing.T) {
+	t.Parallel()
+
+	var text = "one two three four\n\nfive  sixsixsixsix"
+	var lines = wrapText(text, 9)
+	var got = make([]string, len(lines))
+	for i, l := range lines {
+		got[i] = text[l.start:l.end]
+	}
+	assert.Equal(t, []string{"one two", "three", "four", "five", "sixsixsixsix"}, got)
+}
+
+func TestTextPane(t *testing.T) {
+	t.Parallel()
+
+	var sentences []string
+	for i := range 20 {
+		sentences = append(sentences, "Sentence number "+strings.Repeat("x", i)+".")
+	}
+	var text = strings.Join(sentences, "\n")
+	var timeline = &Timeline{}
+	var marks []SpeechMark
+	var offset int
+	for i, s := range sentences {
+		marks = append(marks,
+			SpeechMark{Time: time.Duration(i) * time.Second, Type: types.SpeechMarkTypeSentence, Start: offset, End: offset + len(s), Value: s},
+			SpeechMark{Time: time.Duration(i) * time.Second, Type: types.SpeechMarkTypeWord, Start: offset, End: offset + len("Sentence"), Value: "Sentence"},
+		)
+		offset += len(s) + 1
+	}
+
+	var updated, _ = model{timeline: timeline, text: text}.Update(tea.WindowSizeMsg{Width: 80})
+	var m = updated.(model)
+	// nothing to show until speech marks arrive
+	assert.Empty(t, m.textPane())
+
+	timeline.addSection(marks, 20*time.Second, 0, 0, len(text))
+	var pane = strings.Split(m.textPane(), "\n")
+	assert.Len(t, pane, textPaneLines)
+	assert.Equal(t, sentences[0], pane[0])
+
+	// the pane follows the playback position, forwards and backwards, with a line of context above
+	m.position = 10*time.Second + 500*time.Millisecond
+	pane = strings.Split(m.textPane(), "\n")
+	assert.Equal(t, sentences[9:9+textPaneLines], pane)
+
+	m.position = 19 * time.Second
+	pane = strings.Split(m.textPane(), "\n")
+	assert.Equal(t, sentences[len(sentences)-textPaneLines:], pane)
+
+	m.position = time.Second
+	pane = strings.Split(m.textPane(), "\n")
+	assert.Equal(t, sentences[:textPaneLines], pane)
+
+	// the text is only wrapped again when the window is resized
+	updated, _ = m.Update(tea.WindowSizeMsg{Width: 12})
+	assert.Equal(t, wrapText(text, 8), updated.(model).lines)
+}
+
+func TestDashboardChapterKeys(t *testing.T) {
+	t.Parallel()
+
+	var seekChan = make(chan time.Duration, 1)
+	var pauseChan = make(chan bool, 1)
+	var timeline = &Timeline{}
+	var m tea.Model = model{
+		timeline:   timeline,
+		chapters:   []Chapter{{Title: "One", Start: 50}, {Title: "Two", Start: 100}, {Title: "Three", Start: 150}},
+		seekChan:   seekChan,
+		pauseChan:  pauseChan,
+		paused:     true,
+		grandTotal: 10,
+	}
+	var key = func(k string) {
+		m, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(k)})
+	}
+	var progress = func(position time.Duration) {
+		m, _ = m.Update(progressMsg{Position: position})
+	}
+
+	// before the first chapter, only the chapter in the first section is placed so far
+	timeline.addSection(nil, 10*time.Second, 0, 0, 100)
+	progress(0)
+	key("n")
+	assert.Equal(t, 5*time.Second, <-seekChan)
+	// a seek resumes playback
+	assert.False(t, <-pauseChan)
+	assert.Contains(t, m.View(), "3 chapters")
+
+	timeline.addSection(nil, 20*time.Second, 0, 100, 200)
+	progress(9 * time.Second)
+	assert.Contains(t, m.View(), "Chapter 1/3: One")
+	key("n")
+	assert.Equal(t, 10*time.Second, <-seekChan)
+	// well into a chapter the previous key restarts it
+	key("p")
+	assert.Equal(t, 5*time.Second, <-seekChan)
+
+	// just into one it goes back to the one before
+	progress(11 * time.Second)
+	key("p")
+	assert.Equal(t, 5*time.Second, <-seekChan)
+
+	progress(16 * time.Second)
+	key("n")
+	assert.Empty(t, seekChan)
+}
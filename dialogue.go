@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/polly/types"
+)
+
+// maxBreak is the longest pause polly's ssml break allows.
+const maxBreak = 10 * time.Second
+
+var (
+	errInvalidDialogue = errors.New("invalid dialogue script")
+	errPauseTooLong    = fmt.Errorf("pauses are at most %s", maxBreak)
+	// ALICE = Joanna maps a speaker to a voice in the header of a script
+	dialogueVoiceRegex = regexp.MustCompile(`^([^:=]+?)\s*=\s*([A-Za-z]+)$`)
+	// ALICE: line of dialogue
+	dialogueLineRegex = regexp.MustCompile(`^([^:]+?):\s*(.*)$`)
+	// an all caps name before a colon is taken for a speaker even when it has no voice
+	dialogueSpeakerRegex = regexp.MustCompile(`^[A-Z][A-Z0-9 ._'-]*$`)
+)
+
+// segment is a section of a document that is read with its own voice, e.g. a turn of a dialogue or
+// a paragraph in another language.
+type segment struct {
+	text         string
+	speaker      string
+	voiceID      string
+	languageCode types.LanguageCode // for bilingual voices, empty uses the voice's language
+	pause        time.Duration      // silence before the segment
+}
+
+// synthesisOpts sets the voice of the segment on the run's settings.
+func (s segment) synthesisOpts(synth synthesisOpts) synthesisOpts {
+	synth.voiceID = s.voiceID
+	synth.languageCode = s.languageCode
+	synth.pause = s.pause
+	return synth
+}
+
+// isDialogue reports whether raw starts like a dialogue script: a speaker mapped to a polly voice.
+func isDialogue(raw string) bool {
+	for _, line := range strings.Split(raw, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var m = dialogueVoiceRegex.FindStringSubmatch(line)
+		return m != nil && slices.Contains(types.VoiceId("").Values(), types.VoiceId(m[2]))
+	}
+	return false
+}
+
+// parseDialogue reads a dialogue script. The header maps each speaker to a voice, one
+// "SPEAKER = VoiceId" per line, and is followed by the lines of the dialogue as "SPEAKER: text".
+// Lines without a speaker continue the line before them, # starts a comment. Consecutive lines of
+// the same speaker are read as one turn, pause is the silence whenever the speaker changes.
+func parseDialogue(raw string, pause time.Duration) (document, error) {
+	var voices = make(map[string]string)
+	var segments []segment
+	var header = true
+	for n, line := range strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if header {
+			if m := dialogueVoiceRegex.FindStringSubmatch(line); m != nil {
+				if !slices.Contains(types.VoiceId("").Values(), types.VoiceId(m[2])) {
+					return document{}, fmt.Errorf("%w: line %d: %s is not an AWS Polly VoiceID", errInvalidDialogue, n+1, m[2])
+				}
+				voices[strings.ToUpper(m[1])] = m[2]
+				continue
+			}
+			if len(voices) == 0 {
+				return document{}, fmt.Errorf("%w: no voices, start the script with a SPEAKER = VoiceId line for each speaker", errInvalidDialogue)
+			}
+			header = false
+		}
+
+		var text = line
+		if m := dialogueLineRegex.FindStringSubmatch(line); m != nil {
+			var voice, ok = voices[strings.ToUpper(m[1])]
+			switch {
+			case ok && len(segments) > 0 && segments[len(segments)-1].speaker == strings.ToUpper(m[1]):
+				text = m[2]
+			case ok:
+				segments = append(segments, segment{speaker: strings.ToUpper(m[1]), voiceID: voice})
+				if len(segments) > 1 {
+					segments[len(segments)-1].pause = pause
+				}
+				text = m[2]
+			case dialogueSpeakerRegex.MatchString(m[1]):
+				return document{}, fmt.Errorf("%w: line %d: %s has no voice in the header", errInvalidDialogue, n+1, m[1])
+			}
+		}
+		if len(segments) == 0 {
+			return document{}, fmt.Errorf("%w: line %d has no speaker", errInvalidDialogue, n+1)
+		}
+		var last = &segments[len(segments)-1]
+		last.text = strings.TrimSpace(last.text + "\n" + text)
+	}
+
+	var texts = make([]string, 0, len(segments))
+	for _, s := range segments {
+		if len(s.text) > MAX_CHAR_COUNT {
+			return document{}, fmt.Errorf("%w: a turn of %s is longer than %d characters", errInvalidDialogue, s.speaker, MAX_CHAR_COUNT)
+		}
+		texts = append(texts, s.text)
+	}
+	// turns left without text are dropped
+	segments = slices.DeleteFunc(segments, func(s segment) bool { return s.text == "" })
+	texts = slices.DeleteFunc(texts, func(t string) bool { return t == "" })
+	if len(segments) > 0 {
+		segments[0].pause = 0
+	}
+	return document{text: strings.Join(texts, "\n\n"), segments: segments}, nil
+}
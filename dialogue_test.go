@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/polly/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/kmulvey/text2speech/internal/fakeaws"
+	"github.com/stretchr/testify/assert"
+)
+
+const dialogueInput = `# onboarding, scene 1
+ALICE = Joanna
+Bob = Matthew
+
+ALICE: Hi Bob, have you read the safety briefing?
+BOB: Not yet.
+Bob: Where can I find it?
+ALICE: On the intranet, under
+"Getting started".
+`
+
+func TestParseDialogue(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, isDialogue(dialogueInput))
+	assert.False(t, isDialogue("x = y\nALICE: hi"))
+
+	doc, err := parseDocument(dialogueInput, "", documentOpts{format: "auto", chapters: true, pause: 700 * time.Millisecond})
+	assert.NoError(t, err)
+	assert.Equal(t, []segment{
+		{text: "Hi Bob, have you read the safety briefing?", speaker: "ALICE", voiceID: "Joanna"},
+		{text: "Not yet.\nWhere can I find it?", speaker: "BOB", voiceID: "Matthew", pause: 700 * time.Millisecond},
+		{text: "On the intranet, under\n\"Getting started\".", speaker: "ALICE", voiceID: "Joanna", pause: 700 * time.Millisecond},
+	}, doc.segments)
+	assert.Equal(t, "Hi Bob, have you read the safety briefing?\n\nNot yet.\nWhere can I find it?\n\nOn the intranet, under\n\"Getting started\".", doc.text)
+	assert.Equal(t, []string{doc.segments[0].text, doc.segments[1].text, doc.segments[2].text}, doc.sections())
+
+	for input, problem := range map[string]string{
+		"ALICE: hi":                          "no voices",
+		"ALICE = Joanna\nCAROL: hi":          "CAROL has no voice",
+		"ALICE = Robot\nALICE: hi":           "Robot is not an AWS Polly VoiceID",
+		"ALICE = Joanna\nhello\nALICE: hi\n": "line 2 has no speaker",
+	} {
+		_, err = parseDialogue(input, 0)
+		assert.ErrorIs(t, err, errInvalidDialogue)
+		assert.ErrorContains(t, err, problem)
+	}
+}
+
+func TestHandleOutputDialogue(t *testing.T) {
+	var srv = newFakeAWS(t)
+	srv.Script(fakeaws.Task{Duration: 2 * time.Second}, fakeaws.Task{Duration: time.Second}, fakeaws.Task{Duration: time.Second})
+
+	doc, err := parseDocument(dialogueInput, "scene.dialogue", documentOpts{format: "auto", pause: 500 * time.Millisecond})
+	assert.NoError(t, err)
+	var timeline = &Timeline{}
+	var synth = synthesisOpts{bucket: "bucket", voiceID: "Ivy", marks: []types.SpeechMarkType{types.SpeechMarkTypeWord}}
+	var outputFile = filepath.Join(t.TempDir(), "scene.mp3")
+	assert.NoError(t, handleOutput(context.Background(), srv.PollyClient(), srv.S3Client(), make(chan *s3.GetObjectOutput, 5), make(chan string, 100), synth, doc, outputFile, timeline))
+
+	// each turn is read by its speaker's voice, the turns after the first start with the pause
+	var voices, texts []string
+	for _, task := range srv.Started() {
+		if task.OutputFormat == "mp3" {
+			voices = append(voices, task.VoiceID)
+			texts = append(texts, task.Text)
+		}
+	}
+	assert.Equal(t, []string{"Joanna", "Matthew", "Joanna"}, voices)
+	assert.Equal(t, "Hi Bob, have you read the safety briefing?", texts[0])
+	assert.Equal(t, `<speak><break time="500ms"/>Not yet.`+"\n"+`Where can I find it?</speak>`, texts[1])
+
+	// the speech marks still point into the text
+	var words = timeline.Marks(types.SpeechMarkTypeWord)
+	var not = words[slices.IndexFunc(words, func(m SpeechMark) bool { return m.Value == "Not" })]
+	assert.Equal(t, "Not", doc.text[not.Start:not.End])
+	assert.Equal(t, 1, not.Section)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+var errUnknownInputFormat = errors.New("unknown input format")
+
+// inputFormats are the values accepted by -input-format, auto picks one from the -input extension
+// or, for stdin, by looking at the start of the input.
+var inputFormats = []string{"auto", "text", "markdown", "html", "epub", "subtitles", "dialogue"}
+
+// document is the text to synthesize along with the structure found in the input.
+type document struct {
+	text     string
+	title    string // from the input itself, -title overrides it
+	author   string // likewise for -author
+	chapters []Chapter
+	headings []heading // emphasized and followed by a pause with -ssml
+	cues     []cue     // subtitle input, each cue is a section spoken at the cue's start
+	segments []segment // dialogue input, each turn is a section read with its speaker's voice
+	// every chapter starts a section of its own, -output-dir cuts a file per chapter at the sections
+	sectionPerChapter bool
+}
+
+// heading is the byte range of a heading in the document text.
+type heading struct {
+	start, end int
+	level      int // 1 is the most important
+}
+
+// documentOpts are the settings that shape how the input is read.
+type documentOpts struct {
+	format    string
+	code      codePolicy
+	chapters  bool
+	chapterRe *regexp.Regexp
+	pause     time.Duration // between the speakers of a dialogue
+}
+
+// parseInputFormat parses the -input-format flag.
+func parseInputFormat(format string) (string, error) {
+	format = strings.ToLower(strings.TrimSpace(format))
+	switch format {
+	case "":
+		return "auto", nil
+	case "md":
+		return "markdown", nil
+	case "txt":
+		return "text", nil
+	case "htm", "xhtml":
+		return "html", nil
+	case "srt", "vtt":
+		return "subtitles", nil
+	}
+	if !slices.Contains(inputFormats, format) {
+		return "", fmt.Errorf("%w: %s, must be one of %v", errUnknownInputFormat, format, inputFormats)
+	}
+	return format, nil
+}
+
+// detectInputFormat resolves auto to the format implied by the input file's extension, or to html
+// when the input starts like an html page.
+func detectInputFormat(format, inputFile, raw string) string {
+	if format != "auto" && format != "" {
+		return format
+	}
+	switch strings.ToLower(filepath.Ext(strings.TrimSpace(inputFile))) {
+	case ".md", ".markdown":
+		return "markdown"
+	case ".html", ".htm", ".xhtml":
+		return "html"
+	case ".epub":
+		return "epub"
+	case ".srt", ".vtt":
+		return "subtitles"
+	case ".dialogue":
+		return "dialogue"
+	}
+	if isEPUB(raw) {
+		return "epub"
+	}
+	if isSubtitles(raw) {
+		return "subtitles"
+	}
+	if isDialogue(raw) {
+		return "dialogue"
+	}
+	var start = strings.ToLower(strings.TrimSpace(raw[:min(len(raw), 512)]))
+	for _, prefix := range []string{"<!doctype html", "<html", "<?xml"} {
+		if strings.HasPrefix(start, prefix) && strings.Contains(start, "<html") {
+			return "html"
+		}
+	}
+	return "text"
+}
+
+// parseDocument reads the raw input as the given format. Markdown and html headings start chapters,
+// epub chapters come from its table of contents and plain text uses detectChapters. Subtitles have
+// no chapters, each cue is a section of its own, and neither do dialogues, each turn is a section. A -chapter-regex replaces the detection for every format.
+func parseDocument(raw, inputFile string, opts documentOpts) (document, error) {
+	var doc document
+	switch detectInputFormat(opts.format, inputFile, raw) {
+	case "markdown":
+		doc = parseMarkdown([]byte(raw), opts.code)
+	case "html":
+		var err error
+		if doc, err = parseHTML([]byte(raw), opts.code); err != nil {
+			return document{}, err
+		}
+	case "epub":
+		var err error
+		if doc, err = parseEPUB([]byte(raw), opts.code); err != nil {
+			return document{}, err
+		}
+	case "subtitles":
+		// cues are timed, they are never regrouped into chapters
+		return subtitleDocument(raw)
+	case "dialogue":
+		return parseDialogue(raw, opts.pause)
+	default:
+		doc = document{text: raw}
+		doc.chapters = detectChapters(raw, opts.chapterRe)
+		for _, c := range doc.chapters {
+			var end = strings.IndexByte(raw[c.Start:], '\n')
+			if end < 0 {
+				end = len(raw) - c.Start
+			}
+			doc.headings = append(doc.headings, heading{start: c.Start, end: c.Start + end, level: 1})
+		}
+	}
+	if opts.chapterRe != nil {
+		doc.chapters = detectChapters(doc.text, opts.chapterRe)
+	}
+	if !opts.chapters {
+		doc.chapters = nil
+	}
+	return doc, nil
+}
+
+// sections splits the document into the text of each synthesis task: the cues of subtitles, the
+// turns of a dialogue, or splitInput's sections, split at every chapter with sectionPerChapter.
+func (d document) sections() []string {
+	var sections []string
+	switch {
+	case d.cues != nil:
+		for _, c := range d.cues {
+			sections = append(sections, c.text)
+		}
+	case d.segments != nil:
+		for _, s := range d.segments {
+			sections = append(sections, s.text)
+		}
+	case d.sectionPerChapter:
+		sections = splitAtChapters(d.text, d.chapters)
+	default:
+		sections = splitInput(d.text, d.chapters)
+	}
+	return sections
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// maxEPUBFileSize caps how much of a single file in the archive is read.
+const maxEPUBFileSize = 64 << 20
+
+var (
+	errInvalidEPUB = errors.New("invalid epub")
+
+	// epubRemoved are the elements of a chapter that are not read, books have no menus or ads so
+	// unlike web pages only these are dropped. Footnotes are asides in epub 3, their references are
+	// dropped too.
+	epubRemoved = map[atom.Atom]bool{
+		atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Template: true, atom.Img: true,
+		atom.Svg: true, atom.Picture: true, atom.Video: true, atom.Audio: true, atom.Object: true,
+		atom.Embed: true, atom.Iframe: true, atom.Aside: true,
+	}
+)
+
+// isEPUB reports whether raw starts like an epub, a zip whose first file is its mimetype.
+func isEPUB(raw string) bool {
+	return strings.HasPrefix(raw, "PK\x03\x04") && strings.Contains(raw[:min(len(raw), 128)], "application/epub+zip")
+}
+
+type epubContainer struct {
+	Rootfiles []struct {
+		FullPath string `xml:"full-path,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+type epubPackage struct {
+	Title    []string `xml:"metadata>title"`
+	Creators []string `xml:"metadata>creator"`
+	Manifest []struct {
+		ID         string `xml:"id,attr"`
+		Href       string `xml:"href,attr"`
+		MediaType  string `xml:"media-type,attr"`
+		Properties string `xml:"properties,attr"`
+	} `xml:"manifest>item"`
+	Spine struct {
+		Toc      string `xml:"toc,attr"`
+		ItemRefs []struct {
+			IDRef  string `xml:"idref,attr"`
+			Linear string `xml:"linear,attr"`
+		} `xml:"itemref"`
+	} `xml:"spine"`
+}
+
+// epubNavPoint is an entry of an epub 2 ncx table of contents.
+type epubNavPoint struct {
+	Label   string `xml:"navLabel>text"`
+	Content struct {
+		Src string `xml:"src,attr"`
+	} `xml:"content"`
+	NavPoints []epubNavPoint `xml:"navPoint"`
+}
+
+// epubBook is an open epub archive.
+type epubBook struct {
+	files map[string]*zip.File
+}
+
+// parseEPUB reads the chapters of an epub in spine order as prose, the way html is read. The
+// chapters are titled from the table of contents (the epub 3 nav document, or the epub 2 ncx),
+// books without one use their headings. The title and author come from the package metadata.
+func parseEPUB(raw []byte, policy codePolicy) (document, error) {
+	archive, err := zip.NewReader(bytes.NewReader(raw), int64(len(raw)))
+	if err != nil {
+		return document{}, fmt.Errorf("%w: %w", errInvalidEPUB, err)
+	}
+	var book = epubBook{files: make(map[string]*zip.File)}
+	for _, f := range archive.File {
+		book.files[f.Name] = f
+	}
+
+	var container epubContainer
+	if err := book.decodeXML("META-INF/container.xml", &container); err != nil {
+		return document{}, err
+	}
+	if len(container.Rootfiles) == 0 {
+		return document{}, fmt.Errorf("%w: no rootfile in container.xml", errInvalidEPUB)
+	}
+	var opfPath = container.Rootfiles[0].FullPath
+	var pkg epubPackage
+	if err := book.decodeXML(opfPath, &pkg); err != nil {
+		return document{}, err
+	}
+
+	var hrefs = make(map[string]string)
+	var toc map[string]string
+	var navHref string
+	for _, item := range pkg.Manifest {
+		var href = resolveHref(opfPath, item.Href)
+		hrefs[item.ID] = href
+		switch {
+		case strings.Contains(" "+item.Properties+" ", " nav "):
+			navHref = href
+			toc, err = book.navTitles(href)
+		case item.ID == pkg.Spine.Toc && toc == nil:
+			toc, err = book.ncxTitles(href)
+		}
+		if err != nil {
+			return document{}, err
+		}
+	}
+
+	var w = &proseWriter{policy: policy}
+	for _, ref := range pkg.Spine.ItemRefs {
+		var href, ok = hrefs[ref.IDRef]
+		// the table of contents is not read out
+		if !ok || ref.Linear == "no" || href == navHref {
+			continue
+		}
+		chapter, err := book.chapter(href, policy)
+		if err != nil {
+			return document{}, err
+		}
+		if toc == nil {
+			w.append(chapter, "", true)
+		} else {
+			w.append(chapter, toc[href], false)
+		}
+	}
+
+	var doc = w.document()
+	if len(pkg.Title) > 0 {
+		doc.title = collapseSpace(pkg.Title[0])
+	}
+	if len(pkg.Creators) > 0 {
+		doc.author = collapseSpace(strings.Join(pkg.Creators, ", "))
+	}
+	return doc, nil
+}
+
+// append adds a document written on its own to w. With a title the document is one chapter, which
+// starts with the title unless the document already does, otherwise its own chapters are kept
+// when keepChapters is set.
+func (w *proseWriter) append(doc document, title string, keepChapters bool) {
+	if strings.TrimSpace(doc.text) == "" {
+		return
+	}
+	w.sep = "\n\n"
+	if title != "" {
+		var chapter = Chapter{Title: title, Start: w.out.Len()}
+		if chapter.Start > 0 {
+			chapter.Start += len(w.sep)
+		}
+		w.doc.chapters = append(w.doc.chapters, chapter)
+		if !strings.HasPrefix(strings.ToLower(doc.text), strings.ToLower(title)) {
+			var start = w.write(sentence(title))
+			w.doc.headings = append(w.doc.headings, heading{start: start, end: w.out.Len(), level: 1})
+		}
+	}
+	var start = w.write(doc.text)
+	for _, h := range doc.headings {
+		w.doc.headings = append(w.doc.headings, heading{start: h.start + start, end: h.end + start, level: h.level})
+	}
+	if keepChapters {
+		for _, c := range doc.chapters {
+			w.doc.chapters = append(w.doc.chapters, Chapter{Title: c.Title, Start: c.Start + start})
+		}
+	}
+}
+
+// chapter reads a content document of the spine.
+func (b epubBook) chapter(href string, policy codePolicy) (document, error) {
+	content, err := b.read(href)
+	if err != nil {
+		return document{}, err
+	}
+	root, err := html.Parse(bytes.NewReader(content))
+	if err != nil {
+		return document{}, fmt.Errorf("%w: %s: %w", errInvalidEPUB, href, err)
+	}
+	removeNodes(root, func(n *html.Node) bool {
+		return epubRemoved[n.DataAtom] || attr(n, "epub:type") == "noteref" || attr(n, "role") == "doc-noteref"
+	})
+
+	var w = &proseWriter{policy: policy}
+	if body := findElement(root, atom.Body); body != nil {
+		(&htmlRenderer{w: w}).block(body)
+	}
+	return w.document(), nil
+}
+
+// navTitles reads the toc of an epub 3 nav document, keyed by the path of the file each entry
+// points to. The first entry of a file titles it.
+func (b epubBook) navTitles(navPath string) (map[string]string, error) {
+	content, err := b.read(navPath)
+	if err != nil {
+		return nil, err
+	}
+	root, err := html.Parse(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", errInvalidEPUB, navPath, err)
+	}
+	var nav *html.Node
+	walkHTML(root, func(n *html.Node) bool {
+		if n.DataAtom == atom.Nav && (nav == nil || attr(n, "epub:type") == "toc") {
+			nav = n
+		}
+		return true
+	})
+	var titles = make(map[string]string)
+	if nav == nil {
+		return titles, nil
+	}
+	walkHTML(nav, func(n *html.Node) bool {
+		if n.DataAtom == atom.A {
+			addTitle(titles, resolveHref(navPath, attr(n, "href")), textContent(n))
+		}
+		return true
+	})
+	return titles, nil
+}
+
+// ncxTitles reads the toc of an epub 2 ncx file like navTitles.
+func (b epubBook) ncxTitles(ncxPath string) (map[string]string, error) {
+	var ncx struct {
+		NavPoints []epubNavPoint `xml:"navMap>navPoint"`
+	}
+	if err := b.decodeXML(ncxPath, &ncx); err != nil {
+		return nil, err
+	}
+	var titles = make(map[string]string)
+	var add func(points []epubNavPoint)
+	add = func(points []epubNavPoint) {
+		for _, p := range points {
+			addTitle(titles, resolveHref(ncxPath, p.Content.Src), p.Label)
+			add(p.NavPoints)
+		}
+	}
+	add(ncx.NavPoints)
+	return titles, nil
+}
+
+func addTitle(titles map[string]string, href, title string) {
+	title = collapseSpace(title)
+	if _, ok := titles[href]; !ok && title != "" && href != "" {
+		titles[href] = title
+	}
+}
+
+// resolveHref turns an href relative to the file base into a path in the archive, dropping any fragment.
+func resolveHref(base, href string) string {
+	href, _, _ = strings.Cut(href, "#")
+	if href == "" {
+		return ""
+	}
+	if unescaped, err := url.PathUnescape(href); err == nil {
+		href = unescaped
+	}
+	return path.Join(path.Dir(base), href)
+}
+
+func (b epubBook) read(name string) ([]byte, error) {
+	f, ok := b.files[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: missing %s", errInvalidEPUB, name)
+	}
+	r, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", errInvalidEPUB, name, err)
+	}
+	defer r.Close()
+	content, err := io.ReadAll(io.LimitReader(r, maxEPUBFileSize))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", errInvalidEPUB, name, err)
+	}
+	return content, nil
+}
+
+func (b epubBook) decodeXML(name string, v any) error {
+	content, err := b.read(name)
+	if err != nil {
+		return err
+	}
+	if err := xml.Unmarshal(content, v); err != nil {
+		return fmt.Errorf("%w: %s: %w", errInvalidEPUB, name, err)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// buildEPUB zips files into an epub, the mimetype first as the format requires.
+func buildEPUB(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var zw = zip.NewWriter(&buf)
+	var write = func(name, content string) {
+		f, err := zw.Create(name)
+		assert.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		assert.NoError(t, err)
+	}
+	write("mimetype", "application/epub+zip")
+	write("META-INF/container.xml", `<?xml version="1.0"?><container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles></container>`)
+	for name, content := range files {
+		write(name, content)
+	}
+	assert.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func xhtml(body string) string {
+	return `<?xml version="1.0" encoding="utf-8"?><html xmlns="http://www.w3.org/1999/xhtml"><head><title>x</title><style>p{}</style></head><body>` + body + `</body></html>`
+}
+
+func TestParseEPUB3(t *testing.T) {
+	t.Parallel()
+
+	var raw = buildEPUB(t, map[string]string{
+		"OEBPS/content.opf": `<?xml version="1.0"?><package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>The Voyage</dc:title><dc:creator>A. Writer</dc:creator></metadata>
+<manifest>
+<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+<item id="cover" href="text/cover.xhtml" media-type="application/xhtml+xml"/>
+<item id="c1" href="text/chapter%201.xhtml" media-type="application/xhtml+xml"/>
+<item id="c2" href="text/c2.xhtml" media-type="application/xhtml+xml"/>
+<item id="c2b" href="text/c2b.xhtml" media-type="application/xhtml+xml"/>
+</manifest>
+<spine><itemref idref="nav"/><itemref idref="cover" linear="no"/><itemref idref="c1"/><itemref idref="c2"/><itemref idref="c2b"/></spine></package>`,
+		"OEBPS/nav.xhtml": xhtml(`<nav epub:type="landmarks"><ol><li><a href="text/cover.xhtml">Cover</a></li></ol></nav>
+<nav epub:type="toc"><ol><li><a href="text/chapter%201.xhtml#start">Departure</a></li><li><a href="text/c2.xhtml">The Storm</a></li></ol></nav>`),
+		"OEBPS/text/cover.xhtml":     xhtml(`<p>Cover page.</p>`),
+		"OEBPS/text/chapter 1.xhtml": xhtml(`<section><header><h1>Departure</h1></header><p>We left at dawn.<sup><a epub:type="noteref" href="#n1">1</a></sup></p><aside epub:type="footnote" id="n1">A note.</aside></section>`),
+		"OEBPS/text/c2.xhtml":        xhtml(`<p>The wind rose.</p>`),
+		"OEBPS/text/c2b.xhtml":       xhtml(`<p>It did not stop.</p>`),
+	})
+
+	doc, err := parseEPUB(raw, codeSkip)
+	assert.NoError(t, err)
+	assert.Equal(t, "The Voyage", doc.title)
+	assert.Equal(t, "A. Writer", doc.author)
+	// the toc and the non linear cover are not read, the second chapter gets its title read out
+	assert.Equal(t, "Departure.\n\nWe left at dawn.\n\nThe Storm.\n\nThe wind rose.\n\nIt did not stop.", doc.text)
+	assert.Equal(t, []Chapter{{Title: "Departure", Start: 0}, {Title: "The Storm", Start: 30}}, doc.chapters)
+	assert.Len(t, doc.headings, 2)
+	for _, h := range doc.headings {
+		assert.Contains(t, []string{"Departure.", "The Storm."}, doc.text[h.start:h.end])
+	}
+}
+
+func TestParseEPUB2(t *testing.T) {
+	t.Parallel()
+
+	var raw = buildEPUB(t, map[string]string{
+		"OEBPS/content.opf": `<?xml version="1.0"?><package xmlns="http://www.idpf.org/2007/opf" version="2.0">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Old Book</dc:title></metadata>
+<manifest><item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/><item id="a" href="a.html" media-type="application/xhtml+xml"/></manifest>
+<spine toc="ncx"><itemref idref="a"/></spine></package>`,
+		"OEBPS/toc.ncx": `<?xml version="1.0"?><ncx xmlns="http://www.daisy.org/z3986/2005/ncx/"><navMap>
+<navPoint id="p1"><navLabel><text>Chapter One</text></navLabel><content src="a.html#top"/></navPoint></navMap></ncx>`,
+		"OEBPS/a.html": xhtml(`<h2>Chapter One</h2><p>Once upon a time.</p>`),
+	})
+
+	doc, err := parseDocument(string(raw), "", documentOpts{format: "auto", chapters: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "Old Book", doc.title)
+	assert.Equal(t, "Chapter One.\n\nOnce upon a time.", doc.text)
+	assert.Equal(t, []Chapter{{Title: "Chapter One", Start: 0}}, doc.chapters)
+
+	_, err = parseEPUB([]byte("not a zip"), codeSkip)
+	assert.ErrorIs(t, err, errInvalidEPUB)
+	_, err = parseEPUB(buildEPUB(t, nil), codeSkip)
+	assert.ErrorIs(t, err, errInvalidEPUB)
+}
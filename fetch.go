@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	defaultFetchTimeout = 30 * time.Second
+	defaultFetchMaxSize = 32 << 20
+)
+
+var (
+	errInvalidURL             = errors.New("invalid url, must be http or https")
+	errURLAndInput            = errors.New("set either -url or -input, not both")
+	errFetchStatus            = errors.New("unexpected http status")
+	errFetchTooLarge          = errors.New("document is larger than -url-max-size")
+	errUnsupportedContentType = errors.New("unsupported content type")
+)
+
+// fetchOpts are the limits on downloading the -url document.
+type fetchOpts struct {
+	timeout time.Duration // for the whole request, including reading the body
+	maxSize int64         // bytes
+}
+
+// validateURL checks -url is an absolute http(s) url.
+func validateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: %s", errInvalidURL, rawURL)
+	}
+	return nil
+}
+
+// fetchURL downloads a document and returns it, decoded to utf-8, along with the input format its
+// content type calls for. Plain text is read as markdown when the url ends in .md, content types
+// that say nothing (application/octet-stream) fall back to detectInputFormat.
+func fetchURL(ctx context.Context, client *http.Client, rawURL string, opts fetchOpts) (string, string, error) {
+	if err := validateURL(rawURL); err != nil {
+		return "", "", err
+	}
+	if opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", "", fmt.Errorf("fetch %s: %w", rawURL, err)
+	}
+	req.Header.Set("Accept", "text/html, application/xhtml+xml, text/markdown, text/plain;q=0.9, application/epub+zip;q=0.8")
+	req.Header.Set("User-Agent", "text2speech")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("fetch %s: %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("%w: %s: %s", errFetchStatus, rawURL, resp.Status)
+	}
+	if opts.maxSize > 0 && resp.ContentLength > opts.maxSize {
+		return "", "", fmt.Errorf("%w: %s is %d bytes", errFetchTooLarge, rawURL, resp.ContentLength)
+	}
+
+	var contentType = resp.Header.Get("Content-Type")
+	var urlPath = resp.Request.URL.Path // after redirects
+	format, err := contentTypeFormat(contentType, urlPath)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", rawURL, err)
+	}
+
+	var body io.Reader = resp.Body
+	if opts.maxSize > 0 {
+		body = io.LimitReader(body, opts.maxSize+1)
+	}
+	if format != "epub" {
+		if body, err = charset.NewReader(body, contentType); err != nil {
+			return "", "", fmt.Errorf("fetch %s: %w", rawURL, err)
+		}
+	}
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return "", "", fmt.Errorf("fetch %s: %w", rawURL, err)
+	}
+	if opts.maxSize > 0 && int64(len(content)) > opts.maxSize {
+		return "", "", fmt.Errorf("%w: %s", errFetchTooLarge, rawURL)
+	}
+	if format == "auto" {
+		format = detectInputFormat(format, urlPath, string(content))
+	}
+	return string(content), format, nil
+}
+
+// contentTypeFormat maps a content type onto an input format, auto when it does not say.
+func contentTypeFormat(contentType, urlPath string) (string, error) {
+	if contentType == "" {
+		return "auto", nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", errUnsupportedContentType, contentType)
+	}
+	switch mediaType {
+	case "text/html", "application/xhtml+xml":
+		return "html", nil
+	case "text/markdown", "text/x-markdown":
+		return "markdown", nil
+	case "application/epub+zip":
+		return "epub", nil
+	case "text/plain":
+		switch strings.ToLower(path.Ext(urlPath)) {
+		case ".md", ".markdown":
+			return "markdown", nil
+		}
+		return "text", nil
+	case "application/octet-stream", "binary/octet-stream":
+		return "auto", nil
+	}
+	if strings.HasPrefix(mediaType, "text/") {
+		return "text", nil
+	}
+	return "", fmt.Errorf("%w: %s", errUnsupportedContentType, mediaType)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchURL(t *testing.T) {
+	t.Parallel()
+
+	var mux = http.NewServeMux()
+	mux.HandleFunc("/article", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		_, _ = w.Write([]byte("<html><body><p>Caf\xe9 au lait.</p></body></html>"))
+	})
+	mux.HandleFunc("/notes.md", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte("# Notes"))
+	})
+	mux.HandleFunc("/readme", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/markdown")
+		_, _ = w.Write([]byte("# Readme"))
+	})
+	mux.HandleFunc("/plain", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("Just text."))
+	})
+	mux.HandleFunc("/download", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write([]byte("<!DOCTYPE html><html><p>Sniffed.</p></html>"))
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/notes.md", http.StatusFound)
+	})
+	mux.HandleFunc("/paper.pdf", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+	})
+	mux.HandleFunc("/big", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		// streamed so there is no content length to check up front
+		for range 10 {
+			_, _ = w.Write([]byte(strings.Repeat("a", 100)))
+			w.(http.Flusher).Flush()
+		}
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(5 * time.Second):
+		case <-r.Context().Done():
+		}
+	})
+	var srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	var opts = fetchOpts{timeout: 5 * time.Second, maxSize: 500}
+	for path, want := range map[string][2]string{
+		"/article":  {"<html><body><p>Café au lait.</p></body></html>", "html"},
+		"/notes.md": {"# Notes", "markdown"},
+		"/moved":    {"# Notes", "markdown"},
+		"/readme":   {"# Readme", "markdown"},
+		"/plain":    {"Just text.", "text"},
+		"/download": {"<!DOCTYPE html><html><p>Sniffed.</p></html>", "html"},
+	} {
+		text, format, err := fetchURL(context.Background(), srv.Client(), srv.URL+path, opts)
+		assert.NoError(t, err, path)
+		assert.Equal(t, want[0], text, path)
+		assert.Equal(t, want[1], format, path)
+	}
+
+	var _, _, err = fetchURL(context.Background(), srv.Client(), srv.URL+"/missing", opts)
+	assert.ErrorIs(t, err, errFetchStatus)
+	_, _, err = fetchURL(context.Background(), srv.Client(), srv.URL+"/paper.pdf", opts)
+	assert.ErrorIs(t, err, errUnsupportedContentType)
+	_, _, err = fetchURL(context.Background(), srv.Client(), srv.URL+"/big", opts)
+	assert.ErrorIs(t, err, errFetchTooLarge)
+	_, _, err = fetchURL(context.Background(), srv.Client(), srv.URL+"/slow", fetchOpts{timeout: 50 * time.Millisecond})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	_, _, err = fetchURL(context.Background(), srv.Client(), "file:///etc/passwd", opts)
+	assert.ErrorIs(t, err, errInvalidURL)
+}
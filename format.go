@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/polly/types"
+	"github.com/hajimehoshi/go-mp3"
+)
+
+// defaultPCMSampleRate is the rate polly uses for pcm output when none is requested.
+const defaultPCMSampleRate = 16000
+
+var (
+	errUnknownFormat       = errors.New("unknown output format")
+	errInvalidSampleRate   = errors.New("sample rate is not supported by the output format")
+	errUnsupportedPlayback = errors.New("format cannot be played, write it to a file with -output")
+	errInvalidOgg          = errors.New("invalid ogg stream")
+)
+
+// supportedFormats are the polly output formats that can be requested with -format.
+var supportedFormats = []types.OutputFormat{types.OutputFormatMp3, types.OutputFormatOggVorbis, types.OutputFormatOggOpus, types.OutputFormatPcm}
+
+// audioFormat is the polly output format of the audio along with the requested sample rate, 0 is polly's default.
+type audioFormat struct {
+	output     types.OutputFormat
+	sampleRate int
+}
+
+// parseFormat resolves the -format flag, when it is empty the format is inferred from the extension of outputFile.
+func parseFormat(format, outputFile string) (types.OutputFormat, error) {
+	if strings.TrimSpace(format) == "" {
+		return formatFromExtension(outputFile), nil
+	}
+	var output = types.OutputFormat(strings.ToLower(strings.TrimSpace(format)))
+	if !slices.Contains(supportedFormats, output) {
+		return "", fmt.Errorf("%w: %s, must be one of %v", errUnknownFormat, format, supportedFormats)
+	}
+	return output, nil
+}
+
+// formatFromExtension maps a file extension to the polly output format that produces it, defaulting
+// to mp3. m4b is encoded from mp3.
+func formatFromExtension(file string) types.OutputFormat {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".ogg", ".oga":
+		return types.OutputFormatOggVorbis
+	case ".opus":
+		return types.OutputFormatOggOpus
+	case ".wav", ".pcm", ".raw":
+		return types.OutputFormatPcm
+	default:
+		return types.OutputFormatMp3
+	}
+}
+
+// sampleRates are the SampleRate values polly accepts for each format. ogg_opus is left to polly to validate.
+var sampleRates = map[types.OutputFormat][]int{
+	types.OutputFormatMp3:       {8000, 16000, 22050, 24000},
+	types.OutputFormatOggVorbis: {8000, 16000, 22050, 24000},
+	types.OutputFormatPcm:       {8000, 16000},
+}
+
+// validate checks the requested sample rate against what polly supports for the format.
+func (f audioFormat) validate() error {
+	if f.sampleRate == 0 {
+		return nil
+	}
+	if rates, ok := sampleRates[f.output]; ok && !slices.Contains(rates, f.sampleRate) {
+		return fmt.Errorf("%w: %d for %s, must be one of %v", errInvalidSampleRate, f.sampleRate, f.output, rates)
+	}
+	return nil
+}
+
+// playable reports whether there is a decoder for the format in the playback path.
+func (f audioFormat) playable() bool {
+	return f.output == "" || slices.Contains(supportedFormats, f.output)
+}
+
+// wrapInWAV reports whether raw pcm written to file should get a wav header.
+func (f audioFormat) wrapInWAV(file string) bool {
+	return f.output == types.OutputFormatPcm && strings.EqualFold(filepath.Ext(file), ".wav")
+}
+
+// pcmFormat is the layout of polly's raw pcm output: signed 16 bit little endian mono.
+func (f audioFormat) pcmFormat() pcmFormat {
+	var rate = f.sampleRate
+	if rate == 0 {
+		rate = defaultPCMSampleRate
+	}
+	return pcmFormat{sampleRate: rate, channels: 1}
+}
+
+// duration returns how long the encoded audio plays for.
+func (f audioFormat) duration(body []byte) (time.Duration, error) {
+	var frames, rate int64
+	switch f.output {
+	case types.OutputFormatMp3, "":
+		decodedMp3, err := mp3.NewDecoder(bytes.NewReader(body))
+		if err != nil {
+			return 0, fmt.Errorf("mp3.NewDecoder: %w", err)
+		}
+		// go-mp3 always decodes to 16 bit stereo
+		frames, rate = decodedMp3.Length()/4, int64(decodedMp3.SampleRate())
+	case types.OutputFormatPcm:
+		var format = f.pcmFormat()
+		frames, rate = int64(len(body)/(format.channels*2)), int64(format.sampleRate)
+	case types.OutputFormatOggVorbis, types.OutputFormatOggOpus:
+		var err error
+		if frames, rate, err = oggLength(body); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("%w: %s", errUnknownFormat, f.output)
+	}
+	return time.Duration(frames) * time.Second / time.Duration(rate), nil
+}
+
+// oggLength reads the sample count from the granule position of the last ogg page and the sample
+// rate from the vorbis or opus identification header.
+func oggLength(body []byte) (int64, int64, error) {
+	var last = bytes.LastIndex(body, []byte("OggS"))
+	if last < 0 || len(body) < last+14 {
+		return 0, 0, fmt.Errorf("%w: no ogg pages", errInvalidOgg)
+	}
+	//nolint:gosec // granule positions are sample counts
+	var granule = int64(binary.LittleEndian.Uint64(body[last+6:]))
+
+	if i := bytes.Index(body, []byte("\x01vorbis")); i >= 0 && len(body) >= i+16 {
+		// packet type, "vorbis", version (4 bytes), channels (1 byte), sample rate (4 bytes)
+		return granule, int64(binary.LittleEndian.Uint32(body[i+12:])), nil
+	}
+	if i := bytes.Index(body, []byte("OpusHead")); i >= 0 && len(body) >= i+12 {
+		// opus granule positions are always 48kHz and include the pre-skip
+		var preSkip = int64(binary.LittleEndian.Uint16(body[i+10:]))
+		return max(granule-preSkip, 0), 48000, nil
+	}
+	return 0, 0, fmt.Errorf("%w: no vorbis or opus header", errInvalidOgg)
+}
+
+// decode turns the audio polly returned into pcm for an AudioSink and returns its duration in seconds, rounded down.
+func (f audioFormat) decode(body []byte) (io.Reader, pcmFormat, int, error) {
+	switch f.output {
+	case types.OutputFormatMp3, "":
+		return decodeMP3(body)
+	case types.OutputFormatPcm:
+		var format = f.pcmFormat()
+		return bytes.NewReader(body), format, len(body) / format.bytesPerSecond(), nil
+	case types.OutputFormatOggVorbis:
+		return decodeVorbis(body)
+	case types.OutputFormatOggOpus:
+		return decodeOpus(body)
+	default:
+		return nil, pcmFormat{}, 0, fmt.Errorf("%w: %s", errUnsupportedPlayback, f.output)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/polly/types"
+	"github.com/kmulvey/text2speech/internal/fakeaws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFormat(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		format, outputFile string
+		want               types.OutputFormat
+		err                error
+	}{
+		{outputFile: DEFAULT_OUTPUT, want: types.OutputFormatMp3},
+		{outputFile: "book.OGG", want: types.OutputFormatOggVorbis},
+		{outputFile: "book.opus", want: types.OutputFormatOggOpus},
+		{outputFile: "book.wav", want: types.OutputFormatPcm},
+		{outputFile: "book", want: types.OutputFormatMp3},
+		{format: "PCM", outputFile: "book.raw", want: types.OutputFormatPcm},
+		{format: "ogg_vorbis", outputFile: "book.mp3", want: types.OutputFormatOggVorbis},
+		{format: "flac", err: errUnknownFormat},
+	}
+	for _, tt := range tests {
+		got, err := parseFormat(tt.format, tt.outputFile)
+		assert.ErrorIs(t, err, tt.err)
+		assert.Equal(t, tt.want, got, "%s %s", tt.format, tt.outputFile)
+	}
+}
+
+func TestDecodeAudio(t *testing.T) {
+	t.Parallel()
+
+	_, format, seconds, err := audioFormat{output: types.OutputFormatPcm, sampleRate: 8000}.decode(make([]byte, 8000*2*3))
+	assert.NoError(t, err)
+	assert.Equal(t, pcmFormat{sampleRate: 8000, channels: 1}, format)
+	assert.Equal(t, 3, seconds)
+
+	body, err := os.ReadFile("testdata/vorbis.ogg")
+	assert.NoError(t, err)
+	pcm, format, _, err := audioFormat{output: types.OutputFormatOggVorbis}.decode(body)
+	assert.NoError(t, err)
+	assert.Equal(t, pcmFormat{sampleRate: 44100, channels: 1}, format)
+	decoded, err := io.ReadAll(pcm)
+	assert.NoError(t, err)
+	frames, _, err := oggLength(body)
+	assert.NoError(t, err)
+	assert.Len(t, decoded, int(frames)*2)
+
+	_, _, _, err = audioFormat{output: types.OutputFormatOggVorbis}.decode([]byte("OggS"))
+	assert.ErrorIs(t, err, errInvalidOgg)
+
+	_, _, _, err = audioFormat{output: "flac"}.decode(nil)
+	assert.ErrorIs(t, err, errUnsupportedPlayback)
+}
+
+func TestDecodeOpusWithoutFFmpeg(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+
+	_, _, _, err := audioFormat{output: types.OutputFormatOggOpus}.decode(nil)
+	assert.ErrorIs(t, err, errOpusNeedsFFmpeg)
+}
+
+func TestValidateSampleRate(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, audioFormat{output: types.OutputFormatMp3}.validate())
+	assert.NoError(t, audioFormat{output: types.OutputFormatMp3, sampleRate: 24000}.validate())
+	assert.NoError(t, audioFormat{output: types.OutputFormatOggOpus, sampleRate: 48000}.validate())
+	assert.ErrorIs(t, audioFormat{output: types.OutputFormatPcm, sampleRate: 22050}.validate(), errInvalidSampleRate)
+}
+
+func TestAudioDuration(t *testing.T) {
+	t.Parallel()
+
+	d, err := audioFormat{output: types.OutputFormatMp3}.duration(fakeaws.SilentMP3(3*time.Second, 22050))
+	assert.NoError(t, err)
+	assert.InDelta(t, 3*time.Second, d, float64(50*time.Millisecond))
+
+	d, err = audioFormat{output: types.OutputFormatPcm}.duration(fakeaws.SilentPCM(2*time.Second, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, 2*time.Second, d)
+
+	// first page carries the vorbis identification header, the last page's granule position is the sample count
+	var vorbis = []byte("OggS\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00\x01vorbis\x00\x00\x00\x00\x01\x22\x56\x00\x00")
+	vorbis = append(vorbis, []byte("OggS\x00\x04\x44\xac\x00\x00\x00\x00\x00\x00")...)
+	d, err = audioFormat{output: types.OutputFormatOggVorbis}.duration(vorbis)
+	assert.NoError(t, err)
+	assert.Equal(t, 2*time.Second, d)
+
+	_, err = audioFormat{output: types.OutputFormatOggOpus}.duration([]byte("not ogg"))
+	assert.ErrorIs(t, err, errInvalidOgg)
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	minParagraphLen = 25 // shorter paragraphs do not count towards the main content
+	maxTitleLen     = 150
+)
+
+var (
+	// class and id patterns of boilerplate, as used by readability
+	unlikelyRegex = regexp.MustCompile(`(?i)banner|breadcrumb|combx|comment|community|cookie|disqus|extra|footer|gdpr|header|legends|menu|modal|nav|newsletter|pager|pagination|popup|promo|related|remark|replies|rss|share|shoutbox|sidebar|skyscraper|social|sponsor|subscribe|tags|toolbar|widget|^ad-|-ad$|\bads?\b`)
+	likelyRegex   = regexp.MustCompile(`(?i)and|article|body|column|content|main|post|shadow|story|text|entry`)
+	languageRegex = regexp.MustCompile(`(?:^|\s)(?:language|lang)-(\S+)`)
+)
+
+// removedElements never contain anything worth reading, headers are removed by isPageHeader.
+var removedElements = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Template: true, atom.Nav: true,
+	atom.Footer: true, atom.Aside: true, atom.Form: true, atom.Button: true,
+	atom.Iframe: true, atom.Svg: true, atom.Canvas: true, atom.Select: true, atom.Input: true,
+	atom.Textarea: true, atom.Img: true, atom.Picture: true, atom.Video: true, atom.Audio: true,
+	atom.Object: true, atom.Embed: true, atom.Figure: true, atom.Dialog: true, atom.Menu: true,
+}
+
+// blockElements break the text around them into separate blocks.
+var blockElements = map[atom.Atom]bool{
+	atom.Address: true, atom.Article: true, atom.Blockquote: true, atom.Dd: true, atom.Details: true,
+	atom.Div: true, atom.Dl: true, atom.Dt: true, atom.Fieldset: true, atom.H1: true, atom.H2: true,
+	atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true, atom.Hr: true, atom.Li: true,
+	atom.Main: true, atom.Ol: true, atom.P: true, atom.Pre: true, atom.Section: true,
+	atom.Summary: true, atom.Table: true, atom.Ul: true, atom.Header: true, atom.Footer: true,
+	atom.Nav: true, atom.Aside: true, atom.Figure: true, atom.Figcaption: true, atom.Hgroup: true,
+}
+
+// boilerplateRoles are aria roles of everything around the article.
+var boilerplateRoles = []string{"banner", "navigation", "contentinfo", "complementary", "search", "menu", "menubar", "dialog", "alert"}
+
+// parseHTML extracts the main content of a web page the way reader views do: scripts, menus,
+// page headers, footers and other boilerplate are dropped, then the element whose paragraphs hold the
+// most text (discounting links) is taken as the article and rendered as prose like markdown is.
+func parseHTML(source []byte, policy codePolicy) (document, error) {
+	root, err := html.Parse(bytes.NewReader(source))
+	if err != nil {
+		return document{}, fmt.Errorf("parse html: %w", err)
+	}
+	var title = htmlTitle(root)
+	removeBoilerplate(root)
+
+	var w = &proseWriter{policy: policy}
+	var r = &htmlRenderer{w: w}
+	if article := mainContent(root); article != nil {
+		r.block(article)
+	}
+	var doc = w.document()
+	if title != "" {
+		doc.title = title
+	}
+	return doc, nil
+}
+
+// htmlTitle is the og:title of the page, or its <title>.
+func htmlTitle(root *html.Node) string {
+	var title, ogTitle string
+	walkHTML(root, func(n *html.Node) bool {
+		switch n.DataAtom {
+		case atom.Title:
+			if title == "" {
+				title = collapseSpace(textContent(n))
+			}
+		case atom.Meta:
+			if attr(n, "property") == "og:title" {
+				ogTitle = collapseSpace(attr(n, "content"))
+			}
+		}
+		return true
+	})
+	if ogTitle != "" {
+		title = ogTitle
+	}
+	if len(title) > maxTitleLen {
+		return ""
+	}
+	return title
+}
+
+// removeBoilerplate removes the elements that are never part of the article.
+func removeBoilerplate(root *html.Node) {
+	removeNodes(root, func(n *html.Node) bool {
+		return removedElements[n.DataAtom] || isPageHeader(n) || isHidden(n) || isBoilerplate(n)
+	})
+}
+
+// isPageHeader reports whether n is the header of the page, the site's name and menus, rather than
+// that of an article or section in it which holds its title and is kept along with the content.
+func isPageHeader(n *html.Node) bool {
+	if n.DataAtom != atom.Header {
+		return false
+	}
+	for ancestor := n.Parent; ancestor != nil; ancestor = ancestor.Parent {
+		if ancestor.DataAtom == atom.Article || ancestor.DataAtom == atom.Section || ancestor.DataAtom == atom.Main || attr(ancestor, "role") == "main" {
+			return false
+		}
+	}
+	return true
+}
+
+// removeNodes removes comments and the elements, other than the html and body, that match.
+func removeNodes(root *html.Node, match func(*html.Node) bool) {
+	var remove []*html.Node
+	walkHTML(root, func(n *html.Node) bool {
+		switch {
+		case n.Type == html.CommentNode:
+			remove = append(remove, n)
+		case n.Type != html.ElementNode, n.DataAtom == atom.Body, n.DataAtom == atom.Html:
+		case match(n):
+			remove = append(remove, n)
+			return false
+		}
+		return true
+	})
+	for _, n := range remove {
+		n.Parent.RemoveChild(n)
+	}
+}
+
+func isHidden(n *html.Node) bool {
+	if _, ok := attrValue(n, "hidden"); ok {
+		return true
+	}
+	var style = strings.ReplaceAll(attr(n, "style"), " ", "")
+	return attr(n, "aria-hidden") == "true" || strings.Contains(style, "display:none") || strings.Contains(style, "visibility:hidden")
+}
+
+func isBoilerplate(n *html.Node) bool {
+	for _, role := range boilerplateRoles {
+		if attr(n, "role") == role {
+			return true
+		}
+	}
+	if n.DataAtom == atom.Article || n.DataAtom == atom.Main || attr(n, "role") == "main" {
+		return false
+	}
+	var match = attr(n, "class") + " " + attr(n, "id")
+	return unlikelyRegex.MatchString(match) && !likelyRegex.MatchString(match)
+}
+
+// mainContent scores every element by the paragraphs it holds, each paragraph counting fully
+// towards its parent and half towards its grandparent, and returns the best one after
+// discounting links. Pages without paragraphs are read whole.
+func mainContent(root *html.Node) *html.Node {
+	var scores = make(map[*html.Node]float64)
+	var order []*html.Node
+	walkHTML(root, func(n *html.Node) bool {
+		if n.DataAtom != atom.P && n.DataAtom != atom.Pre && n.DataAtom != atom.Td && n.DataAtom != atom.Li {
+			return true
+		}
+		var text = collapseSpace(textContent(n))
+		if len(text) < minParagraphLen {
+			return false
+		}
+		var score = 1 + float64(strings.Count(text, ",")) + min(float64(len(text))/100, 3)
+		for ancestor, weight := n.Parent, 1.0; ancestor != nil && weight >= 0.5; ancestor, weight = ancestor.Parent, weight/2 {
+			if ancestor.Type != html.ElementNode {
+				break
+			}
+			if _, ok := scores[ancestor]; !ok {
+				order = append(order, ancestor)
+			}
+			scores[ancestor] += score * weight
+		}
+		return false
+	})
+
+	var best *html.Node
+	var bestScore float64
+	for _, n := range order {
+		var score = scores[n] * (1 - linkDensity(n))
+		switch n.DataAtom {
+		case atom.Article, atom.Main:
+			score *= 1.25
+		}
+		if score > bestScore {
+			best, bestScore = n, score
+		}
+	}
+	if best == nil {
+		return findElement(root, atom.Body)
+	}
+	return best
+}
+
+// linkDensity is the share of an element's text that is inside links.
+func linkDensity(n *html.Node) float64 {
+	var total = len(collapseSpace(textContent(n)))
+	if total == 0 {
+		return 0
+	}
+	var links int
+	walkHTML(n, func(c *html.Node) bool {
+		if c.DataAtom == atom.A {
+			links += len(collapseSpace(textContent(c)))
+			return false
+		}
+		return true
+	})
+	return float64(links) / float64(total)
+}
+
+type htmlRenderer struct {
+	w *proseWriter
+}
+
+// block renders the children of a block element, the inline content between its block children
+// is read as a paragraph.
+func (r *htmlRenderer) block(n *html.Node) {
+	var inline strings.Builder
+	var flush = func() {
+		r.w.paragraph(collapseSpace(inline.String()))
+		inline.Reset()
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && blockElements[c.DataAtom] {
+			flush()
+			r.element(c)
+			continue
+		}
+		writeText(&inline, c)
+	}
+	flush()
+}
+
+func (r *htmlRenderer) element(n *html.Node) {
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		r.w.heading(collapseSpace(textContent(n)), int(n.Data[1]-'0'))
+	case atom.Ul, atom.Ol, atom.Dl:
+		r.w.endList()
+		r.list(n)
+		r.w.endList()
+	case atom.Pre:
+		r.w.code(codeLanguage(n), textContent(n))
+	case atom.Table:
+		r.table(n)
+	case atom.Hr:
+	default:
+		r.block(n)
+	}
+}
+
+// list reads each item as a sentence, nested lists follow their item.
+func (r *htmlRenderer) list(n *html.Node) {
+	for item := n.FirstChild; item != nil; item = item.NextSibling {
+		if item.Type != html.ElementNode {
+			continue
+		}
+		var text strings.Builder
+		var nested []*html.Node
+		for c := item.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && blockElements[c.DataAtom] && c.DataAtom != atom.P {
+				nested = append(nested, c)
+				continue
+			}
+			writeText(&text, c)
+			text.WriteByte(' ')
+		}
+		r.w.item(collapseSpace(text.String()))
+		for _, c := range nested {
+			if c.DataAtom == atom.Ul || c.DataAtom == atom.Ol || c.DataAtom == atom.Dl {
+				r.list(c)
+			} else {
+				r.element(c)
+				r.w.endList()
+			}
+		}
+	}
+}
+
+func (r *htmlRenderer) table(n *html.Node) {
+	var headers []string
+	var rows [][]string
+	walkHTML(n, func(row *html.Node) bool {
+		if row.DataAtom == atom.Table && row != n {
+			return false
+		}
+		if row.DataAtom != atom.Tr {
+			return true
+		}
+		var cells []string
+		var header = true
+		for cell := row.FirstChild; cell != nil; cell = cell.NextSibling {
+			if cell.DataAtom == atom.Td || cell.DataAtom == atom.Th {
+				cells = append(cells, collapseSpace(textContent(cell)))
+				header = header && cell.DataAtom == atom.Th
+			}
+		}
+		if header && headers == nil && len(rows) == 0 {
+			headers = cells
+		} else if len(cells) > 0 {
+			rows = append(rows, cells)
+		}
+		return false
+	})
+	r.w.table(headers, rows)
+}
+
+// codeLanguage reads the language from a language-x class on a pre or its code element.
+func codeLanguage(pre *html.Node) string {
+	for _, n := range []*html.Node{pre, findElement(pre, atom.Code)} {
+		if n == nil {
+			continue
+		}
+		if m := languageRegex.FindStringSubmatch(attr(n, "class")); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// writeText appends the text of n, line breaks become spaces.
+func writeText(b *strings.Builder, n *html.Node) {
+	switch {
+	case n.Type == html.TextNode:
+		b.WriteString(n.Data)
+	case n.DataAtom == atom.Br:
+		b.WriteByte(' ')
+	default:
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			writeText(b, c)
+		}
+	}
+}
+
+// textContent is all the text below n.
+func textContent(n *html.Node) string {
+	var b strings.Builder
+	writeText(&b, n)
+	return b.String()
+}
+
+// walkHTML calls fn on n and its descendants in document order, returning false skips the children.
+func walkHTML(n *html.Node, fn func(*html.Node) bool) {
+	if !fn(n) {
+		return
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walkHTML(c, fn)
+	}
+}
+
+// findElement returns the first element of type a at or below n.
+func findElement(n *html.Node, a atom.Atom) *html.Node {
+	var found *html.Node
+	walkHTML(n, func(c *html.Node) bool {
+		if found == nil && c.DataAtom == a {
+			found = c
+		}
+		return found == nil
+	})
+	return found
+}
+
+func attr(n *html.Node, key string) string {
+	var value, _ = attrValue(n, key)
+	return value
+}
+
+func attrValue(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
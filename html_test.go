@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const articlePage = `<!DOCTYPE html>
+<html><head><title>Ignored | Example News</title><meta property="og:title" content="Rivers of the World">
+<script>var tracking = "do not read";</script><style>p { color: red }</style></head>
+<body>
+<header><a href="/">Example News</a></header>
+<nav><ul><li><a href="/world">World</a></li><li><a href="/sport">Sport</a></li></ul></nav>
+<div class="sidebar"><p>Popular stories, read more of them, and even more stories, right here.</p></div>
+<article>
+<h1>Rivers of the World</h1>
+<p>Rivers shape the land they cross, carving valleys, depositing silt and feeding the plains.</p>
+<h2>The Longest</h2>
+<p>The Nile and the Amazon compete for the title, depending on where the source is measured.<br>Both exceed six thousand kilometres.</p>
+<ul><li>Nile</li><li>Amazon <ul><li>Ucayali source</li></ul></li></ul>
+<pre><code class="language-python">print("rivers")
+</code></pre>
+<table><tr><th>River</th><th>Length</th></tr><tr><td>Nile</td><td>6650</td></tr></table>
+<div class="share-buttons"><a href="#">Share</a></div>
+<p style="display: none">Hidden text.</p>
+<img src="map.png" alt="map">
+<p>Read about <a href="/lakes">lakes</a> next.</p>
+</article>
+<div id="comments"><p>First! This article is great, thanks for writing it, really.</p></div>
+<footer><p>Copyright Example News, all rights reserved, since forever and ever.</p></footer>
+</body></html>`
+
+func TestParseHTML(t *testing.T) {
+	t.Parallel()
+
+	doc, err := parseHTML([]byte(articlePage), codeSummarize)
+	assert.NoError(t, err)
+	assert.Equal(t, "Rivers of the World", doc.title)
+	assert.Equal(t, "Rivers of the World.\n\n"+
+		"Rivers shape the land they cross, carving valleys, depositing silt and feeding the plains.\n\n"+
+		"The Longest.\n\n"+
+		"The Nile and the Amazon compete for the title, depending on where the source is measured. Both exceed six thousand kilometres.\n\n"+
+		"Nile.\nAmazon.\nUcayali source.\n\n"+
+		"Code sample in python, 1 line.\n\n"+
+		"Table of River and Length with 1 row.\n\n"+
+		"Read about lakes next.", doc.text)
+	assert.Len(t, doc.chapters, 2)
+	assert.Equal(t, "The Longest", doc.chapters[1].Title)
+	assert.Len(t, doc.headings, 2)
+
+	for _, boilerplate := range []string{"tracking", "Sport", "Popular", "Share", "Hidden", "First!", "Copyright"} {
+		assert.NotContains(t, doc.text, boilerplate)
+	}
+}
+
+func TestParseHTMLWithoutArticle(t *testing.T) {
+	t.Parallel()
+
+	// pages without paragraphs long enough to score are read whole
+	doc, err := parseHTML([]byte("<p>Short.</p><div>Also short</div>"), codeSkip)
+	assert.NoError(t, err)
+	assert.Equal(t, "Short.\n\nAlso short", doc.text)
+	assert.Empty(t, doc.title)
+}
+
+func TestParseHTMLArticleHeader(t *testing.T) {
+	t.Parallel()
+
+	// the article's own header holds its title, only the page's header is dropped
+	var page = `<html><body>
+<header><a href="/">Example News</a><p>The news, all of it, every single day of the week.</p></header>
+<article>
+<header><h1>Rivers of the World</h1><p class="byline">By Jane Doe, the river correspondent.</p></header>
+<p>Rivers shape the land they cross, carving valleys, depositing silt and feeding the plains.</p>
+<p>The Nile and the Amazon compete for the title, depending on where the source is measured.</p>
+</article>
+</body></html>`
+	doc, err := parseHTML([]byte(page), codeSkip)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(doc.text, "Rivers of the World.\n\nBy Jane Doe, the river correspondent."), doc.text)
+	assert.Equal(t, []Chapter{{Title: "Rivers of the World", Start: 0}}, doc.chapters)
+	assert.NotContains(t, doc.text, "Example News")
+	assert.NotContains(t, doc.text, "every single day")
+}
+
+func TestDetectInputFormat(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "html", detectInputFormat("auto", "", "  <!DOCTYPE html>\n<html>"))
+	assert.Equal(t, "html", detectInputFormat("auto", "page.HTM", "hello"))
+	assert.Equal(t, "text", detectInputFormat("auto", "", "<b>not a page</b>"))
+	assert.Equal(t, "markdown", detectInputFormat("auto", "notes.md", "<html>"))
+	assert.Equal(t, "text", detectInputFormat("text", "page.html", "<html>"))
+}
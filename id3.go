@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxID3TOCEntries is how many entries a CTOC frame can list, its entry count is a single byte.
+const maxID3TOCEntries = 255
+
+// id3Tag is the metadata written to the start of mp3 output.
+type id3Tag struct {
+	title    string
+	artist   string
+	album    string
+	date     time.Time
+	comment  string
+	track    int // position in -output-dir, 0 when the output is a single file
+	tracks   int
+	chapters []chapterMarker
+}
+
+// newID3Tag fills in the tag for the run, the artist is the author or else the polly voice.
+func newID3Tag(info bookInfo, voice, inputFile string, markers []chapterMarker) id3Tag {
+	var tag = id3Tag{title: info.title, artist: info.author, album: info.title, date: time.Now(), chapters: markers}
+	if tag.artist == "" {
+		tag.artist = voice
+	}
+	var source = "standard input"
+	if inputFile != "" {
+		source = filepath.Base(inputFile)
+	}
+	tag.comment = fmt.Sprintf("Synthesized with AWS Polly (%s) from %s", voice, source)
+	return tag
+}
+
+// bytes encodes the tag as ID3v2.4 with utf-8 text. Chapters get a CHAP frame each, listed in
+// order by a CTOC frame.
+func (t id3Tag) bytes() []byte {
+	var frames bytes.Buffer
+	for _, f := range []struct{ id, text string }{{"TIT2", t.title}, {"TPE1", t.artist}, {"TALB", t.album}} {
+		if f.text != "" {
+			frames.Write(id3Frame(f.id, id3Text(f.text)))
+		}
+	}
+	if t.track > 0 {
+		frames.Write(id3Frame("TRCK", id3Text(fmt.Sprintf("%d/%d", t.track, t.tracks))))
+	}
+	if !t.date.IsZero() {
+		frames.Write(id3Frame("TDRC", id3Text(t.date.Format("2006-01-02"))))
+	}
+	if t.comment != "" {
+		// encoding, language, empty description, text
+		frames.Write(id3Frame("COMM", append([]byte("\x03eng\x00"), t.comment...)))
+	}
+
+	var chapters = t.chapters
+	if len(chapters) > maxID3TOCEntries*maxID3TOCEntries {
+		log.Warnf("only the first %d of %d chapters are written to the id3 tag", maxID3TOCEntries*maxID3TOCEntries, len(chapters))
+		chapters = chapters[:maxID3TOCEntries*maxID3TOCEntries]
+	}
+	if len(chapters) > 0 {
+		frames.Write(id3TOCFrames(len(chapters)))
+	}
+	for i, c := range chapters {
+		var chap = []byte(fmt.Sprintf("ch%d\x00", i))
+		//nolint:gosec // chapter times are far below 49 days
+		chap = binary.BigEndian.AppendUint32(chap, uint32(c.start.Milliseconds()))
+		//nolint:gosec
+		chap = binary.BigEndian.AppendUint32(chap, uint32(c.end.Milliseconds()))
+		// byte offsets are not used
+		chap = binary.BigEndian.AppendUint32(chap, 0xFFFFFFFF)
+		chap = binary.BigEndian.AppendUint32(chap, 0xFFFFFFFF)
+		chap = append(chap, id3Frame("TIT2", id3Text(c.title))...)
+		frames.Write(id3Frame("CHAP", chap))
+	}
+
+	var tag = append([]byte("ID3\x04\x00\x00"), syncsafe(frames.Len())...)
+	return append(tag, frames.Bytes()...)
+}
+
+// id3TOCFrames lists the chapters in order in CTOC frames. Up to maxID3TOCEntries chapters are listed
+// by the top level CTOC, more are split over child CTOCs that the top level one lists in order.
+func id3TOCFrames(chapters int) []byte {
+	var ids = make([]string, chapters)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("ch%d", i)
+	}
+	if chapters <= maxID3TOCEntries {
+		return id3Frame("CTOC", id3TOC("toc", true, ids))
+	}
+	var children []string
+	var childFrames []byte
+	for i := 0; i < chapters; i += maxID3TOCEntries {
+		var child = fmt.Sprintf("toc%d", len(children))
+		children = append(children, child)
+		childFrames = append(childFrames, id3Frame("CTOC", id3TOC(child, false, ids[i:min(i+maxID3TOCEntries, chapters)]))...)
+	}
+	return append(id3Frame("CTOC", id3TOC("toc", true, children)), childFrames...)
+}
+
+// id3TOC is the body of an ordered CTOC frame: its element id, flags, entry count and entries.
+func id3TOC(id string, topLevel bool, entries []string) []byte {
+	var flags byte = 0x01
+	if topLevel {
+		flags |= 0x02
+	}
+	var toc = append([]byte(id+"\x00"), flags, byte(len(entries)))
+	for _, entry := range entries {
+		toc = append(toc, entry+"\x00"...)
+	}
+	return toc
+}
+
+// id3Frame wraps the body in an ID3v2.4 frame header.
+func id3Frame(id string, body []byte) []byte {
+	var frame = append([]byte(id), syncsafe(len(body))...)
+	frame = append(frame, 0, 0)
+	return append(frame, body...)
+}
+
+// id3Text is the body of a text frame: utf-8 encoding followed by the text.
+func id3Text(text string) []byte {
+	return append([]byte{3}, text...)
+}
+
+// syncsafe encodes n as a 28 bit ID3 syncsafe integer.
+func syncsafe(n int) []byte {
+	return []byte{byte(n >> 21 & 0x7F), byte(n >> 14 & 0x7F), byte(n >> 7 & 0x7F), byte(n & 0x7F)}
+}
+
+// prependID3Tag writes the tag to the start of the mp3 at path.
+func prependID3Tag(path string, tag id3Tag) error {
+	//nolint:gosec
+	mp3, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error tagging mp3: %w", err)
+	}
+	defer mp3.Close()
+
+	tagged, err := os.CreateTemp(filepath.Dir(path), ".text2speech-*.mp3")
+	if err != nil {
+		return fmt.Errorf("error tagging mp3: %w", err)
+	}
+	defer os.Remove(tagged.Name())
+	// keep the permissions handleOutput created the file with
+	if stat, err := mp3.Stat(); err == nil {
+		if err := tagged.Chmod(stat.Mode()); err != nil {
+			tagged.Close()
+			return fmt.Errorf("error tagging mp3: %w", err)
+		}
+	}
+	if _, err := tagged.Write(tag.bytes()); err != nil {
+		tagged.Close()
+		return fmt.Errorf("error tagging mp3: %w", err)
+	}
+	if _, err := io.Copy(tagged, mp3); err != nil {
+		tagged.Close()
+		return fmt.Errorf("error tagging mp3: %w", err)
+	}
+	if err := tagged.Close(); err != nil {
+		return fmt.Errorf("error tagging mp3: %w", err)
+	}
+	if err := os.Rename(tagged.Name(), path); err != nil {
+		return fmt.Errorf("error tagging mp3: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kmulvey/text2speech/internal/fakeaws"
+	"github.com/stretchr/testify/assert"
+)
+
+// readID3Frames returns the frames of an ID3v2.4 tag by id, in order.
+func readID3Frames(t *testing.T, tag []byte) ([]string, map[string][]byte) {
+	t.Helper()
+	assert.Equal(t, "ID3\x04\x00\x00", string(tag[:6]))
+	var size = int(tag[6])<<21 | int(tag[7])<<14 | int(tag[8])<<7 | int(tag[9])
+	var ids []string
+	var frames = make(map[string][]byte)
+	for body := tag[10 : 10+size]; len(body) >= 10; {
+		var frameSize = int(body[4])<<21 | int(body[5])<<14 | int(body[6])<<7 | int(body[7])
+		ids = append(ids, string(body[:4]))
+		frames[string(body[:4])] = body[10 : 10+frameSize]
+		body = body[10+frameSize:]
+	}
+	return ids, frames
+}
+
+func TestID3Tag(t *testing.T) {
+	t.Parallel()
+
+	var tag = newID3Tag(bookInfo{title: "Report"}, "Joanna", "/tmp/report.txt", []chapterMarker{
+		{title: "One", end: 1500 * time.Millisecond},
+		{title: "Two", start: 1500 * time.Millisecond, end: 3 * time.Second},
+	})
+	tag.date = time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	ids, frames := readID3Frames(t, tag.bytes())
+	assert.Equal(t, []string{"TIT2", "TPE1", "TALB", "TDRC", "COMM", "CTOC", "CHAP", "CHAP"}, ids)
+	assert.Equal(t, "\x03Report", string(frames["TIT2"]))
+	// the voice is the artist when there is no author
+	assert.Equal(t, "\x03Joanna", string(frames["TPE1"]))
+	assert.Equal(t, "\x032024-05-01", string(frames["TDRC"]))
+	assert.Equal(t, "\x03eng\x00Synthesized with AWS Polly (Joanna) from report.txt", string(frames["COMM"]))
+	assert.Equal(t, "toc\x00\x03\x02ch0\x00ch1\x00", string(frames["CTOC"]))
+
+	// the last CHAP frame
+	var chap = frames["CHAP"]
+	assert.Equal(t, "ch1\x00", string(chap[:4]))
+	assert.Equal(t, uint32(1500), binary.BigEndian.Uint32(chap[4:]))
+	assert.Equal(t, uint32(3000), binary.BigEndian.Uint32(chap[8:]))
+	_, sub := readID3Frames(t, append([]byte("ID3\x04\x00\x00"), append(syncsafe(len(chap)-20), chap[20:]...)...))
+	assert.Equal(t, "\x03Two", string(sub["TIT2"]))
+
+	// more chapters than a CTOC frame can list are split over child CTOCs
+	tag.chapters = make([]chapterMarker, maxID3TOCEntries+45)
+	ids, frames = readID3Frames(t, tag.bytes())
+	assert.Equal(t, []string{"CTOC", "CTOC", "CTOC", "CHAP"}, ids[5:9])
+	assert.Equal(t, "toc1\x00\x01\x2dch255\x00", string(frames["CTOC"][:13]))
+	assert.Equal(t, "ch299\x00", string(frames["CHAP"][:6]))
+}
+
+func TestPrependID3Tag(t *testing.T) {
+	t.Parallel()
+
+	var path = filepath.Join(t.TempDir(), "out.mp3")
+	var audio = fakeaws.SilentMP3(time.Second, 0)
+	assert.NoError(t, os.WriteFile(path, audio, 0o640))
+	assert.NoError(t, prependID3Tag(path, newID3Tag(bookInfo{title: "Report", author: "Jane"}, "Joanna", "", nil)))
+
+	tagged, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	ids, frames := readID3Frames(t, tagged)
+	assert.NotContains(t, ids, "CTOC")
+	assert.Equal(t, "\x03Jane", string(frames["TPE1"]))
+	assert.Equal(t, "\x03eng\x00Synthesized with AWS Polly (Joanna) from standard input", string(frames["COMM"]))
+
+	// the audio is untouched and still decodes
+	assert.Equal(t, audio, tagged[len(tagged)-len(audio):])
+	taggedLength, err := audioFormat{}.duration(tagged)
+	assert.NoError(t, err)
+	untaggedLength, err := audioFormat{}.duration(audio)
+	assert.NoError(t, err)
+	assert.Equal(t, untaggedLength, taggedLength)
+	assert.Equal(t, 1, mp3Channels(tagged))
+
+	stat, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o640), stat.Mode().Perm())
+}
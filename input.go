@@ -42,33 +42,38 @@ func readInput(reader io.Reader) (string, error) {
 }
 
 // splitInput splits the text input into chunks of at most MAX_CHAR_COUNT
-// characters, which is the current polly limit per job. It prefers to split
-// at sentence boundaries (". "), falling back to the last whitespace character.
-func splitInput(fulltext string) []string {
-	if len(fulltext) <= MAX_CHAR_COUNT {
-		return []string{fulltext}
+// characters, which is the current polly limit per job. Each chunk is filled up
+// to the limit; where one has to be cut it is cut at the last chapter start inside
+// it, then at the last sentence boundary (". "), falling back to the last
+// whitespace character.
+func splitInput(fulltext string, chapters []Chapter) []string {
+	// offset is where remaining starts in fulltext, which is what the chapter starts are relative to
+	var remaining = strings.TrimSpace(fulltext)
+	if remaining == "" {
+		return nil
 	}
+	var offset = len(fulltext) - len(strings.TrimLeftFunc(fulltext, unicode.IsSpace))
 
 	var result []string
-	remaining := fulltext
 	for len(remaining) > MAX_CHAR_COUNT {
 		chunk := remaining[:MAX_CHAR_COUNT]
 
-		// prefer splitting at a sentence boundary
-		splitAt := strings.LastIndex(chunk, ". ")
-		if splitAt < 0 {
-			// fall back to last whitespace
-			splitAt = strings.LastIndexFunc(chunk, unicode.IsSpace)
+		// prefer splitting where a chapter starts
+		splitAt := -1
+		for _, c := range chapters {
+			if c.Start > offset && c.Start-offset < len(chunk) {
+				splitAt = c.Start - offset
+			}
 		}
 		if splitAt < 0 {
-			// hard split as last resort
-			splitAt = MAX_CHAR_COUNT
-		} else {
-			splitAt++ // include the trailing period/space in this chunk
+			splitAt = splitPoint(chunk)
 		}
 
+		var cut = remaining[splitAt:]
+		var rest = strings.TrimSpace(cut)
+		offset += splitAt + len(cut) - len(rest)
 		result = append(result, strings.TrimSpace(remaining[:splitAt]))
-		remaining = strings.TrimSpace(remaining[splitAt:])
+		remaining = rest
 	}
 	if remaining != "" {
 		result = append(result, remaining)
@@ -76,6 +81,52 @@ func splitInput(fulltext string) []string {
 	return result
 }
 
+// splitAtChapters splits the text like splitInput, except that every chapter starts a new chunk
+// so each chapter's audio can be cut out of the output on its own.
+func splitAtChapters(fulltext string, chapters []Chapter) []string {
+	var result []string
+	var start int
+	for _, c := range chapters {
+		if c.Start > start && c.Start < len(fulltext) {
+			result = append(result, splitInput(fulltext[start:c.Start], nil)...)
+			start = c.Start
+		}
+	}
+	return append(result, splitInput(fulltext[start:], nil)...)
+}
+
+// splitPoint returns where to cut a chunk that is too long: after its last sentence, or its last
+// whitespace character, or at its end as a last resort.
+func splitPoint(chunk string) int {
+	// prefer splitting at a sentence boundary
+	splitAt := strings.LastIndex(chunk, ". ")
+	if splitAt < 0 {
+		// fall back to last whitespace
+		splitAt = strings.LastIndexFunc(chunk, unicode.IsSpace)
+	}
+	if splitAt < 0 {
+		// hard split as last resort
+		return len(chunk)
+	}
+	// include the trailing period/space in this chunk
+	return splitAt + 1
+}
+
+// sectionOffsets returns the byte offset in text where each section from splitInput starts.
+func sectionOffsets(text string, sections []string) []int {
+	var offsets = make([]int, len(sections))
+	var textOffset, searchFrom int
+	for i, section := range sections {
+		// sections are trimmed substrings of text
+		if idx := strings.Index(text[searchFrom:], section); idx >= 0 {
+			textOffset = searchFrom + idx
+			searchFrom = textOffset + len(section)
+		}
+		offsets[i] = textOffset
+	}
+	return offsets
+}
+
 // deleteS3File deletes the file that polly writes to s3 after we are done playing it.
 func deleteS3File(ctx context.Context, s3Client *s3.Client, bucket, key string) error {
 	var _, err = s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
@@ -87,9 +138,3 @@ func deleteS3File(ctx context.Context, s3Client *s3.Client, bucket, key string)
 	}
 	return nil
 }
-
-// EscapeFilePath escapes spaces in the filepath used for an exec() call
-func EscapeFilePath(file string) string {
-	var r = strings.NewReplacer(" ", `\ `, "(", `\(`, ")", `\)`, "'", `\'`, "&", `\&`, "@", `\@`)
-	return r.Replace(file)
-}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadInput(t *testing.T) {
+	t.Parallel()
+
+	text, err := readInput(strings.NewReader("  hello world \n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", text)
+}
+
+func TestSplitInput(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []string{"short text."}, splitInput("short text.", nil))
+
+	// sentences are kept whole and every section is under the polly limit
+	var sentence = "The quick brown fox jumps over the lazy dog. "
+	var text = strings.TrimSpace(strings.Repeat(sentence, (MAX_CHAR_COUNT*2)/len(sentence)+1))
+	var sections = splitInput(text, nil)
+	assert.Len(t, sections, 3)
+	for _, section := range sections {
+		assert.LessOrEqual(t, len(section), MAX_CHAR_COUNT)
+		assert.True(t, strings.HasSuffix(section, "dog."), "section should end on a sentence boundary")
+	}
+	assert.Equal(t, strings.ReplaceAll(text, " ", ""), strings.ReplaceAll(strings.Join(sections, ""), " ", ""))
+
+	// no whitespace at all forces a hard split
+	sections = splitInput(strings.Repeat("a", MAX_CHAR_COUNT+10), nil)
+	assert.Len(t, sections, 2)
+	assert.Len(t, sections[0], MAX_CHAR_COUNT)
+	assert.Len(t, sections[1], 10)
+}
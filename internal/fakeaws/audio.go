@@ -0,0 +1,51 @@
+package fakeaws
+
+import (
+	"bytes"
+	"time"
+)
+
+// SilentMP3 returns a mono MPEG layer III stream of silence that is at least d long. Frames are
+// all zero after the header, which decoders treat as silence. sampleRate selects MPEG-1
+// (32000, 44100, 48000) or MPEG-2 (16000, 22050, 24000) framing, anything else uses polly's
+// default mp3 rate of 22050.
+func SilentMP3(d time.Duration, sampleRate int) []byte {
+	var header = [4]byte{0xFF}
+	var frameSize, samplesPerFrame int
+	switch sampleRate {
+	case 44100, 48000, 32000:
+		header[1] = 0xFB   // MPEG-1, layer III, no crc
+		header[2] = 9 << 4 // 128kbps
+		header[2] |= map[int]byte{44100: 0, 48000: 1, 32000: 2}[sampleRate] << 2
+		frameSize = 144 * 128_000 / sampleRate
+		samplesPerFrame = 1152
+	default:
+		if sampleRate != 24000 && sampleRate != 16000 {
+			sampleRate = 22050
+		}
+		header[1] = 0xF3   // MPEG-2, layer III, no crc
+		header[2] = 4 << 4 // 32kbps
+		header[2] |= map[int]byte{22050: 0, 24000: 1, 16000: 2}[sampleRate] << 2
+		frameSize = 72 * 32_000 / sampleRate
+		samplesPerFrame = 576
+	}
+	header[3] = 0xC0 // mono
+
+	var frames = int(d.Seconds()*float64(sampleRate)+float64(samplesPerFrame)-1) / samplesPerFrame
+	var buf bytes.Buffer
+	var frame = make([]byte, frameSize)
+	copy(frame, header[:])
+	for range max(frames, 1) {
+		buf.Write(frame)
+	}
+	return buf.Bytes()
+}
+
+// SilentPCM returns d of signed 16 bit little endian mono silence, the layout of polly's pcm output.
+// sampleRate defaults to polly's pcm default of 16000.
+func SilentPCM(d time.Duration, sampleRate int) []byte {
+	if sampleRate == 0 {
+		sampleRate = 16000
+	}
+	return make([]byte, int(d.Seconds()*float64(sampleRate))*2)
+}
@@ -0,0 +1,28 @@
+package fakeaws
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/hajimehoshi/go-mp3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSilentMP3(t *testing.T) {
+	t.Parallel()
+
+	for _, rate := range []int{16000, 22050, 24000, 32000, 44100, 48000} {
+		decoder, err := mp3.NewDecoder(bytes.NewReader(SilentMP3(2*time.Second, rate)))
+		assert.NoError(t, err)
+		assert.Equal(t, rate, decoder.SampleRate())
+
+		pcm, err := io.ReadAll(decoder)
+		assert.NoError(t, err)
+		// go-mp3 always decodes to 16 bit stereo
+		var seconds = float64(len(pcm)) / float64(rate*4)
+		assert.InDelta(t, 2.0, seconds, 0.1, "rate %d", rate)
+		assert.Equal(t, make([]byte, len(pcm)), pcm, "rate %d should be silent", rate)
+	}
+}
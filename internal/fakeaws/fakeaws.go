@@ -0,0 +1,600 @@
+// Package fakeaws is an in-process stand in for the subset of AWS Polly and S3 that text2speech uses,
+// so synthesis, output handling and cleanup can be tested end to end without AWS.
+//
+// A single httptest server answers both services: polly's rest-json synthesis task api under
+// /v1/synthesisTasks and path style s3 for everything else. Point the sdk clients at Server.URL
+// (with s3 path style addressing) or use PollyClient and S3Client.
+package fakeaws
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/polly"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	synthesisTasksPath = "/v1/synthesisTasks"
+	lexiconsPath       = "/v1/lexicons"
+	voicesPath         = "/v1/voices"
+	defaultDuration    = time.Second
+)
+
+// Task scripts how the server handles one StartSpeechSynthesisTask call. Scripts are consumed in the
+// order the audio task calls arrive, calls beyond the script get the zero Task which completes
+// immediately. Speech mark tasks (OutputFormat json) run alongside audio tasks so they are not
+// scripted, they stay inProgress until the audio task for the same text has started and their
+// marks are spread over that task's Duration.
+type Task struct {
+	Duration   time.Duration // length of the silent mp3 written on completion, default 1s
+	Pending    int           // number of GetSpeechSynthesisTask polls that report inProgress before completing
+	Latency    time.Duration // delay added to every polly response about this task
+	StartError string        // reject StartSpeechSynthesisTask with a ValidationException carrying this message
+	FailReason string        // report the task as failed with this reason
+	DenyGet    bool          // GetObject of the output returns AccessDenied
+	DenyDelete bool          // DeleteObject of the output returns AccessDenied
+}
+
+// StartedTask records the parameters of a StartSpeechSynthesisTask call.
+type StartedTask struct {
+	TaskID            string
+	Text              string
+	VoiceID           string
+	Engine            string
+	LanguageCode      string
+	OutputFormat      string
+	OutputS3Bucket    string
+	OutputS3KeyPrefix string
+	SampleRate        string
+	TextType          string
+	LexiconNames      []string
+	SpeechMarkTypes   []string
+}
+
+type task struct {
+	StartedTask
+	script Task
+	polls  int
+	key    string
+}
+
+type object struct {
+	body       []byte
+	modified   time.Time
+	encryption Encryption
+	denyGet    bool
+	denyDelete bool
+}
+
+// Server is a fake polly and s3 endpoint. It is safe for concurrent use.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	script   []Task
+	tasks    map[string]*task
+	started  []StartedTask
+	objects  map[string]*object // keyed by bucket/key
+	deleted  []string
+	nextID   int
+	lexicons map[string]lexicon
+}
+
+type lexicon struct {
+	content  string
+	modified time.Time
+}
+
+// New starts a Server, callers must Close it.
+func New() *Server {
+	var s = &Server{
+		tasks:    make(map[string]*task),
+		objects:  make(map[string]*object),
+		lexicons: make(map[string]lexicon),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Script queues the behaviour of the next synthesis tasks.
+func (s *Server) Script(tasks ...Task) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.script = append(s.script, tasks...)
+}
+
+// Started returns every StartSpeechSynthesisTask call in the order received.
+func (s *Server) Started() []StartedTask {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.started)
+}
+
+// Objects returns the bucket/key of every object currently stored, sorted.
+func (s *Server) Objects() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys = make([]string, 0, len(s.objects))
+	for k := range s.objects {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// Put stores an object as if it had been written at modified, for objects polly did not write.
+func (s *Server) Put(objKey string, body []byte, modified time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[objKey] = &object{body: body, modified: modified}
+}
+
+// Encryption is the server side encryption an object was last written with.
+type Encryption struct {
+	Algorithm string // x-amz-server-side-encryption, empty when none was requested
+	KMSKeyID  string // x-amz-server-side-encryption-aws-kms-key-id
+}
+
+// Encryption returns the server side encryption of the object at bucket/key.
+func (s *Server) Encryption(objKey string) (Encryption, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var obj, ok = s.objects[objKey]
+	if !ok {
+		return Encryption{}, false
+	}
+	return obj.encryption, true
+}
+
+// Deleted returns the bucket/key of every successfully deleted object in the order they were deleted.
+func (s *Server) Deleted() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.deleted)
+}
+
+// PollyClient returns a polly client that talks to the server with static credentials and no retries.
+func (s *Server) PollyClient() *polly.Client {
+	return polly.New(polly.Options{
+		Region:           "us-west-2",
+		BaseEndpoint:     aws.String(s.URL),
+		Credentials:      credentials.NewStaticCredentialsProvider("fake", "fake", ""),
+		HTTPClient:       s.Client(),
+		RetryMaxAttempts: 1,
+	})
+}
+
+// S3Client returns a path style s3 client that talks to the server with static credentials and no retries.
+func (s *Server) S3Client() *s3.Client {
+	return s3.New(s3.Options{
+		Region:           "us-west-2",
+		BaseEndpoint:     aws.String(s.URL),
+		Credentials:      credentials.NewStaticCredentialsProvider("fake", "fake", ""),
+		HTTPClient:       s.Client(),
+		RetryMaxAttempts: 1,
+		UsePathStyle:     true,
+	})
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == synthesisTasksPath && r.Method == http.MethodPost:
+		s.startSpeechSynthesisTask(w, r)
+	case strings.HasPrefix(r.URL.Path, synthesisTasksPath+"/") && r.Method == http.MethodGet:
+		s.getSpeechSynthesisTask(w, strings.TrimPrefix(r.URL.Path, synthesisTasksPath+"/"))
+	case r.URL.Path == voicesPath && r.Method == http.MethodGet:
+		s.describeVoices(w, r)
+	case r.URL.Path == lexiconsPath && r.Method == http.MethodGet:
+		s.listLexicons(w)
+	case strings.HasPrefix(r.URL.Path, lexiconsPath+"/"):
+		s.lexicon(w, r, strings.TrimPrefix(r.URL.Path, lexiconsPath+"/"))
+	case r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		s.listObjects(w, strings.Trim(r.URL.Path, "/"), r.URL.Query().Get("prefix"))
+	case r.Method == http.MethodGet:
+		s.getObject(w, strings.TrimPrefix(r.URL.Path, "/"))
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		s.copyObject(w, r, strings.TrimPrefix(r.URL.Path, "/"))
+	case r.Method == http.MethodDelete:
+		s.deleteObject(w, strings.TrimPrefix(r.URL.Path, "/"))
+	default:
+		writeS3Error(w, http.StatusNotImplemented, "NotImplemented", r.Method+" "+r.URL.Path)
+	}
+}
+
+// Voice is a polly voice as DescribeVoices returns it.
+type Voice struct {
+	ID                      string `json:"Id"`
+	Name                    string
+	Gender                  string
+	LanguageCode            string
+	LanguageName            string
+	AdditionalLanguageCodes []string `json:",omitempty"`
+	SupportedEngines        []string
+}
+
+// Voices are the voices DescribeVoices pages through, two per page.
+var Voices = []Voice{
+	{ID: "Aditi", Name: "Aditi", Gender: "Female", LanguageCode: "hi-IN", LanguageName: "Hindi", AdditionalLanguageCodes: []string{"en-IN"}, SupportedEngines: []string{"standard"}},
+	{ID: "Hans", Name: "Hans", Gender: "Male", LanguageCode: "de-DE", LanguageName: "German", SupportedEngines: []string{"standard"}},
+	{ID: "Joanna", Name: "Joanna", Gender: "Female", LanguageCode: "en-US", LanguageName: "US English", SupportedEngines: []string{"neural", "standard"}},
+	{ID: "Matthew", Name: "Matthew", Gender: "Male", LanguageCode: "en-US", LanguageName: "US English", SupportedEngines: []string{"generative", "neural", "standard"}},
+	{ID: "Vicki", Name: "Vicki", Gender: "Female", LanguageCode: "de-DE", LanguageName: "German", SupportedEngines: []string{"neural", "standard"}},
+}
+
+// describeVoices filters Voices by the Engine and LanguageCode query parameters.
+func (s *Server) describeVoices(w http.ResponseWriter, r *http.Request) {
+	var query = r.URL.Query()
+	var voices []Voice
+	for _, v := range Voices {
+		var language = query.Get("LanguageCode")
+		var speaks = language == "" || v.LanguageCode == language ||
+			query.Get("IncludeAdditionalLanguageCodes") == "true" && slices.Contains(v.AdditionalLanguageCodes, language)
+		if speaks && (query.Get("Engine") == "" || slices.Contains(v.SupportedEngines, query.Get("Engine"))) {
+			voices = append(voices, v)
+		}
+	}
+	var start, _ = strconv.Atoi(query.Get("NextToken"))
+	var end = min(start+2, len(voices))
+	var out = struct {
+		Voices    []Voice
+		NextToken string `json:",omitempty"`
+	}{Voices: voices[min(start, end):end]}
+	if end < len(voices) {
+		out.NextToken = strconv.Itoa(end)
+	}
+	writeJSON(w, out)
+}
+
+// Lexicon returns the content of a lexicon put with PutLexicon.
+func (s *Server) Lexicon(name string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var l, ok = s.lexicons[name]
+	return l.content, ok
+}
+
+// lexicon serves PutLexicon and DeleteLexicon.
+func (s *Server) lexicon(w http.ResponseWriter, r *http.Request, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		var in struct{ Content string }
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.Content == "" {
+			writePollyError(w, http.StatusBadRequest, "InvalidLexiconException", "no lexicon content")
+			return
+		}
+		s.lexicons[name] = lexicon{content: in.Content, modified: time.Now()}
+		writeJSON(w, struct{}{})
+	case http.MethodDelete:
+		if _, ok := s.lexicons[name]; !ok {
+			writePollyError(w, http.StatusNotFound, "LexiconNotFoundException", "no lexicon "+name)
+			return
+		}
+		delete(s.lexicons, name)
+		writeJSON(w, struct{}{})
+	default:
+		writePollyError(w, http.StatusNotImplemented, "NotImplemented", r.Method+" "+r.URL.Path)
+	}
+}
+
+func (s *Server) listLexicons(w http.ResponseWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	type attributes struct {
+		Alphabet     string
+		LanguageCode string
+		LastModified float64
+		LexemesCount int
+		Size         int
+	}
+	type description struct {
+		Name       string
+		Attributes attributes
+	}
+	var names = make([]string, 0, len(s.lexicons))
+	for name := range s.lexicons {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var out struct{ Lexicons []description }
+	for _, name := range names {
+		var l = s.lexicons[name]
+		out.Lexicons = append(out.Lexicons, description{Name: name, Attributes: attributes{
+			Alphabet:     xmlAttr(l.content, "alphabet"),
+			LanguageCode: xmlAttr(l.content, "xml:lang"),
+			LastModified: float64(l.modified.Unix()),
+			LexemesCount: strings.Count(l.content, "<lexeme>"),
+			Size:         len(l.content),
+		}})
+	}
+	writeJSON(w, out)
+}
+
+// xmlAttr finds the first value of the attribute in an xml document.
+func xmlAttr(content, name string) string {
+	var _, rest, ok = strings.Cut(content, " "+name+`="`)
+	if !ok {
+		return ""
+	}
+	var value, _, _ = strings.Cut(rest, `"`)
+	return value
+}
+
+func (s *Server) startSpeechSynthesisTask(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Engine             string
+		LanguageCode       string
+		LexiconNames       []string
+		OutputFormat       string
+		OutputS3BucketName string
+		OutputS3KeyPrefix  string
+		SampleRate         string
+		SpeechMarkTypes    []string
+		Text               string
+		TextType           string
+		VoiceID            string `json:"VoiceId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writePollyError(w, http.StatusBadRequest, "ValidationException", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	var script Task
+	if len(s.script) > 0 && in.OutputFormat != "json" {
+		script, s.script = s.script[0], s.script[1:]
+	}
+	s.nextID++
+	var t = &task{
+		StartedTask: StartedTask{
+			TaskID:            fmt.Sprintf("00000000-0000-4000-8000-%012d", s.nextID),
+			Text:              in.Text,
+			VoiceID:           in.VoiceID,
+			Engine:            in.Engine,
+			LanguageCode:      in.LanguageCode,
+			OutputFormat:      in.OutputFormat,
+			OutputS3Bucket:    in.OutputS3BucketName,
+			OutputS3KeyPrefix: in.OutputS3KeyPrefix,
+			SampleRate:        in.SampleRate,
+			TextType:          in.TextType,
+			LexiconNames:      in.LexiconNames,
+			SpeechMarkTypes:   in.SpeechMarkTypes,
+		},
+		script: script,
+	}
+	t.key = in.OutputS3KeyPrefix + t.TaskID + "." + extension(in.OutputFormat)
+	if script.StartError == "" {
+		s.tasks[t.TaskID] = t
+		s.started = append(s.started, t.StartedTask)
+	}
+	var body = s.taskJSON(t, "scheduled")
+	s.mu.Unlock()
+
+	time.Sleep(script.Latency)
+	if script.StartError != "" {
+		writePollyError(w, http.StatusBadRequest, "ValidationException", script.StartError)
+		return
+	}
+	writeJSON(w, body)
+}
+
+func (s *Server) getSpeechSynthesisTask(w http.ResponseWriter, taskID string) {
+	s.mu.Lock()
+	var t, ok = s.tasks[taskID]
+	if !ok {
+		s.mu.Unlock()
+		writePollyError(w, http.StatusNotFound, "SynthesisTaskNotFoundException", "no task "+taskID)
+		return
+	}
+	t.polls++
+	var status = "inProgress"
+	var audio, audioStarted = s.audioTask(t)
+	switch {
+	case t.OutputFormat == "json" && !audioStarted:
+	case t.polls <= t.script.Pending:
+	case t.script.FailReason != "":
+		status = "failed"
+	default:
+		status = "completed"
+		var objKey = t.OutputS3Bucket + "/" + t.key
+		if _, exists := s.objects[objKey]; !exists && !slices.Contains(s.deleted, objKey) {
+			s.objects[objKey] = &object{
+				body:       output(t, audio),
+				modified:   time.Now(),
+				denyGet:    t.script.DenyGet,
+				denyDelete: t.script.DenyDelete,
+			}
+		}
+	}
+	var body = s.taskJSON(t, status)
+	s.mu.Unlock()
+
+	time.Sleep(t.script.Latency)
+	writeJSON(w, body)
+}
+
+// audioTask finds the audio task a speech marks task belongs to, callers hold s.mu.
+func (s *Server) audioTask(t *task) (*task, bool) {
+	if t.OutputFormat != "json" {
+		return t, true
+	}
+	for i := len(s.started) - 1; i >= 0; i-- {
+		var started = s.tasks[s.started[i].TaskID]
+		if started.OutputFormat != "json" && started.Text == t.Text && started.VoiceID == t.VoiceID {
+			return started, true
+		}
+	}
+	return nil, false
+}
+
+// output generates the audio or speech marks for a completed task. The fake cannot encode ogg so
+// every audio format other than pcm gets an mp3.
+func output(t, audio *task) []byte {
+	var d = audio.script.Duration
+	if d == 0 {
+		d = defaultDuration
+	}
+	if t.OutputFormat == "json" {
+		var text = t.Text
+		if t.TextType == "ssml" {
+			text = maskTags(text)
+		}
+		return SpeechMarks(text, t.SpeechMarkTypes, d)
+	}
+	var rate, _ = strconv.Atoi(t.SampleRate)
+	if t.OutputFormat == "pcm" {
+		return SilentPCM(d, rate)
+	}
+	return SilentMP3(d, rate)
+}
+
+// taskJSON renders the SynthesisTask response, callers hold s.mu.
+func (s *Server) taskJSON(t *task, status string) map[string]any {
+	var synthesisTask = map[string]any{
+		"TaskId":            t.TaskID,
+		"TaskStatus":        status,
+		"OutputFormat":      t.OutputFormat,
+		"VoiceId":           t.VoiceID,
+		"RequestCharacters": len(t.Text),
+		"CreationTime":      time.Now().Unix(),
+		"OutputUri":         s.URL + "/" + t.OutputS3Bucket + "/" + t.key,
+	}
+	if status == "failed" {
+		synthesisTask["TaskStatusReason"] = t.script.FailReason
+	}
+	return map[string]any{"SynthesisTask": synthesisTask}
+}
+
+func (s *Server) getObject(w http.ResponseWriter, objKey string) {
+	s.mu.Lock()
+	var obj, ok = s.objects[objKey]
+	s.mu.Unlock()
+	switch {
+	case !ok:
+		writeS3Error(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+	case obj.denyGet:
+		writeS3Error(w, http.StatusForbidden, "AccessDenied", "Access Denied")
+	default:
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.body)))
+		_, _ = w.Write(obj.body)
+	}
+}
+
+// listObjects answers ListObjectsV2 with every object of the bucket under prefix in a single page.
+func (s *Server) listObjects(w http.ResponseWriter, bucket, prefix string) {
+	type content struct {
+		Key          string
+		LastModified string
+		Size         int
+	}
+	var out = struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		Name        string
+		Prefix      string
+		KeyCount    int
+		IsTruncated bool
+		Contents    []content
+	}{Name: bucket, Prefix: prefix}
+	s.mu.Lock()
+	for objKey, obj := range s.objects {
+		if key, ok := strings.CutPrefix(objKey, bucket+"/"); ok && strings.HasPrefix(key, prefix) {
+			out.Contents = append(out.Contents, content{Key: key, LastModified: obj.modified.UTC().Format(time.RFC3339), Size: len(obj.body)})
+		}
+	}
+	s.mu.Unlock()
+	slices.SortFunc(out.Contents, func(a, b content) int { return strings.Compare(a.Key, b.Key) })
+	out.KeyCount = len(out.Contents)
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = io.WriteString(w, xml.Header)
+	_ = xml.NewEncoder(w).Encode(out)
+}
+
+// copyObject answers CopyObject, copying the source object's body and recording the encryption requested for the copy.
+func (s *Server) copyObject(w http.ResponseWriter, r *http.Request, objKey string) {
+	source, err := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", err.Error())
+		return
+	}
+	s.mu.Lock()
+	var src, ok = s.objects[strings.TrimPrefix(source, "/")]
+	if ok {
+		s.objects[objKey] = &object{body: src.body, modified: time.Now(), encryption: Encryption{
+			Algorithm: r.Header.Get("X-Amz-Server-Side-Encryption"),
+			KMSKeyID:  r.Header.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"),
+		}}
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeS3Error(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = io.WriteString(w, xml.Header)
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName      xml.Name `xml:"CopyObjectResult"`
+		LastModified string
+	}{LastModified: time.Now().UTC().Format(time.RFC3339)})
+}
+
+func (s *Server) deleteObject(w http.ResponseWriter, objKey string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if obj, ok := s.objects[objKey]; ok {
+		if obj.denyDelete {
+			writeS3Error(w, http.StatusForbidden, "AccessDenied", "Access Denied")
+			return
+		}
+		delete(s.objects, objKey)
+		s.deleted = append(s.deleted, objKey)
+	}
+	// s3 reports success for keys that do not exist
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// extension mirrors the file extensions polly uses for each OutputFormat.
+func extension(outputFormat string) string {
+	switch outputFormat {
+	case "ogg_vorbis", "ogg_opus":
+		return "ogg"
+	case "json":
+		return "marks"
+	case "":
+		return "mp3"
+	default:
+		return outputFormat
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writePollyError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("X-Amzn-Errortype", code)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintf(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<Error><Code>%s</Code><Message>%s</Message></Error>", code, message)
+}
@@ -0,0 +1,83 @@
+package fakeaws
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// SpeechMarks returns polly style json lines speech marks for text spoken over d. Words are spread
+// evenly across d, sentences end at '.', '!' or '?'. Only sentence and word marks are generated.
+func SpeechMarks(text string, markTypes []string, d time.Duration) []byte {
+	var wordDuration = d / time.Duration(max(len(strings.Fields(text)), 1))
+	var buf bytes.Buffer
+	var enc = json.NewEncoder(&buf)
+	var sentenceStart = -1
+	var words int
+	for start, end := nextWord(text, 0); start < len(text); start, end = nextWord(text, end) {
+		var t = int64(time.Duration(words) * wordDuration / time.Millisecond)
+		if sentenceStart < 0 && slices.Contains(markTypes, "sentence") {
+			sentenceStart = start
+			var sentenceEnd = strings.IndexAny(text[start:], ".!?")
+			if sentenceEnd < 0 {
+				sentenceEnd = len(text)
+			} else {
+				sentenceEnd += start + 1
+			}
+			_ = enc.Encode(mark{Time: t, Type: "sentence", Start: start, End: sentenceEnd, Value: text[start:sentenceEnd]})
+		}
+		if slices.Contains(markTypes, "word") {
+			_ = enc.Encode(mark{Time: t, Type: "word", Start: start, End: end, Value: strings.TrimRight(text[start:end], ".,!?;:")})
+		}
+		if strings.ContainsAny(text[end-1:end], ".!?") {
+			sentenceStart = -1
+		}
+		words++
+	}
+	return buf.Bytes()
+}
+
+type mark struct {
+	Time  int64  `json:"time"`
+	Type  string `json:"type"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Value string `json:"value"`
+}
+
+// nextWord returns the byte range of the next whitespace separated word at or after from.
+func nextWord(text string, from int) (int, int) {
+	var start = strings.IndexFunc(text[from:], func(r rune) bool { return !unicode.IsSpace(r) })
+	if start < 0 {
+		return len(text), len(text)
+	}
+	start += from
+	var end = strings.IndexFunc(text[start:], unicode.IsSpace)
+	if end < 0 {
+		return start, len(text)
+	}
+	return start, start + end
+}
+
+// maskTags blanks out the tags of ssml so only the text is marked, offsets stay those of the ssml
+// as they are with polly.
+func maskTags(ssml string) string {
+	var b = []byte(ssml)
+	var inTag bool
+	for i, c := range b {
+		switch {
+		case c == '<':
+			inTag = true
+		case c == '>' && inTag:
+			inTag = false
+			b[i] = ' '
+		}
+		if inTag {
+			b[i] = ' '
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go-v2/service/polly/types"
+)
+
+const (
+	minLanguageLetters    = 20   // shorter paragraphs are too short to tell
+	minLanguageConfidence = 0.15 // below this a paragraph's language is taken as unknown
+	languageVocabulary    = 5000 // trigrams a language uses, for smoothing
+)
+
+var (
+	errInvalidLanguageVoice = errors.New("invalid language voice, expected language=VoiceId, e.g. de=Vicki or pt-PT=Ines")
+	errNoLanguageVoice      = errors.New("no default voice supports the -engine for the language, pick one with -language-voices")
+)
+
+// language is a language that can be detected, with the polly language code and the voice it is
+// read with by default.
+type language struct {
+	code  types.LanguageCode
+	voice string
+}
+
+// languages by ISO 639-1 code. The default voices are standard voices, which every region has, and
+// are used when no -engine is set.
+var languages = map[string]language{
+	"ar": {types.LanguageCodeArb, "Zeina"},
+	"da": {types.LanguageCodeDaDk, "Naja"},
+	"de": {types.LanguageCodeDeDe, "Marlene"},
+	"en": {types.LanguageCodeEnUs, "Joanna"},
+	"es": {types.LanguageCodeEsEs, "Conchita"},
+	"fr": {types.LanguageCodeFrFr, "Celine"},
+	"hi": {types.LanguageCodeHiIn, "Aditi"},
+	"it": {types.LanguageCodeItIt, "Carla"},
+	"ja": {types.LanguageCodeJaJp, "Mizuki"},
+	"ko": {types.LanguageCodeKoKr, "Seoyeon"},
+	"nb": {types.LanguageCodeNbNo, "Liv"},
+	"nl": {types.LanguageCodeNlNl, "Lotte"},
+	"pl": {types.LanguageCodePlPl, "Ewa"},
+	"pt": {types.LanguageCodePtBr, "Vitoria"},
+	"ro": {types.LanguageCodeRoRo, "Carmen"},
+	"ru": {types.LanguageCodeRuRu, "Tatyana"},
+	"sv": {types.LanguageCodeSvSe, "Astrid"},
+	"tr": {types.LanguageCodeTrTr, "Filiz"},
+	"zh": {types.LanguageCodeCmnCn, "Zhiyu"},
+}
+
+// neuralLanguages are the default voices when the engine is neural, polly has no neural voice for
+// the languages left out. Arabic is read in its Gulf variety, the only one with a neural voice.
+var neuralLanguages = map[string]language{
+	"ar": {types.LanguageCodeArAe, "Hala"},
+	"da": {types.LanguageCodeDaDk, "Sofie"},
+	"de": {types.LanguageCodeDeDe, "Vicki"},
+	"en": {types.LanguageCodeEnUs, "Joanna"},
+	"es": {types.LanguageCodeEsEs, "Lucia"},
+	"fr": {types.LanguageCodeFrFr, "Lea"},
+	"hi": {types.LanguageCodeHiIn, "Kajal"},
+	"it": {types.LanguageCodeItIt, "Bianca"},
+	"ja": {types.LanguageCodeJaJp, "Takumi"},
+	"ko": {types.LanguageCodeKoKr, "Seoyeon"},
+	"nb": {types.LanguageCodeNbNo, "Ida"},
+	"nl": {types.LanguageCodeNlNl, "Laura"},
+	"pl": {types.LanguageCodePlPl, "Ola"},
+	"pt": {types.LanguageCodePtBr, "Camila"},
+	"sv": {types.LanguageCodeSvSe, "Elin"},
+	"tr": {types.LanguageCodeTrTr, "Burcu"},
+	"zh": {types.LanguageCodeCmnCn, "Zhiyu"},
+}
+
+// scriptLanguages are the languages told apart by their script alone.
+var scriptLanguages = []struct {
+	script   *unicode.RangeTable
+	language string
+}{
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Hangul, "ko"},
+	{unicode.Han, "zh"},
+	{unicode.Cyrillic, "ru"},
+	{unicode.Arabic, "ar"},
+	{unicode.Devanagari, "hi"},
+}
+
+// languageSamples are the text the trigram profiles of the languages written in latin script are
+// built from.
+var languageSamples = map[string]string{
+	"da": `Alle mennesker er født frie og lige i værdighed og rettigheder. De er udstyret med fornuft og samvittighed, og de bør handle mod hverandre i en broderskabets ånd. Vi har været i byen hele dagen, og nu skal vi hjem og spise aftensmad med børnene. Det er ikke så nemt at finde vej, når man ikke kender gaderne, men heldigvis hjælper folk gerne. Hvad synes du om det nye hus, som de har købt ude på landet? Vi ses i morgen efter arbejde, hvis vejret tillader det. Jeg kan ikke huske, hvor jeg har lagt nøglerne, men de må være her et eller andet sted.`,
+	"de": `Alle Menschen sind frei und gleich an Würde und Rechten geboren. Sie sind mit Vernunft und Gewissen begabt und sollen einander im Geist der Brüderlichkeit begegnen. Wir haben den ganzen Tag in der Stadt verbracht und wollen jetzt nach Hause gehen, um mit den Kindern zu essen. Es ist nicht so einfach, den Weg zu finden, wenn man die Straßen nicht kennt, aber zum Glück helfen die Leute gerne. Was hältst du von dem neuen Haus, das sie auf dem Land gekauft haben?`,
+	"en": `All human beings are born free and equal in dignity and rights. They are endowed with reason and conscience and should act towards one another in a spirit of brotherhood. We have spent the whole day in the city and now we want to go home and have dinner with the children. It is not that easy to find the way when you do not know the streets, but luckily people are happy to help. What do you think of the new house that they bought in the country?`,
+	"es": `Todos los seres humanos nacen libres e iguales en dignidad y derechos y, dotados como están de razón y conciencia, deben comportarse fraternalmente los unos con los otros. Hemos pasado todo el día en la ciudad y ahora queremos volver a casa para cenar con los niños. No es tan fácil encontrar el camino cuando no se conocen las calles, pero por suerte la gente ayuda con gusto. ¿Qué te parece la casa nueva que han comprado en el campo?`,
+	"fr": `Tous les êtres humains naissent libres et égaux en dignité et en droits. Ils sont doués de raison et de conscience et doivent agir les uns envers les autres dans un esprit de fraternité. Nous avons passé toute la journée en ville et maintenant nous voulons rentrer à la maison pour dîner avec les enfants. Ce n'est pas si facile de trouver le chemin quand on ne connaît pas les rues, mais heureusement les gens aident volontiers. Que penses-tu de la nouvelle maison qu'ils ont achetée à la campagne ?`,
+	"it": `Tutti gli esseri umani nascono liberi ed eguali in dignità e diritti. Essi sono dotati di ragione e di coscienza e devono agire gli uni verso gli altri in spirito di fratellanza. Abbiamo passato tutta la giornata in città e adesso vogliamo tornare a casa per cenare con i bambini. Non è così facile trovare la strada quando non si conoscono le vie, ma per fortuna la gente aiuta volentieri. Che cosa pensi della nuova casa che hanno comprato in campagna?`,
+	"nb": `Alle mennesker er født frie og med samme menneskeverd og menneskerettigheter. De er utstyrt med fornuft og samvittighet og bør handle mot hverandre i brorskapets ånd. Vi har vært i byen hele dagen, og nå vil vi hjem og spise middag med barna. Det er ikke så lett å finne veien når man ikke kjenner gatene, men heldigvis hjelper folk gjerne til. Hva synes du om det nye huset som de har kjøpt på landet? Vi sees i morgen etter jobb hvis været tillater det. Jeg husker ikke hvor jeg har lagt nøklene, men de må være her et eller annet sted.`,
+	"nl": `Alle mensen worden vrij en gelijk in waardigheid en rechten geboren. Zij zijn begiftigd met verstand en geweten, en behoren zich jegens elkander in een geest van broederschap te gedragen. We hebben de hele dag in de stad doorgebracht en nu willen we naar huis om met de kinderen te eten. Het is niet zo makkelijk om de weg te vinden als je de straten niet kent, maar gelukkig helpen de mensen graag. Wat vind jij van het nieuwe huis dat ze op het platteland hebben gekocht?`,
+	"pl": `Wszyscy ludzie rodzą się wolni i równi pod względem swej godności i swych praw. Są oni obdarzeni rozumem i sumieniem i powinni postępować wobec innych w duchu braterstwa. Spędziliśmy cały dzień w mieście i teraz chcemy wrócić do domu, żeby zjeść kolację z dziećmi. Nie jest tak łatwo znaleźć drogę, kiedy nie zna się ulic, ale na szczęście ludzie chętnie pomagają. Co myślisz o nowym domu, który kupili na wsi?`,
+	"pt": `Todos os seres humanos nascem livres e iguais em dignidade e em direitos. Dotados de razão e de consciência, devem agir uns para com os outros em espírito de fraternidade. Passamos o dia inteiro na cidade e agora queremos voltar para casa para jantar com as crianças. Não é tão fácil encontrar o caminho quando não se conhecem as ruas, mas felizmente as pessoas ajudam com prazer. O que você acha da casa nova que eles compraram no interior?`,
+	"ro": `Toate ființele umane se nasc libere și egale în demnitate și în drepturi. Ele sunt înzestrate cu rațiune și conștiință și trebuie să se comporte unele față de altele în spiritul fraternității. Am petrecut toată ziua în oraș și acum vrem să mergem acasă să luăm cina cu copiii. Nu este atât de ușor să găsești drumul când nu cunoști străzile, dar din fericire oamenii ajută cu plăcere. Ce părere ai despre casa nouă pe care au cumpărat-o la țară?`,
+	"sv": `Alla människor är födda fria och lika i värde och rättigheter. De har utrustats med förnuft och samvete och bör handla gentemot varandra i en anda av broderskap. Vi har varit i staden hela dagen och nu vill vi åka hem och äta middag med barnen. Det är inte så lätt att hitta vägen när man inte känner till gatorna, men som tur är hjälper folk gärna till. Vad tycker du om det nya huset som de har köpt ute på landet?`,
+	"tr": `Bütün insanlar hür, haysiyet ve haklar bakımından eşit doğarlar. Akıl ve vicdana sahiptirler ve birbirlerine karşı kardeşlik zihniyeti ile hareket etmelidirler. Bütün günü şehirde geçirdik ve şimdi çocuklarla akşam yemeği yemek için eve dönmek istiyoruz. Sokakları bilmediğin zaman yolu bulmak o kadar kolay değil, ama neyse ki insanlar seve seve yardım ediyor. Kırsalda satın aldıkları yeni ev hakkında ne düşünüyorsun? Hava güzel olursa yarın işten sonra görüşürüz. Anahtarları nereye koyduğumu hatırlayamıyorum, ama burada bir yerde olmalılar. Bu kitabı okuduktan sonra sana vereceğim.`,
+}
+
+// languageProfiles are the trigram counts of languageSamples.
+var languageProfiles = func() map[string]map[string]int {
+	var profiles = make(map[string]map[string]int, len(languageSamples))
+	for lang, sample := range languageSamples {
+		profiles[lang] = trigrams(sample)
+	}
+	return profiles
+}()
+
+// trigrams counts the letter trigrams of the words in text, a word is padded with a space on each side.
+func trigrams(text string) map[string]int {
+	var counts = make(map[string]int)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		var runes = []rune(" " + word + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			counts[string(runes[i:i+3])]++
+		}
+	}
+	return counts
+}
+
+// detectLanguage returns the language of text and how confident it is, from 0 to 1. The script
+// decides between the languages written in their own script, the others are told apart by how
+// similar their trigrams are to those of each language's sample.
+func detectLanguage(text string) (string, float64) {
+	var letters int
+	var scripts = make(map[string]int)
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		for _, s := range scriptLanguages {
+			if unicode.Is(s.script, r) {
+				scripts[s.language]++
+				break
+			}
+		}
+	}
+	if letters == 0 {
+		return "", 0
+	}
+	// japanese mixes kana with han, any kana at all makes it japanese
+	if scripts["ja"] > 0 {
+		return "ja", float64(scripts["ja"]+scripts["zh"]) / float64(letters)
+	}
+	for lang, n := range scripts {
+		if n*2 > letters {
+			return lang, float64(n) / float64(letters)
+		}
+	}
+	if letters < minLanguageLetters {
+		return "", 0
+	}
+
+	// naive bayes over the trigrams, with add one smoothing for those missing from a sample
+	var grams = trigrams(text)
+	var langs = slices.Sorted(maps.Keys(languageProfiles))
+	var scores = make([]float64, len(langs))
+	var n int
+	for i, l := range langs {
+		var profile = languageProfiles[l]
+		var total float64
+		for _, c := range profile {
+			total += float64(c)
+		}
+		for g, c := range grams {
+			scores[i] += float64(c) * math.Log((float64(profile[g])+1)/(total+languageVocabulary))
+			if i == 0 {
+				n += c
+			}
+		}
+	}
+	var best = slices.Index(scores, slices.Max(scores))
+	// the posterior of the best language, tempered by the number of trigrams as they are far from independent
+	var sum float64
+	for _, score := range scores {
+		sum += math.Exp((score - scores[best]) / math.Sqrt(float64(n)))
+	}
+	return langs[best], 1 / sum
+}
+
+// keepLetters is a strings.Map mapping that drops everything but letters.
+func keepLetters(r rune) rune {
+	if unicode.IsLetter(r) {
+		return r
+	}
+	return -1
+}
+
+// parseLanguageVoices parses the comma separated -language-voices flag, language=VoiceId pairs that
+// replace the default voice of a language. A language with a region, e.g. pt-PT, also sets the
+// language code of the tasks. The defaults are those of the engine, the long-form and generative
+// engines have none.
+func parseLanguageVoices(list string, engine types.Engine) (map[string]language, error) {
+	var voices = make(map[string]language)
+	switch engine {
+	case "", types.EngineStandard:
+		voices = maps.Clone(languages)
+	case types.EngineNeural:
+		voices = maps.Clone(neuralLanguages)
+	}
+	for _, entry := range strings.Split(list, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		var key, voice, ok = strings.Cut(entry, "=")
+		key, voice = strings.TrimSpace(key), strings.TrimSpace(voice)
+		var base, _, _ = strings.Cut(strings.ToLower(key), "-")
+		var lang, known = voices[base]
+		if !known {
+			lang, known = languages[base]
+		}
+		if !ok || !known {
+			return nil, fmt.Errorf("%w: %s", errInvalidLanguageVoice, entry)
+		}
+		if !slices.Contains(types.VoiceId("").Values(), types.VoiceId(voice)) {
+			return nil, fmt.Errorf("%w: %s is not an AWS Polly VoiceID", errInvalidLanguageVoice, voice)
+		}
+		lang.voice = voice
+		if strings.Contains(key, "-") {
+			var i = slices.IndexFunc(types.LanguageCode("").Values(), func(c types.LanguageCode) bool { return strings.EqualFold(string(c), key) })
+			if i < 0 {
+				return nil, fmt.Errorf("%w: %s is not an AWS Polly language code", errInvalidLanguageVoice, key)
+			}
+			lang.code = types.LanguageCode("").Values()[i]
+		}
+		voices[base] = lang
+	}
+	return voices, nil
+}
+
+// languageSegments splits the sections of the document into segments where the language of its
+// paragraphs changes. The document's main language is read with voice, the others with their voice
+// from voices and their language code. Paragraphs whose language is uncertain are read with voice
+// too, those too short to tell keep the voice of the paragraph before them. Dialogues and subtitles
+// are left alone, as is a document in a single language. A language without a voice in voices is an error.
+func languageSegments(doc document, voices map[string]language, voice string) (document, error) {
+	if doc.cues != nil || doc.segments != nil {
+		return doc, nil
+	}
+	type paragraph struct {
+		start, end int    // in the section
+		lang       string // ? when it is too short to tell
+	}
+	var sections = doc.sections()
+	var paragraphs = make([][]paragraph, len(sections))
+	var letters = make(map[string]int)
+	for i, section := range sections {
+		var start int
+		for _, gap := range append(blankLineRegex.FindAllStringIndex(section, -1), []int{len(section), len(section)}) {
+			var p = paragraph{start: start, end: gap[0]}
+			start = gap[1]
+			var text = section[p.start:p.end]
+			if strings.TrimSpace(text) == "" {
+				continue
+			}
+			var lang, confidence = detectLanguage(text)
+			switch {
+			case lang == "" && len(strings.Map(keepLetters, text)) < minLanguageLetters:
+				p.lang = "?"
+			case confidence >= minLanguageConfidence:
+				p.lang = lang
+				letters[lang] += len(text)
+			}
+			paragraphs[i] = append(paragraphs[i], p)
+		}
+	}
+	var main string
+	for _, lang := range slices.Sorted(maps.Keys(letters)) {
+		if letters[lang] > letters[main] {
+			main = lang
+		}
+	}
+	if len(letters) < 2 {
+		return doc, nil
+	}
+
+	var segments []segment
+	for i, section := range sections {
+		var current *segment
+		var start int
+		for _, p := range paragraphs[i] {
+			var s = segment{voiceID: voice}
+			switch {
+			case p.lang == "?" && current != nil:
+				s = *current
+			case p.lang != "" && p.lang != "?" && p.lang != main:
+				var lang, ok = voices[p.lang]
+				if !ok {
+					return doc, fmt.Errorf("%w: %s", errNoLanguageVoice, p.lang)
+				}
+				s = segment{voiceID: lang.voice, languageCode: lang.code}
+			}
+			if current != nil && current.voiceID == s.voiceID && current.languageCode == s.languageCode {
+				current.text = section[start:p.end]
+				continue
+			}
+			start = p.start
+			s.text = section[p.start:p.end]
+			segments = append(segments, s)
+			current = &segments[len(segments)-1]
+		}
+	}
+	for i := range segments {
+		segments[i].text = strings.TrimSpace(segments[i].text)
+	}
+	doc.segments = segments
+	return doc, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/polly/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/kmulvey/text2speech/internal/fakeaws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	t.Parallel()
+
+	for text, want := range map[string]string{
+		"The meeting has been moved to Thursday afternoon because several people are travelling this week.":             "en",
+		"Die Besprechung wurde auf Donnerstagnachmittag verschoben, weil mehrere Kollegen diese Woche unterwegs sind.":  "de",
+		"La réunion a été déplacée à jeudi après-midi car plusieurs collègues sont en déplacement cette semaine.":       "fr",
+		"La reunión se ha trasladado al jueves por la tarde porque varios compañeros están de viaje esta semana.":       "es",
+		"Spotkanie zostało przeniesione na czwartek po południu, ponieważ kilku kolegów jest w tym tygodniu w podróży.": "pl",
+		"Встреча перенесена на вечер четверга.":                                                                         "ru",
+		"会議は木曜日の午後に変更されました。":                                                                                            "ja",
+		"会议改到星期四下午。": "zh",
+	} {
+		lang, confidence := detectLanguage(text)
+		assert.Equal(t, want, lang, text)
+		assert.GreaterOrEqual(t, confidence, minLanguageConfidence, text)
+	}
+
+	// too short to tell, or not a language we know
+	lang, _ := detectLanguage("Chapter 2")
+	assert.Empty(t, lang)
+	_, confidence := detectLanguage("Lorem ipsum dolor sit amet, consectetur adipiscing elit.")
+	assert.Less(t, confidence, minLanguageConfidence)
+}
+
+func TestParseLanguageVoices(t *testing.T) {
+	t.Parallel()
+
+	voices, err := parseLanguageVoices("de=Hans, pt-PT=Ines", "")
+	assert.NoError(t, err)
+	assert.Equal(t, language{types.LanguageCodeDeDe, "Hans"}, voices["de"])
+	assert.Equal(t, language{types.LanguageCodePtPt, "Ines"}, voices["pt"])
+	assert.Equal(t, languages["fr"], voices["fr"])
+	assert.Equal(t, "Marlene", languages["de"].voice, "the defaults are left alone")
+
+	for _, list := range []string{"de", "xx=Hans", "de=Robot", "de-XX=Hans"} {
+		_, err = parseLanguageVoices(list, "")
+		assert.ErrorIs(t, err, errInvalidLanguageVoice, list)
+	}
+	for _, lang := range languages {
+		assert.Contains(t, types.VoiceId("").Values(), types.VoiceId(lang.voice))
+	}
+
+	// the defaults support the engine, languages without a voice for it are left out
+	voices, err = parseLanguageVoices("ro=Carmen", types.EngineNeural)
+	assert.NoError(t, err)
+	assert.Equal(t, "Vicki", voices["de"].voice)
+	assert.Equal(t, language{types.LanguageCodeRoRo, "Carmen"}, voices["ro"])
+	assert.NotContains(t, voices, "ru")
+	for lang, neural := range neuralLanguages {
+		assert.Contains(t, languages, lang)
+		assert.Contains(t, types.VoiceId("").Values(), types.VoiceId(neural.voice))
+	}
+	voices, err = parseLanguageVoices("", types.EngineGenerative)
+	assert.NoError(t, err)
+	assert.Empty(t, voices)
+}
+
+const mixedLanguageInput = `The meeting has been moved to Thursday afternoon because several people are travelling this week.
+
+Please forward the new time to everyone on the team.
+
+Deutsch
+
+Die Besprechung wurde auf Donnerstagnachmittag verschoben, weil mehrere Kollegen diese Woche unterwegs sind.
+
+Thank you, and see you on Thursday afternoon in the big meeting room.`
+
+func TestLanguageSegments(t *testing.T) {
+	t.Parallel()
+
+	doc, err := languageSegments(document{text: mixedLanguageInput}, languages, "Matthew")
+	assert.NoError(t, err)
+	assert.Equal(t, []segment{
+		{text: "The meeting has been moved to Thursday afternoon because several people are travelling this week.\n\nPlease forward the new time to everyone on the team.\n\nDeutsch", voiceID: "Matthew"},
+		{text: "Die Besprechung wurde auf Donnerstagnachmittag verschoben, weil mehrere Kollegen diese Woche unterwegs sind.", voiceID: "Marlene", languageCode: types.LanguageCodeDeDe},
+		{text: "Thank you, and see you on Thursday afternoon in the big meeting room.", voiceID: "Matthew"},
+	}, doc.segments)
+
+	// a single language is read as before
+	doc, err = languageSegments(document{text: "The meeting has been moved to Thursday.\n\nPlease forward the new time to everyone."}, languages, "Matthew")
+	assert.NoError(t, err)
+	assert.Nil(t, doc.segments)
+
+	// a language without a voice for the engine is reported before anything is synthesized
+	_, err = languageSegments(document{text: mixedLanguageInput}, map[string]language{}, "Matthew")
+	assert.ErrorIs(t, err, errNoLanguageVoice)
+}
+
+func TestHandleOutputLanguages(t *testing.T) {
+	var srv = newFakeAWS(t)
+	srv.Script(fakeaws.Task{Duration: time.Second}, fakeaws.Task{Duration: time.Second}, fakeaws.Task{Duration: time.Second})
+
+	doc, err := languageSegments(document{text: mixedLanguageInput}, languages, "Matthew")
+	assert.NoError(t, err)
+	var synth = synthesisOpts{bucket: "bucket", voiceID: "Matthew"}
+	assert.NoError(t, handleOutput(context.Background(), srv.PollyClient(), srv.S3Client(), make(chan *s3.GetObjectOutput, 5), make(chan string, 100), synth, doc, filepath.Join(t.TempDir(), "out.mp3"), nil))
+
+	var started = srv.Started()
+	assert.Len(t, started, 3)
+	assert.Equal(t, []string{"Matthew", "Marlene", "Matthew"}, []string{started[0].VoiceID, started[1].VoiceID, started[2].VoiceID})
+	assert.Equal(t, []string{"", "de-DE", ""}, []string{started[0].LanguageCode, started[1].LanguageCode, started[2].LanguageCode})
+}
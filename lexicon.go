@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/polly"
+	"github.com/dustin/go-humanize"
+	log "github.com/sirupsen/logrus"
+)
+
+// polly's lexicon limits
+const (
+	maxLexiconChars     = 4000 // characters in a lexicon file
+	maxLexiconReplace   = 100  // characters in a phoneme or alias
+	maxLexiconsPerTask  = 5
+	plsNamespace        = "http://www.w3.org/2005/01/pronunciation-lexicon"
+	lexiconCommandUsage = `usage: text2speech lexicon <command> [flags] [args]
+
+commands:
+  validate <file.pls>...           check lexicon files against the PLS format and polly's limits
+  upload [-name name] <file.pls>   upload a lexicon to polly, named after the file by default
+  list                             list the lexicons in polly
+  delete <name>...                 delete lexicons from polly
+`
+)
+
+var (
+	errInvalidLexicon     = errors.New("invalid lexicon")
+	errInvalidLexiconName = errors.New("lexicon names are 1 to 20 letters and digits")
+	errTooManyLexicons    = fmt.Errorf("polly applies at most %d lexicons", maxLexiconsPerTask)
+	lexiconNameRegex      = regexp.MustCompile(`^[0-9A-Za-z]{1,20}$`)
+	lexiconNameCharsRegex = regexp.MustCompile(`[^0-9A-Za-z]`)
+)
+
+// plsLexicon is a pronunciation lexicon (https://www.w3.org/TR/pronunciation-lexicon/).
+type plsLexicon struct {
+	XMLName  xml.Name `xml:"lexicon"`
+	Version  string   `xml:"version,attr"`
+	Alphabet string   `xml:"alphabet,attr"`
+	Lang     string   `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+	Lexemes  []struct {
+		Graphemes []string `xml:"grapheme"`
+		Phonemes  []string `xml:"phoneme"`
+		Aliases   []string `xml:"alias"`
+	} `xml:"lexeme"`
+}
+
+// parseLexicon parses a PLS lexicon and checks it against what polly accepts.
+func parseLexicon(content []byte) (plsLexicon, error) {
+	var lexicon plsLexicon
+	if err := xml.Unmarshal(content, &lexicon); err != nil {
+		return lexicon, fmt.Errorf("%w: %w", errInvalidLexicon, err)
+	}
+	var problems []string
+	if lexicon.XMLName.Space != plsNamespace {
+		problems = append(problems, "the lexicon element must be in the "+plsNamespace+" namespace")
+	}
+	if lexicon.Version != "1.0" {
+		problems = append(problems, "version must be 1.0")
+	}
+	if lexicon.Alphabet != "ipa" && lexicon.Alphabet != "x-sampa" {
+		problems = append(problems, "alphabet must be ipa or x-sampa")
+	}
+	if lexicon.Lang == "" {
+		problems = append(problems, "xml:lang is missing")
+	}
+	if n := utf8.RuneCount(content); n > maxLexiconChars {
+		problems = append(problems, fmt.Sprintf("%d characters, polly accepts at most %d", n, maxLexiconChars))
+	}
+	if len(lexicon.Lexemes) == 0 {
+		problems = append(problems, "no lexemes")
+	}
+	for i, lexeme := range lexicon.Lexemes {
+		if len(lexeme.Graphemes) == 0 {
+			problems = append(problems, fmt.Sprintf("lexeme %d has no grapheme", i+1))
+		}
+		if len(lexeme.Phonemes) == 0 && len(lexeme.Aliases) == 0 {
+			problems = append(problems, fmt.Sprintf("lexeme %d has no phoneme or alias", i+1))
+		}
+		for _, replacement := range append(slices.Clone(lexeme.Phonemes), lexeme.Aliases...) {
+			if utf8.RuneCountInString(replacement) > maxLexiconReplace {
+				problems = append(problems, fmt.Sprintf("lexeme %d has a replacement longer than %d characters", i+1, maxLexiconReplace))
+			}
+		}
+	}
+	if problems != nil {
+		return lexicon, fmt.Errorf("%w: %s", errInvalidLexicon, strings.Join(problems, "; "))
+	}
+	return lexicon, nil
+}
+
+// readLexicon reads and validates a lexicon file.
+func readLexicon(path string) ([]byte, plsLexicon, error) {
+	//nolint:gosec
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, plsLexicon{}, fmt.Errorf("cannot read lexicon: %w", err)
+	}
+	lexicon, err := parseLexicon(content)
+	if err != nil {
+		return nil, lexicon, fmt.Errorf("%s: %w", path, err)
+	}
+	return content, lexicon, nil
+}
+
+// lexiconName names an uploaded lexicon after its file, dropping what polly does not allow.
+func lexiconName(path string) string {
+	var name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	name = lexiconNameCharsRegex.ReplaceAllString(name, "")
+	return name[:min(len(name), 20)]
+}
+
+// parseLexicons splits the comma separated -lexicon flag into the names of lexicons stored in polly
+// and local lexicon files (anything ending in .pls or .xml), which are read as text substitutions.
+func parseLexicons(list string) ([]string, []plsLexicon, error) {
+	var names []string
+	var files []plsLexicon
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		switch ext := strings.ToLower(filepath.Ext(entry)); {
+		case entry == "":
+		case ext == ".pls" || ext == ".xml":
+			_, lexicon, err := readLexicon(entry)
+			if err != nil {
+				return nil, nil, err
+			}
+			files = append(files, lexicon)
+		case !lexiconNameRegex.MatchString(entry):
+			return nil, nil, fmt.Errorf("%w: %s", errInvalidLexiconName, entry)
+		case !slices.Contains(names, entry):
+			names = append(names, entry)
+		}
+	}
+	if len(names) > maxLexiconsPerTask {
+		return nil, nil, errTooManyLexicons
+	}
+	return names, files, nil
+}
+
+// lexiconRule is a normalization rule that replaces the graphemes of local lexicons with their
+// aliases, for lexicons that are not in polly. Phonemes can only be applied by polly.
+func lexiconRule(lexicons []plsLexicon) (normalizeRule, bool) {
+	var table = make(map[string]string)
+	var skipped int
+	for _, lexicon := range lexicons {
+		for _, lexeme := range lexicon.Lexemes {
+			if len(lexeme.Aliases) == 0 {
+				skipped++
+				continue
+			}
+			for _, grapheme := range lexeme.Graphemes {
+				table[strings.TrimSpace(grapheme)] = strings.TrimSpace(lexeme.Aliases[0])
+			}
+		}
+	}
+	if skipped > 0 {
+		log.Warnf("%d lexemes only have phonemes, upload the lexicon with text2speech lexicon upload to use them", skipped)
+	}
+	if len(table) == 0 {
+		return normalizeRule{}, false
+	}
+	var graphemes = make([]string, 0, len(table))
+	for g := range table {
+		graphemes = append(graphemes, g)
+	}
+	// longest first so the longer of two overlapping graphemes wins
+	slices.SortFunc(graphemes, func(a, b string) int { return len(b) - len(a) })
+	var alternatives = make([]string, len(graphemes))
+	for i, g := range graphemes {
+		// whole words only, the boundary takes no characters so adjacent graphemes both match
+		alternatives[i] = regexp.QuoteMeta(g)
+		if isWordByte(g[0]) {
+			alternatives[i] = `\b` + alternatives[i]
+		}
+		if isWordByte(g[len(g)-1]) {
+			alternatives[i] += `\b`
+		}
+	}
+	var pattern = regexp.MustCompile(strings.Join(alternatives, "|"))
+	return normalizeRule{name: "lexicon", pattern: pattern, replace: func(m []string) string {
+		return table[m[0]]
+	}}, true
+}
+
+// runLexicon is the entry point for the lexicon subcommand.
+func runLexicon(ctx context.Context, args []string) {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, lexiconCommandUsage)
+		os.Exit(2)
+	}
+	var command, rest = args[0], args[1:]
+	var awsFlags awsOpts
+	var name string
+	var fs = flag.NewFlagSet("lexicon "+command, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), lexiconCommandUsage+"\nflags:\n")
+		fs.PrintDefaults()
+	}
+	if command != "validate" {
+		awsFlags.registerFlags(fs)
+	}
+	if command == "upload" {
+		fs.StringVar(&name, "name", "", "name of the lexicon in polly, defaults to the file name")
+	}
+	//nolint:errcheck // ExitOnError
+	fs.Parse(rest)
+	if err := applyConfig(fs, "", false); err != nil {
+		log.Fatal(err)
+	}
+
+	if command == "validate" {
+		if err := validateLexiconFiles(os.Stdout, fs.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := awsFlags.validate(); err != nil {
+		log.Fatal(err)
+	}
+	pollyClient, _, err := newAWSClients(ctx, awsFlags)
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch command {
+	case "upload":
+		if fs.NArg() != 1 {
+			log.Fatal("upload takes one lexicon file")
+		}
+		if name == "" {
+			name = lexiconName(fs.Arg(0))
+		}
+		err = uploadLexicon(ctx, pollyClient, name, fs.Arg(0))
+		if err == nil {
+			log.Infof("uploaded %s as %s", fs.Arg(0), name)
+		}
+	case "list":
+		err = listLexicons(ctx, pollyClient, os.Stdout)
+	case "delete":
+		for _, name := range fs.Args() {
+			if err = deleteLexicon(ctx, pollyClient, name); err != nil {
+				break
+			}
+			log.Infof("deleted %s", name)
+		}
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// validateLexiconFiles checks every file and reports each one, the error is for the first invalid file.
+func validateLexiconFiles(w io.Writer, paths []string) error {
+	var first error
+	for _, path := range paths {
+		_, lexicon, err := readLexicon(path)
+		if err != nil {
+			fmt.Fprintln(w, err)
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		fmt.Fprintf(w, "%s: ok, %d lexemes (%s, %s)\n", path, len(lexicon.Lexemes), lexicon.Lang, lexicon.Alphabet)
+	}
+	return first
+}
+
+func uploadLexicon(ctx context.Context, pollyClient *polly.Client, name, path string) error {
+	if !lexiconNameRegex.MatchString(name) {
+		return fmt.Errorf("%w: %s", errInvalidLexiconName, name)
+	}
+	content, _, err := readLexicon(path)
+	if err != nil {
+		return err
+	}
+	if _, err := pollyClient.PutLexicon(ctx, &polly.PutLexiconInput{Name: aws.String(name), Content: aws.String(string(content))}); err != nil {
+		return fmt.Errorf("polly put lexicon: %w", err)
+	}
+	return nil
+}
+
+// listLexicons writes a table of the lexicons stored in polly.
+func listLexicons(ctx context.Context, pollyClient *polly.Client, w io.Writer) error {
+	var tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tLANGUAGE\tALPHABET\tLEXEMES\tSIZE\tMODIFIED")
+	var input = &polly.ListLexiconsInput{}
+	for {
+		output, err := pollyClient.ListLexicons(ctx, input)
+		if err != nil {
+			return fmt.Errorf("polly list lexicons: %w", err)
+		}
+		for _, l := range output.Lexicons {
+			var attrs = l.Attributes
+			if attrs == nil {
+				fmt.Fprintf(tw, "%s\t\t\t\t\t\n", aws.ToString(l.Name))
+				continue
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\n", aws.ToString(l.Name), attrs.LanguageCode, aws.ToString(attrs.Alphabet),
+				attrs.LexemesCount, humanize.Bytes(uint64(max(attrs.Size, 0))), aws.ToTime(attrs.LastModified).Format(time.DateTime))
+		}
+		if aws.ToString(output.NextToken) == "" {
+			break
+		}
+		input.NextToken = output.NextToken
+	}
+	return tw.Flush()
+}
+
+func deleteLexicon(ctx context.Context, pollyClient *polly.Client, name string) error {
+	if _, err := pollyClient.DeleteLexicon(ctx, &polly.DeleteLexiconInput{Name: aws.String(name)}); err != nil {
+		return fmt.Errorf("polly delete lexicon %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/kmulvey/text2speech/internal/fakeaws"
+	"github.com/stretchr/testify/assert"
+)
+
+const testLexicon = `<?xml version="1.0" encoding="UTF-8"?>
+<lexicon version="1.0" xmlns="http://www.w3.org/2005/01/pronunciation-lexicon" alphabet="ipa" xml:lang="en-US">
+  <lexeme><grapheme>W3C</grapheme><alias>World Wide Web Consortium</alias></lexeme>
+  <lexeme><grapheme>k8s</grapheme><alias>kubernetes</alias></lexeme>
+  <lexeme><grapheme>Kmulvey</grapheme><phoneme>ˈkeɪmʌlvi</phoneme></lexeme>
+</lexicon>`
+
+func writeLexicon(t *testing.T, name, content string) string {
+	t.Helper()
+	var path = filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestParseLexicon(t *testing.T) {
+	t.Parallel()
+
+	lexicon, err := parseLexicon([]byte(testLexicon))
+	assert.NoError(t, err)
+	assert.Equal(t, "en-US", lexicon.Lang)
+	assert.Len(t, lexicon.Lexemes, 3)
+
+	for content, problem := range map[string]string{
+		`<lexicon version="1.0" alphabet="ipa" xml:lang="en-US"><lexeme><grapheme>a</grapheme><alias>b</alias></lexeme></lexicon>`:                                                             "namespace",
+		`<lexicon version="1.0" xmlns="http://www.w3.org/2005/01/pronunciation-lexicon" alphabet="arpabet" xml:lang="en-US"><lexeme><grapheme>a</grapheme><alias>b</alias></lexeme></lexicon>`: "alphabet must be ipa or x-sampa",
+		`<lexicon version="1.0" xmlns="http://www.w3.org/2005/01/pronunciation-lexicon" alphabet="ipa"><lexeme><grapheme>a</grapheme><alias>b</alias></lexeme></lexicon>`:                      "xml:lang is missing",
+		`<lexicon version="1.0" xmlns="http://www.w3.org/2005/01/pronunciation-lexicon" alphabet="ipa" xml:lang="en-US"><lexeme><grapheme>a</grapheme></lexeme></lexicon>`:                     "lexeme 1 has no phoneme or alias",
+		`<lexicon version="1.0"`: "XML syntax error",
+	} {
+		_, err = parseLexicon([]byte(content))
+		assert.ErrorIs(t, err, errInvalidLexicon)
+		assert.ErrorContains(t, err, problem)
+	}
+}
+
+func TestParseLexicons(t *testing.T) {
+	t.Parallel()
+
+	var path = writeLexicon(t, "acronyms.pls", testLexicon)
+	names, files, err := parseLexicons("tech, " + path + ",tech,names")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tech", "names"}, names)
+	assert.Len(t, files, 1)
+
+	_, _, err = parseLexicons("a,b,c,d,e,f")
+	assert.ErrorIs(t, err, errTooManyLexicons)
+	_, _, err = parseLexicons("my-lexicon")
+	assert.ErrorIs(t, err, errInvalidLexiconName)
+	_, _, err = parseLexicons(writeLexicon(t, "bad.pls", "<lexicon/>"))
+	assert.ErrorIs(t, err, errInvalidLexicon)
+
+	assert.Equal(t, "myacronyms2", lexiconName("/tmp/my-acronyms_2.pls"))
+}
+
+func TestLexiconRule(t *testing.T) {
+	t.Parallel()
+
+	lexicon, err := parseLexicon([]byte(testLexicon))
+	assert.NoError(t, err)
+	rule, ok := lexiconRule([]plsLexicon{lexicon})
+	assert.True(t, ok)
+
+	got, _ := normalizeText("The W3C runs k8s, not k8sx or W3Cs. Ask Kmulvey.", []normalizeRule{rule})
+	assert.Equal(t, "The World Wide Web Consortium runs kubernetes, not k8sx or W3Cs. Ask Kmulvey.", got)
+	// graphemes separated by a single character are both replaced
+	got, _ = normalizeText("k8s k8s,W3C", []normalizeRule{rule})
+	assert.Equal(t, "kubernetes kubernetes,World Wide Web Consortium", got)
+
+	var buf bytes.Buffer
+	assert.NoError(t, validateLexiconFiles(&buf, []string{writeLexicon(t, "acronyms.pls", testLexicon)}))
+	assert.Contains(t, buf.String(), "acronyms.pls")
+	assert.Error(t, validateLexiconFiles(&buf, []string{writeLexicon(t, "bad.pls", "<lexicon/>")}))
+}
+
+func TestPollyLexicons(t *testing.T) {
+	var srv = newFakeAWS(t)
+	var ctx = context.Background()
+
+	assert.NoError(t, uploadLexicon(ctx, srv.PollyClient(), "acronyms", writeLexicon(t, "acronyms.pls", testLexicon)))
+	content, ok := srv.Lexicon("acronyms")
+	assert.True(t, ok)
+	assert.Equal(t, testLexicon, content)
+
+	var buf bytes.Buffer
+	assert.NoError(t, listLexicons(ctx, srv.PollyClient(), &buf))
+	assert.Contains(t, buf.String(), "acronyms")
+	assert.Contains(t, buf.String(), "en-US")
+
+	// the names are passed to every synthesis task
+	srv.Script(fakeaws.Task{Duration: time.Second})
+	var audioChan = make(chan *s3.GetObjectOutput, 5)
+	var logs = make(chan string, 100)
+	var synth = synthesisOpts{bucket: "bucket", voiceID: "Matthew", lexicons: []string{"acronyms"}}
+	assert.NoError(t, handleOutput(ctx, srv.PollyClient(), srv.S3Client(), audioChan, logs, synth, document{text: "The W3C."}, filepath.Join(t.TempDir(), "out.mp3"), nil))
+	assert.Equal(t, []string{"acronyms"}, srv.Started()[0].LexiconNames)
+
+	assert.NoError(t, deleteLexicon(ctx, srv.PollyClient(), "acronyms"))
+	_, ok = srv.Lexicon("acronyms")
+	assert.False(t, ok)
+	assert.Error(t, deleteLexicon(ctx, srv.PollyClient(), "acronyms"))
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	errFFmpegNotFound = errors.New("m4b output needs ffmpeg on the PATH to encode aac")
+	errM4BNeedsMP3    = errors.New("m4b output is encoded from mp3, -format must be mp3")
+)
+
+// m4bBitrate is the aac bitrate of m4b output, plenty for speech.
+const m4bBitrate = "64k"
+
+// isM4B reports whether the output file is an m4b audiobook.
+func isM4B(file string) bool {
+	return strings.EqualFold(filepath.Ext(strings.TrimSpace(file)), ".m4b")
+}
+
+// m4bSourceFile is where the mp3 is written before it is encoded into the m4b.
+func m4bSourceFile(outputFile string) string {
+	return strings.TrimSuffix(outputFile, filepath.Ext(outputFile)) + ".part.mp3"
+}
+
+// writeM4B encodes the mp3 at source to aac in an mp4 container at outputFile with the chapters,
+// title, author and cover art. ffmpeg does the encoding and muxing, go has no aac encoder.
+func writeM4B(ctx context.Context, source, outputFile string, info bookInfo, markers []chapterMarker) error {
+	metadata, err := os.CreateTemp(filepath.Dir(outputFile), "text2speech-*.ffmetadata")
+	if err != nil {
+		return fmt.Errorf("error creating m4b metadata: %w", err)
+	}
+	defer os.Remove(metadata.Name())
+	var w = bufio.NewWriter(metadata)
+	if err := writeFFMetadata(w, info, markers); err != nil {
+		metadata.Close()
+		return fmt.Errorf("error writing m4b metadata: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		metadata.Close()
+		return fmt.Errorf("error writing m4b metadata: %w", err)
+	}
+	if err := metadata.Close(); err != nil {
+		return fmt.Errorf("error writing m4b metadata: %w", err)
+	}
+
+	var args = []string{"-hide_banner", "-loglevel", "error", "-y", "-i", source, "-i", metadata.Name()}
+	if info.cover != "" {
+		args = append(args, "-i", info.cover)
+	}
+	args = append(args, "-map", "0:a", "-map_metadata", "1", "-map_chapters", "1")
+	if info.cover != "" {
+		args = append(args, "-map", "2:v", "-c:v", "copy", "-disposition:v", "attached_pic")
+	}
+	// m4b is written by the ipod muxer, plain mp4 players ignore the chapters it adds
+	args = append(args, "-c:a", "aac", "-b:a", m4bBitrate, "-movflags", "+faststart", "-f", "ipod", outputFile)
+
+	//nolint:gosec // the arguments are passed to ffmpeg directly, not through a shell
+	out, err := exec.CommandContext(ctx, "ffmpeg", args...).CombinedOutput()
+	if err != nil {
+		// ffmpeg leaves what it encoded before it failed
+		_ = os.Remove(outputFile)
+		return fmt.Errorf("ffmpeg encoding %s: %w: output: %s", outputFile, err, out)
+	}
+	return nil
+}
+
+// writeFFMetadata writes the metadata and chapters in ffmpeg's metadata file format.
+func writeFFMetadata(w io.Writer, info bookInfo, markers []chapterMarker) error {
+	var lines = []string{";FFMETADATA1"}
+	if info.title != "" {
+		lines = append(lines, "title="+escapeFFMetadata(info.title), "album="+escapeFFMetadata(info.title))
+	}
+	if info.author != "" {
+		lines = append(lines, "artist="+escapeFFMetadata(info.author), "album_artist="+escapeFFMetadata(info.author))
+	}
+	lines = append(lines, "genre=Audiobook")
+	for _, m := range markers {
+		lines = append(lines,
+			"[CHAPTER]",
+			"TIMEBASE=1/1000",
+			fmt.Sprintf("START=%d", m.start.Milliseconds()),
+			fmt.Sprintf("END=%d", m.end.Milliseconds()),
+			"title="+escapeFFMetadata(m.title),
+		)
+	}
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+// escapeFFMetadata escapes the characters that are special in ffmpeg metadata files.
+func escapeFFMetadata(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", `\`+"\n").Replace(value)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/kmulvey/text2speech/internal/fakeaws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteFFMetadata(t *testing.T) {
+	t.Parallel()
+
+	var buf strings.Builder
+	var markers = []chapterMarker{
+		{title: "Introduction", end: 1500 * time.Millisecond},
+		{title: "Chapter 1; a=b", start: 1500 * time.Millisecond, end: 3 * time.Second},
+	}
+	assert.NoError(t, writeFFMetadata(&buf, bookInfo{title: "Report #4", author: "Jane"}, markers))
+	assert.Equal(t, `;FFMETADATA1
+title=Report \#4
+album=Report \#4
+artist=Jane
+album_artist=Jane
+genre=Audiobook
+[CHAPTER]
+TIMEBASE=1/1000
+START=0
+END=1500
+title=Introduction
+[CHAPTER]
+TIMEBASE=1/1000
+START=1500
+END=3000
+title=Chapter 1\; a\=b
+`, buf.String())
+}
+
+func TestChapterMarkers(t *testing.T) {
+	t.Parallel()
+
+	var timeline = &Timeline{}
+	timeline.addSection(nil, 2*time.Second, 0, 0, 10)
+	timeline.addSection(nil, 5*time.Second, 0, 10, 20)
+	timeline.addSection(nil, 6*time.Second, 0, 20, 30)
+
+	// without chapters every section is one
+	assert.Equal(t, []chapterMarker{
+		{title: "Part 1", end: 2 * time.Second, sections: [2]int{0, 1}},
+		{title: "Part 2", start: 2 * time.Second, end: 5 * time.Second, sections: [2]int{1, 2}},
+		{title: "Part 3", start: 5 * time.Second, end: 6 * time.Second, sections: [2]int{2, 3}},
+	}, chapterMarkers(nil, timeline))
+
+	assert.Equal(t, []chapterMarker{
+		{title: "Introduction", end: 2 * time.Second, sections: [2]int{0, 1}},
+		{title: "One", start: 2 * time.Second, end: 6 * time.Second, sections: [2]int{1, 3}},
+	}, chapterMarkers([]Chapter{{Title: "One", Start: 10}}, timeline))
+
+	// chapters that share a section both cover it
+	assert.Equal(t, []chapterMarker{
+		{title: "One", end: 5*time.Second + 500*time.Millisecond, sections: [2]int{0, 3}},
+		{title: "Two", start: 5*time.Second + 500*time.Millisecond, end: 6 * time.Second, sections: [2]int{2, 3}},
+	}, chapterMarkers([]Chapter{{Title: "One", Start: 0}, {Title: "Two", Start: 25}}, timeline))
+
+	assert.Empty(t, chapterMarkers(nil, &Timeline{}))
+}
+
+func TestInferTitle(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "Quarterly Report", inferTitle("# Quarterly Report\nSome text.", "report.txt"))
+	assert.Equal(t, "report", inferTitle("Some text.", "/tmp/report.txt"))
+	assert.Empty(t, inferTitle("Some text.", ""))
+}
+
+func TestWriteM4B(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg is not installed")
+	}
+	var srv = newFakeAWS(t)
+	srv.Script(fakeaws.Task{Duration: 4 * time.Second})
+
+	var text = "# One\n" + longText(1) + "\n# Two\nThe end."
+	var chapters = detectChapters(text, nil)
+	var outputFile = filepath.Join(t.TempDir(), "book.m4b")
+	var timeline = &Timeline{}
+	var err = handleOutput(context.Background(), srv.PollyClient(), srv.S3Client(), make(chan *s3.GetObjectOutput, 5), make(chan string, 100), synthesisOpts{bucket: "bucket", voiceID: "Matthew"}, document{text: text, chapters: chapters}, m4bSourceFile(outputFile), timeline)
+	assert.NoError(t, err)
+
+	assert.NoError(t, writeM4B(context.Background(), m4bSourceFile(outputFile), outputFile, bookInfo{title: "Book", author: "Jane"}, chapterMarkers(chapters, timeline)))
+	m4b, err := os.ReadFile(outputFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "ftyp", string(m4b[4:8]))
+	// chapter titles are stored as samples of the chapter track
+	assert.Contains(t, string(m4b), "Two")
+}
+
+func TestRemoveSynthFile(t *testing.T) {
+	t.Parallel()
+
+	var outputFile = filepath.Join(t.TempDir(), "book.m4b")
+	var opts = cliOpts{outputFile: outputFile}
+	assert.NoError(t, os.WriteFile(m4bSourceFile(outputFile), []byte("mp3"), 0o600))
+	removeSynthFile(opts, m4bSourceFile(outputFile))
+	assert.NoFileExists(t, m4bSourceFile(outputFile))
+	// a missing intermediate is not an error
+	removeSynthFile(opts, m4bSourceFile(outputFile))
+
+	// audio written straight to the output is kept
+	opts.outputFile = filepath.Join(filepath.Dir(outputFile), "book.mp3")
+	assert.NoError(t, os.WriteFile(opts.outputFile, []byte("mp3"), 0o600))
+	removeSynthFile(opts, opts.outputFile)
+	assert.FileExists(t, opts.outputFile)
+}
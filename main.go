@@ -6,70 +6,240 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"slices"
 	"strings"
 	"sync/atomic"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/polly"
 	"github.com/aws/aws-sdk-go-v2/service/polly/types"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	log "github.com/sirupsen/logrus"
-	"go.szostok.io/version"
-	"go.szostok.io/version/printer"
 )
 
 // PlaybackProgress represents how far we have gotten in playing the audio
 type PlaybackProgress struct {
-	Total        int // section total in seconds
-	Current      int // section elapsed in seconds
-	GrandTotal   int // running sum of all section durations resolved so far
-	GrandElapsed int // total seconds elapsed across all sections
+	Total        int           // section total in seconds
+	Current      int           // section elapsed in seconds
+	GrandTotal   int           // running sum of all section durations resolved so far
+	GrandElapsed int           // total seconds elapsed across all sections
+	Position     time.Duration // exact position across all sections, lines up with the speech marks on the Timeline
+	Section      int           // index of the section being played
 }
 
 func (p *PlaybackProgress) String() string {
 	return fmt.Sprintf("GrandElapsed: %d, GrandTotal: %d", p.GrandElapsed, p.GrandTotal)
 }
 
-const MAX_CHAR_COUNT = 100_000  // StartSpeechSynthesisTask limit (async) is 100k chars
-const DEFAULT_VOICE = "Matthew" // this can be overridden with cli flags
+const MAX_CHAR_COUNT = 100_000      // StartSpeechSynthesisTask limit (async) is 100k chars
+const DEFAULT_VOICE = "Matthew"     // this can be overridden with cli flags
+const DEFAULT_OUTPUT = "output.mp3" // when -output is left at this value the audio is played instead of saved
 
 type cliOpts struct {
-	s3Bucket   string
-	awsProfile string
-	awsRegion  string
-	voiceID    string
-	inputFile  string
-	outputFile string
-	dashboard  bool
+	s3Bucket       string
+	s3Prefix       string
+	kmsKeyID       string
+	keepS3         bool
+	aws            awsOpts
+	voiceID        string
+	engine         types.Engine
+	rate           int // prosody rate in percent, 0 is normal speed
+	detectLanguage bool
+	languageVoices map[string]language
+	inputFile      string
+	url            string
+	fetch          fetchOpts
+	outputFile     string
+	outputDir      string
+	template       string // names the files in outputDir
+	format         audioFormat
+	marks          []types.SpeechMarkType
+	subtitles      []string
+	document       documentOpts
+	ssml           bool
+	fitCues        bool
+	normalize      []normalizeRule
+	lexicons       []string // stored in polly, local lexicon files are part of normalize
+	showNormalized bool
+	book           bookInfo
+	dashboard      bool
+	highlight      bool
+	sink           sinkOpts
 }
 
-func parseFlags() cliOpts {
+// parseFlags parses the flags of the speak command, or of synth without the playback flags.
+func parseFlags(fs *flag.FlagSet, args []string, playback bool) cliOpts {
 	var opts cliOpts
 	var v bool
-	flag.StringVar(&opts.s3Bucket, "bucket", "", "s3 bucket to put the mp3 files")
-	flag.StringVar(&opts.awsProfile, "profile", "default", "aws profile to use")
-	flag.StringVar(&opts.awsRegion, "region", "us-west-2", "aws region to use")
-	flag.StringVar(&opts.voiceID, "voice", "Matthew", "voice to use")
-	flag.StringVar(&opts.inputFile, "input", "", "path the input text file, if this is specified STDIN will be ignored")
-	flag.StringVar(&opts.outputFile, "output", "output.mp3", "path the save the mp3, this will NOT play the audio")
-	flag.BoolVar(&opts.dashboard, "dashboard", false, "use a terminal dashboard")
-	flag.BoolVar(&v, "version", false, "print version")
-	flag.BoolVar(&v, "v", false, "print version")
-	flag.Parse()
+	var format string
+	var sampleRate int
+	var speechMarks string
+	var subtitles string
+	var chapterRegex string
+	var inputFormat string
+	var normalize string
+	var abbreviationsFile string
+	var lexicons string
+	var languageVoices string
+	var preset string
+	var engine string
+	var speed int
+	var code string
+	fs.StringVar(&opts.s3Bucket, "bucket", "", "s3 bucket to put the mp3 files")
+	fs.StringVar(&opts.s3Prefix, "s3-prefix", "", "key prefix polly writes the mp3 files under, e.g. text2speech/")
+	fs.StringVar(&opts.kmsKeyID, "kms-key-id", "", "KMS key id or arn the audio in s3 is re-encrypted with (SSE-KMS). polly cannot encrypt with KMS, so each object is unencrypted until it is copied onto itself; prefer default bucket encryption")
+	fs.BoolVar(&opts.keepS3, "keep-s3", false, "leave the mp3 files in s3 as an archive instead of deleting them, use a separate -s3-prefix so s3-cleanup leaves them alone")
+	opts.aws.registerFlags(fs)
+	fs.StringVar(&opts.voiceID, "voice", "Matthew", "voice to use")
+	fs.StringVar(&engine, "engine", "", "polly engine: standard, neural, long-form or generative, the voice must support it. empty uses polly's default")
+	fs.IntVar(&speed, "speed", 100, "speaking rate in percent of normal speed, 20 to 200")
+	fs.StringVar(&preset, "preset", "", "named preset of settings from the config files, see the README")
+	fs.BoolVar(&opts.detectLanguage, "detect-language", false, "detect the language of each paragraph and read those not in the main language of the input with a voice of their language")
+	fs.StringVar(&languageVoices, "language-voices", "", "comma separated language=VoiceId pairs for -detect-language, e.g. de=Hans,pt-PT=Ines, over the default voice of each language")
+	fs.StringVar(&opts.inputFile, "input", "", "path the input text file, if this is specified STDIN will be ignored")
+	fs.StringVar(&opts.url, "url", "", "http(s) url of a web page, markdown, text or epub document to read instead of -input or STDIN")
+	fs.DurationVar(&opts.fetch.timeout, "url-timeout", defaultFetchTimeout, "how long fetching -url may take")
+	fs.Int64Var(&opts.fetch.maxSize, "url-max-size", defaultFetchMaxSize, "largest -url document in bytes")
+	fs.StringVar(&opts.outputFile, "output", DEFAULT_OUTPUT, "path the save the audio, this will NOT play the audio. subtitle input can only be saved as .wav (pcm), it is aligned as raw pcm and not encoded to mp3 or ogg")
+	fs.StringVar(&opts.outputDir, "output-dir", "", "directory to save the audio to as a file per chapter (or section when there are no chapters) along with an m3u playlist, this will NOT play the audio")
+	fs.StringVar(&opts.template, "output-template", defaultFileTemplate, "file names in -output-dir: {index} (or {index:03} to zero pad), {title} and {ext}")
+	fs.StringVar(&format, "format", "", "audio format: mp3, ogg_vorbis, ogg_opus or pcm (saved as wav when -output ends in .wav), inferred from the -output extension when not set")
+	fs.IntVar(&sampleRate, "sample-rate", 0, "sample rate in Hz: 8000, 16000, 22050 or 24000 (8000 or 16000 for pcm), 0 uses the polly default. polly picks the bitrate to match")
+	fs.StringVar(&speechMarks, "speech-marks", "", "comma separated speech marks to fetch alongside the audio: sentence, word, viseme, ssml")
+	fs.StringVar(&subtitles, "subtitles", "", "write srt and/or vtt subtitles (comma separated) next to -output, e.g. -output talk.mp3 writes talk.srt")
+	fs.StringVar(&opts.book.title, "title", "", "title (and album) written into the output file's tags, defaults to a markdown heading on the first line or the input file name")
+	fs.StringVar(&opts.book.author, "author", "", "author written into the output file's tags, mp3 files use the voice when not set")
+	fs.StringVar(&opts.book.cover, "cover", "", "jpeg or png cover art for m4b output")
+	fs.StringVar(&inputFormat, "input-format", "auto", "how to read the input: text, markdown, html, epub, subtitles, dialogue or auto (by file extension, html, subtitles and dialogue scripts are also recognized on stdin). subtitles are always synthesized as pcm, they are played or saved to a .wav -output")
+	fs.StringVar(&code, "code", "summarize", "what to say for markdown and html code blocks and tables: skip, summarize (e.g. \"Code sample in go, 12 lines.\") or read")
+	fs.BoolVar(&opts.ssml, "ssml", false, "send the text as ssml so headings are read with emphasis and followed by a pause")
+	fs.StringVar(&normalize, "normalize", "none", "comma separated rules that rewrite the text before it is synthesized, none by default: urls (read as their domain), paths, emoji (removed), abbreviations, numbers (versions and units). preview them with -show-normalized")
+	fs.StringVar(&abbreviationsFile, "abbreviations", "", "file of abbreviation=expansion lines added to the abbreviations rule, which -normalize has to include")
+	fs.StringVar(&lexicons, "lexicon", "", fmt.Sprintf("comma separated pronunciation lexicons: names of lexicons uploaded to polly (at most %d), or local .pls files whose aliases are substituted into the text", maxLexiconsPerTask))
+	fs.BoolVar(&opts.showNormalized, "show-normalized", false, "print the text as it would be synthesized and exit")
+	fs.BoolVar(&opts.fitCues, "fit-cues", false, fmt.Sprintf("speak subtitle cues that overrun their slot faster (up to %d%%) instead of only warning", maxCueRate))
+	fs.DurationVar(&opts.document.pause, "speaker-pause", 500*time.Millisecond, fmt.Sprintf("silence between the speakers of a dialogue script, at most %s", maxBreak))
+	fs.BoolVar(&opts.document.chapters, "chapters", true, "detect chapter headings for the dashboard, the chapters of the output file and -output-dir")
+	fs.StringVar(&chapterRegex, "chapter-regex", "", "regex matching chapter heading lines, replaces the built in detection of markdown headings, \"Chapter N\" and all caps lines. the first capture group, if any, is the title")
+	fs.BoolVar(&opts.dashboard, "dashboard", false, "use a terminal dashboard")
+	fs.BoolVar(&opts.highlight, "highlight", true, "highlight the sentence and word being read in the dashboard, fetches sentence and word speech marks")
+	if playback {
+		opts.sink.registerFlags(fs)
+		// kept from before the version command
+		fs.BoolVar(&v, "version", false, "print version")
+		fs.BoolVar(&v, "v", false, "print version")
+	}
+	//nolint:errcheck // ExitOnError
+	fs.Parse(args)
 	if v {
-		var verPrinter = printer.New()
-		var info = version.Get()
-		if err := verPrinter.PrintInfo(os.Stdout, info); err != nil {
-			log.Fatal(err)
-		}
+		printVersion()
 		os.Exit(0)
 	}
+	// the config files fill in what is not given on the command line, the speak command has every
+	// flag so it is the one that reports settings nothing knows
+	if err := applyConfig(fs, preset, playback); err != nil {
+		log.Fatal(err)
+	}
+	// synth always writes a file, output.mp3 is only the default that means playing for speak
+	if !playback && strings.TrimSpace(opts.outputFile) == DEFAULT_OUTPUT && opts.outputDir == "" {
+		opts.outputFile = "./" + DEFAULT_OUTPUT
+	}
+	// the format is inferred from the extension of the file, or of the template in -output-dir
+	var outputName = opts.outputFile
+	if opts.outputDir != "" {
+		outputName = opts.template
+	}
+	output, err := parseFormat(format, outputName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts.format = audioFormat{output: output, sampleRate: sampleRate}
+	if engine != "" && !slices.Contains(types.Engine("").Values(), types.Engine(engine)) {
+		log.Fatalf("%s is not an AWS Polly engine, must be one of %v", engine, types.Engine("").Values())
+	}
+	opts.engine = types.Engine(engine)
+	if speed < 20 || speed > 200 {
+		log.Fatal(errInvalidSpeed)
+	}
+	// normal speed is sent as plain text
+	if speed != 100 {
+		opts.rate = speed
+	}
+	if opts.marks, err = parseSpeechMarkTypes(speechMarks); err != nil {
+		log.Fatal(err)
+	}
+	if opts.document.chapterRe, err = parseChapterRegex(chapterRegex); err != nil {
+		log.Fatal(err)
+	}
+	if opts.document.format, err = parseInputFormat(inputFormat); err != nil {
+		log.Fatal(err)
+	}
+	if opts.document.code, err = parseCodePolicy(code); err != nil {
+		log.Fatal(err)
+	}
+	if opts.languageVoices, err = parseLanguageVoices(languageVoices, opts.engine); err != nil {
+		log.Fatal(err)
+	}
+	if opts.document.pause < 0 || opts.document.pause > maxBreak {
+		log.Fatal(errPauseTooLong)
+	}
+	extra, err := readAbbreviations(abbreviationsFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if opts.normalize, err = parseNormalizeRules(normalize, extra); err != nil {
+		log.Fatal(err)
+	}
+	lexiconNames, lexiconFiles, err := parseLexicons(lexicons)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts.lexicons = lexiconNames
+	// the lexicon's words are replaced before the other rules rewrite them
+	if rule, ok := lexiconRule(lexiconFiles); ok {
+		opts.normalize = append([]normalizeRule{rule}, opts.normalize...)
+	}
+	if opts.subtitles, err = parseSubtitleFormats(subtitles); err != nil {
+		log.Fatal(err)
+	}
+	// subtitles and the dashboard's text highlighting are built from the sentence and word timings
+	if len(opts.subtitles) > 0 || opts.dashboard && opts.highlight && opts.playing() {
+		for _, markType := range []types.SpeechMarkType{types.SpeechMarkTypeSentence, types.SpeechMarkTypeWord} {
+			if !slices.Contains(opts.marks, markType) {
+				opts.marks = append(opts.marks, markType)
+			}
+		}
+	}
 	return opts
 }
 
+// playing reports whether the audio is played rather than saved.
+func (opts cliOpts) playing() bool {
+	return strings.TrimSpace(opts.outputFile) == DEFAULT_OUTPUT && opts.outputDir == ""
+}
+
+// synthesisOpts picks out the settings used by synthesizeText and handleOutput.
+func (opts cliOpts) synthesisOpts() synthesisOpts {
+	return synthesisOpts{
+		bucket:    opts.s3Bucket,
+		keyPrefix: opts.s3Prefix,
+		kmsKeyID:  opts.kmsKeyID,
+		voiceID:   opts.voiceID,
+		engine:    opts.engine,
+		rate:      opts.rate,
+		format:    opts.format,
+		keepS3:    opts.keepS3,
+		marks:     opts.marks,
+		ssml:      opts.ssml,
+		fitCues:   opts.fitCues,
+		lexicons:  opts.lexicons,
+	}
+}
+
 func validateOpts(opts cliOpts) {
 	if strings.TrimSpace(opts.s3Bucket) == "" {
 		log.Fatal("s3 bucket not spcecified")
@@ -79,6 +249,60 @@ func validateOpts(opts cliOpts) {
 			log.Fatalf("VoiceID: %s is not an AWS Polly VoiceID", opts.voiceID)
 		}
 	}
+	if err := opts.aws.validate(); err != nil {
+		log.Fatal(err)
+	}
+	if opts.url != "" {
+		if strings.TrimSpace(opts.inputFile) != "" {
+			log.Fatal(errURLAndInput)
+		}
+		if err := validateURL(opts.url); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := opts.format.validate(); err != nil {
+		log.Fatal(err)
+	}
+	if len(opts.subtitles) > 0 && strings.TrimSpace(opts.outputFile) == DEFAULT_OUTPUT {
+		log.Fatal(errSubtitlesNeedOutput)
+	}
+	if opts.outputDir != "" {
+		if strings.TrimSpace(opts.outputFile) != DEFAULT_OUTPUT {
+			log.Fatal(errOutputAndDir)
+		}
+		if err := validateFileTemplate(opts.template); err != nil {
+			log.Fatal(err)
+		}
+		// the audio is written next to the directory before it is split into it
+		if info, err := os.Stat(filepath.Dir(filepath.Clean(opts.outputDir))); err != nil || !info.IsDir() {
+			log.Fatalf("cannot write to output dir %s, its parent directory does not exist", opts.outputDir)
+		}
+	}
+	if isM4B(opts.outputFile) {
+		if _, err := exec.LookPath("ffmpeg"); err != nil {
+			log.Fatal(errFFmpegNotFound)
+		}
+		if opts.format.output != types.OutputFormatMp3 {
+			log.Fatal(errM4BNeedsMP3)
+		}
+	}
+	if opts.book.cover != "" {
+		if _, err := os.Stat(opts.book.cover); err != nil {
+			log.Fatalf("cannot read cover %s: %v", opts.book.cover, err)
+		}
+	}
+	if opts.playing() {
+		if !opts.format.playable() {
+			log.Fatalf("%s: %s", errUnsupportedPlayback, opts.format.output)
+		}
+		if opts.format.output == types.OutputFormatOggOpus {
+			if _, err := exec.LookPath("ffmpeg"); err != nil {
+				log.Fatal(errOpusNeedsFFmpeg)
+			}
+		}
+	} else if inferred := formatFromExtension(opts.outputFile); opts.outputDir == "" && inferred != opts.format.output {
+		log.Warnf("writing %s audio to %s which looks like %s", opts.format.output, opts.outputFile, inferred)
+	}
 }
 
 func getInputText(inputFile string) string {
@@ -96,28 +320,45 @@ func getInputText(inputFile string) string {
 	return text
 }
 
-func run(ctx context.Context, cancel context.CancelFunc, opts cliOpts, text string) {
-	awsConfig, err := config.LoadDefaultConfig(ctx, config.WithSharedConfigProfile(opts.awsProfile), config.WithRegion(opts.awsRegion))
+func run(ctx context.Context, cancel context.CancelFunc, opts cliOpts, doc document) {
+	pollyClient, s3Client, err := newAWSClients(ctx, opts.aws)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sink, err := newAudioSink(opts.sink)
 	if err != nil {
-		log.Fatalf("failed to load SDK configuration, %v", err)
+		log.Fatal(err)
 	}
-	var pollyClient = polly.NewFromConfig(awsConfig)
-	var s3Client = s3.NewFromConfig(awsConfig)
+	defer func() {
+		if err := sink.Close(); err != nil {
+			log.Error(err)
+		}
+	}()
 	var audioChan = make(chan *s3.GetObjectOutput, 5)
+	var timeline = &Timeline{}
 	var errors = make(chan error)
 	var playbackProgress = make(chan PlaybackProgress)
 	var logs = make(chan string, 32)
 	var pauseChan = make(chan bool, 1)
+	var seekChan = make(chan time.Duration, 1)
+	// m4b and -output-dir are written from a single file once every section has been synthesized
+	var synthFile = opts.outputFile
+	switch {
+	case isM4B(opts.outputFile):
+		synthFile = m4bSourceFile(opts.outputFile)
+	case opts.outputDir != "":
+		synthFile = outputDirSourceFile(opts.outputDir)
+	}
 
 	if !opts.dashboard {
 		go logOutput(playbackProgress, logs)
 	}
 
-	go playWithProgressBar(audioChan, playbackProgress, errors, pauseChan)
+	go playWithProgressBar(sink, opts.format, audioChan, playbackProgress, errors, pauseChan, seekChan)
 	// Use a buffered channel so the goroutine never blocks even if run() has already returned.
 	handleErrCh := make(chan error, 1)
 	go func() {
-		handleErrCh <- handleOutput(ctx, pollyClient, s3Client, audioChan, logs, opts.s3Bucket, opts.voiceID, text, opts.outputFile)
+		handleErrCh <- handleOutput(ctx, pollyClient, s3Client, audioChan, logs, opts.synthesisOpts(), doc, synthFile, timeline)
 	}()
 
 	if !opts.dashboard {
@@ -125,6 +366,10 @@ func run(ctx context.Context, cancel context.CancelFunc, opts cliOpts, text stri
 			log.Error(err)
 		}
 		if err := <-handleErrCh; err != nil {
+			removeSynthFile(opts, synthFile)
+			log.Fatal(err)
+		}
+		if err := finishOutput(ctx, opts, synthFile, timeline, doc); err != nil {
 			log.Fatal(err)
 		}
 		cancel()
@@ -137,153 +382,482 @@ func run(ctx context.Context, cancel context.CancelFunc, opts cliOpts, text stri
 		}
 		cancel()
 	}()
-	if err := NewDashboard(ctx, cancel, playbackProgress, logs, pauseChan); err != nil {
+	if err := NewDashboard(ctx, cancel, playbackProgress, logs, pauseChan, seekChan, timeline, doc.text, doc.chapters); err != nil {
+		removeSynthFile(opts, synthFile)
 		log.Fatalf("failed to create dashboard, %v", err)
 	}
 	// Terminal is now restored. Check whether handleOutput reported an error
 	// and surface it to the user. This must be done here (not in a goroutine)
 	// to guarantee it runs before main() exits.
 	if err := <-handleErrCh; err != nil {
+		removeSynthFile(opts, synthFile)
+		log.Error(err)
+		return
+	}
+	if err := finishOutput(context.Background(), opts, synthFile, timeline, doc); err != nil {
 		log.Error(err)
 	}
 }
 
+// removeSynthFile removes the intermediate file m4b and -output-dir are written from when the
+// run fails before finishOutput gets to it.
+func removeSynthFile(opts cliOpts, synthFile string) {
+	if synthFile == opts.outputFile {
+		return
+	}
+	if err := os.Remove(synthFile); err != nil && !os.IsNotExist(err) {
+		log.Error(err)
+	}
+}
+
+// finishOutput runs once every section has been written to synthFile: it splits it into
+// -output-dir, encodes the m4b or tags the mp3 and writes the subtitles.
+func finishOutput(ctx context.Context, opts cliOpts, synthFile string, timeline *Timeline, doc document) error {
+	if opts.playing() {
+		return nil
+	}
+	var info = opts.book
+	if info.title == "" {
+		info.title = doc.title
+	}
+	if info.author == "" {
+		info.author = doc.author
+	}
+	if info.title == "" {
+		info.title = inferTitle(doc.text, opts.inputFile)
+	}
+	switch {
+	case opts.outputDir != "":
+		defer os.Remove(synthFile)
+		var tag = newID3Tag(info, opts.voiceID, opts.inputFile, nil)
+		return writeOutputDir(synthFile, opts.outputDir, opts.template, opts.format, chapterMarkers(doc.chapters, timeline), timeline.Sections(), tag)
+	case isM4B(opts.outputFile):
+		defer os.Remove(synthFile)
+		if err := writeM4B(ctx, synthFile, opts.outputFile, info, chapterMarkers(doc.chapters, timeline)); err != nil {
+			return err
+		}
+	case opts.format.output == types.OutputFormatMp3:
+		if err := prependID3Tag(opts.outputFile, newID3Tag(info, opts.voiceID, opts.inputFile, chapterMarkers(doc.chapters, timeline))); err != nil {
+			return err
+		}
+	}
+	return writeSubtitleFiles(opts.outputFile, opts.subtitles, timeline, doc.text)
+}
+
 func main() {
 	var ctx, cancel = context.WithCancel(context.Background())
 	log.SetFormatter(&log.TextFormatter{
 		FullTimestamp:   true,
 		TimestampFormat: "2006-01-02 15:04:05",
 	})
-	opts := parseFlags()
-	validateOpts(opts)
-	text := getInputText(opts.inputFile)
-	if text == "" {
+	var command, args = splitCommand(os.Args[1:])
+	runCommand(ctx, cancel, command, args)
+}
+
+// runSpeak synthesizes the input and plays or saves it, it is the speak and synth commands.
+func runSpeak(ctx context.Context, cancel context.CancelFunc, opts cliOpts) {
+	// printing the normalized text needs no aws settings
+	if !opts.showNormalized {
+		validateOpts(opts)
+	}
+	var text string
+	if opts.url != "" {
+		var format string
+		var err error
+		if text, format, err = fetchURL(ctx, http.DefaultClient, opts.url, opts.fetch); err != nil {
+			log.Fatal(err)
+		}
+		if opts.document.format == "auto" {
+			opts.document.format = format
+		}
+	} else {
+		text = getInputText(opts.inputFile)
+	}
+	if strings.TrimSpace(text) == "" {
 		return
 	}
-	run(ctx, cancel, opts, text)
+	doc, err := parseDocument(text, opts.inputFile, opts.document)
+	if err != nil {
+		log.Fatal(err)
+	}
+	doc = normalizeDocument(doc, opts.normalize)
+	doc.sectionPerChapter = opts.outputDir != ""
+	if opts.detectLanguage {
+		if doc, err = languageSegments(doc, opts.languageVoices, opts.voiceID); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if opts.showNormalized {
+		fmt.Println(doc.text)
+		return
+	}
+	if doc.cues != nil {
+		if opts.format, err = cueFormat(opts); err != nil {
+			log.Fatal(err)
+		}
+	}
+	run(ctx, cancel, opts, doc)
 }
 
 // handleOutput synthesizes text and either writes the result to a file or a channel for playing. File writing and playing are exclusize and is determined by cli flags.
-func handleOutput(ctx context.Context, pollyClient *polly.Client, s3Client *s3.Client, audioChan chan *s3.GetObjectOutput, logs chan string, s3Bucket, voiceID, text, outputFile string) error {
+func handleOutput(ctx context.Context, pollyClient *polly.Client, s3Client *s3.Client, audioChan chan *s3.GetObjectOutput, logs chan string, synth synthesisOpts, doc document, outputFile string, timeline *Timeline) error {
 	// Always close both channels so consumers (playWithProgressBar, dashboard log
 	// pane) are never left blocked waiting when we return early with an error.
 	defer close(audioChan)
 	defer close(logs)
 
 	// splitting the input allows us to handle input that is larger than the max input size of polly (200k)
-	var textSections = splitInput(text)
+	var textSections = doc.sections()
+	var textOffsets = sectionOffsets(doc.text, textSections)
 	logs <- fmt.Sprintf("The input text has been slpit into %d sections in order to comply with polly limits. \n", len(textSections))
 
-	for _, section := range textSections {
-		voice, s3File, err := synthesizeText(ctx, pollyClient, s3Client, logs, s3Bucket, voiceID, section)
+	// sections are concatenated into a single file, mp3 and ogg streams can simply be appended
+	var out *os.File
+	var wav = synth.format.wrapInWAV(outputFile)
+	var written int64
+	if strings.TrimSpace(outputFile) != DEFAULT_OUTPUT {
+		var err error
+		//nolint:gosec
+		out, err = os.OpenFile(outputFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0775)
+		if err != nil {
+			return fmt.Errorf("error creating file: %w", err)
+		}
+		defer out.Close()
+		// raw pcm needs a header to be playable, the sizes are filled in once all sections are written
+		if wav {
+			if err := writeWAVHeader(out, synth.format.pcmFormat(), 0); err != nil {
+				return fmt.Errorf("error writing file: %w", err)
+			}
+		}
+	}
+
+	var sectionStart time.Duration
+	for i, section := range textSections {
+		var synth = synth
+		if i < len(doc.segments) {
+			synth = doc.segments[i].synthesisOpts(synth)
+		}
+		audio, err := synthesizeSection(ctx, pollyClient, s3Client, logs, synth, section, sectionHeadings(doc.headings, textOffsets[i], section), synth.rate)
 		if err != nil {
 			logs <- fmt.Sprintf("ERROR: %v\n", err)
 			return fmt.Errorf("error from synthesisText: %w", err)
 		}
+		// subtitle cues are placed at their start time
+		if i < len(doc.cues) {
+			if audio, err = timeCue(ctx, pollyClient, s3Client, logs, synth, doc.cues[i], i, section, sectionStart, audio); err != nil {
+				return err
+			}
+		}
+		var voice = audio.voice
+
+		// the next section starts where this section's audio ends, this places the speech marks, the
+		// chapters and the next subtitle cue
+		body, err := io.ReadAll(voice.Body)
+		voice.Body.Close()
+		if err != nil {
+			return fmt.Errorf("error reading voice.Body: %w", err)
+		}
+		voice.Body = io.NopCloser(bytes.NewReader(body))
+		sectionLength, err := synth.format.duration(body)
+		if err != nil {
+			return fmt.Errorf("error getting audio duration: %w", err)
+		}
+		timeline.addSection(offsetSpeechMarks(audio.marks, i, sectionStart+audio.lead, textOffsets[i]), sectionStart+sectionLength, int64(len(body)), textOffsets[i], textOffsets[i]+len(section))
+		sectionStart += sectionLength
 
 		// output switch
-		if strings.TrimSpace(outputFile) != "output.mp3" {
-			body, err := io.ReadAll(voice.Body)
+		if out != nil {
+			n, err := io.Copy(out, voice.Body)
+			voice.Body.Close()
+			written += n
 			if err != nil {
-				return fmt.Errorf("error reading voice.Body: %w", err)
-			}
-			//nolint:gosec
-			if err := os.WriteFile(outputFile, body, 0775); err != nil {
 				return fmt.Errorf("error writing file: %w", err)
 			}
 		} else {
 			audioChan <- voice
 		}
 
-		// clean up s3 bucket
-		if err := deleteS3File(ctx, s3Client, s3Bucket, s3File); err != nil {
+		if err := cleanupS3Files(ctx, s3Client, logs, synth, audio.keys...); err != nil {
+			return err
+		}
+	}
+
+	if wav {
+		if _, err := out.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("error writing file: %w", err)
+		}
+		if err := writeWAVHeader(out, synth.format.pcmFormat(), written); err != nil {
+			return fmt.Errorf("error writing file: %w", err)
+		}
+	}
+	return nil
+}
+
+// sectionAudio is the synthesized audio of a section along with its speech marks.
+type sectionAudio struct {
+	voice *s3.GetObjectOutput
+	marks []SpeechMark  // relative to the start of the section's text and audio
+	keys  []string      // what polly wrote to s3
+	lead  time.Duration // silence before the speech, see timeCue
+}
+
+// synthesizeSection synthesizes the audio of a section of text, and its speech marks when they are
+// requested. With ssml, a pause, or a prosody rate other than 0 (normal speed) the text is sent as ssml.
+func synthesizeSection(ctx context.Context, pollyClient *polly.Client, s3Client *s3.Client, logs chan string, synth synthesisOpts, text string, headings []heading, rate int) (sectionAudio, error) {
+	// with ssml the marks point into the ssml and are moved back onto the text once fetched
+	var ssmlOffsets []int
+	if synth.ssml || synth.pause > 0 || rate > 0 {
+		text, ssmlOffsets = toSSML(text, headings)
+		if synth.pause > 0 {
+			text, ssmlOffsets = withLeadingBreak(text, ssmlOffsets, synth.pause)
+		}
+		if rate > 0 {
+			text, ssmlOffsets = withProsodyRate(text, ssmlOffsets, rate)
+		}
+		synth.ssml = true
+	}
+	// the speech marks task runs alongside the audio task and is always waited on so it
+	// never logs after the logs channel is closed
+	var marksResult <-chan speechMarksResult
+	if len(synth.marks) > 0 {
+		marksResult = startSpeechMarks(ctx, pollyClient, s3Client, logs, synth, text)
+	}
+	voice, s3File, err := synthesizeText(ctx, pollyClient, s3Client, logs, synth, text)
+	var marks speechMarksResult
+	if marksResult != nil {
+		marks = <-marksResult
+	}
+	var audio = sectionAudio{voice: voice, marks: marks.marks}
+	for _, key := range []string{s3File, marks.key} {
+		if key != "" {
+			audio.keys = append(audio.keys, key)
+		}
+	}
+	if err == nil {
+		err = marks.err
+	}
+	if err != nil {
+		return audio, err
+	}
+	if ssmlOffsets != nil {
+		audio.marks = ssmlSpeechMarks(audio.marks, ssmlOffsets)
+	}
+	return audio, nil
+}
+
+// cleanupS3Files deletes what polly wrote to s3 once it has been read, unless it is kept.
+func cleanupS3Files(ctx context.Context, s3Client *s3.Client, logs chan string, synth synthesisOpts, keys ...string) error {
+	for _, key := range keys {
+		if synth.keepS3 {
+			logs <- fmt.Sprintf("Keeping s3://%s/%s \n", synth.bucket, key)
+			continue
+		}
+		if err := deleteS3File(ctx, s3Client, synth.bucket, key); err != nil {
 			return fmt.Errorf("error deleting s3 files: %w", err)
 		}
 	}
 	return nil
 }
 
-// playWithProgressBar manages the progess bar and plays the audio
-func playWithProgressBar(audioChan chan *s3.GetObjectOutput, playbackProgress chan PlaybackProgress, errors chan error, pauseChan <-chan bool) {
-	var completedSeconds int
+// receivedSection is the audio of a section, kept after it is played so it can be played again after a seek.
+type receivedSection struct {
+	body   []byte
+	length time.Duration
+}
+
+// playWithProgressBar manages the progess bar and plays the audio. Sections are played in order,
+// a position on the timeline sent on seekChan stops the current one and continues from there, once
+// that far has been synthesized.
+func playWithProgressBar(sink AudioSink, audioFormat audioFormat, audioChan chan *s3.GetObjectOutput, playbackProgress chan PlaybackProgress, errors chan error, pauseChan <-chan bool, seekChan <-chan time.Duration) {
+	defer close(playbackProgress)
+	defer close(errors)
+	var sections []receivedSection
 	var grandTotal int
 	var paused atomic.Bool
 
 	// Forward pause signals from the channel into the shared atomic flag so both
-	// play() and the progress ticker goroutine can read it without racing.
+	// the sink and the progress goroutine can read it without racing.
 	go func() {
 		for p := range pauseChan {
 			paused.Store(p)
 		}
 	}()
 
-	for voice := range audioChan {
-		body, audioLength, err := prepareAudio(voice)
+	// from is how far into the next section to start, pending a seek past the sections received so far
+	var next, from, pending = 0, time.Duration(0), time.Duration(-1)
+	for {
+		// wait for the section to be synthesized, a seek can arrive in the meantime
+		for pending >= 0 || next >= len(sections) {
+			if pending >= 0 {
+				if i, offset, ok := locateSection(sections, pending); ok {
+					next, from, pending = i, offset, -1
+					continue
+				}
+			}
+			select {
+			case voice, ok := <-audioChan:
+				if !ok {
+					return
+				}
+				body, length, err := readAudio(voice, audioFormat)
+				if err != nil {
+					errors <- err
+					return
+				}
+				sections = append(sections, receivedSection{body: body, length: length})
+				grandTotal += int(length / time.Second)
+			case d := <-seekChan:
+				pending = max(d, 0)
+			}
+		}
+
+		pcm, format, audioLength, err := audioFormat.decode(sections[next].body)
 		if err != nil {
-			errors <- err
-			close(errors)
+			errors <- fmt.Errorf("error decoding audio: %w", err)
 			return
 		}
+		// skip whole frames up to the seek position
+		var skipped = int64(pcmSize(from, format))
+		if _, err := io.CopyN(io.Discard, pcm, skipped); err != nil && err != io.EOF {
+			errors <- fmt.Errorf("error decoding audio: %w", err)
+			return
+		}
+		var baseElapsed int
+		var base = pcmDuration(skipped, format)
+		for _, s := range sections[:next] {
+			baseElapsed += int(s.length / time.Second)
+			base += s.length
+		}
 
-		grandTotal += audioLength
-		sectionLen := audioLength
-		baseElapsed := completedSeconds
-		total := grandTotal
+		var skip = &skipReader{r: pcm}
+		var section = &countingReader{r: skip}
+		var done = make(chan struct{})
+		var reported = make(chan struct{})
+		var watched = make(chan struct{})
+		var seek = make(chan time.Duration, 1)
 
-		// send per-second progress ticks for this section, freezing while paused
-		go func() {
-			for i := range sectionLen {
-				for paused.Load() {
-					time.Sleep(50 * time.Millisecond)
+		// send progress for this section based on how much audio the sink has consumed, this freezes
+		// while paused and keeps up with sinks that play faster than real-time. Position is reported
+		// every tick for the dashboard's highlighting, the rest only changes once a second.
+		go func(index, sectionLen, baseElapsed, total int, base, from time.Duration) {
+			defer close(reported)
+			var last = time.Duration(-1)
+			var ticker = time.NewTicker(50 * time.Millisecond)
+			defer ticker.Stop()
+			for finished := false; ; {
+				var consumed = section.n.Load()
+				var position = base + pcmDuration(consumed, format)
+				if position != last {
+					last = position
+					var i = min(int((from+pcmDuration(consumed, format))/time.Second), max(sectionLen-1, 0))
+					playbackProgress <- PlaybackProgress{
+						Current:      i,
+						Total:        sectionLen,
+						GrandElapsed: baseElapsed + i,
+						GrandTotal:   total,
+						Position:     position,
+						Section:      index,
+					}
 				}
-				playbackProgress <- PlaybackProgress{
-					Current:      i,
-					Total:        sectionLen,
-					GrandElapsed: baseElapsed + i,
-					GrandTotal:   total,
+				if finished {
+					return
+				}
+				select {
+				case <-done:
+					finished = true
+				case <-ticker.C:
 				}
-				time.Sleep(time.Second)
+			}
+		}(next, audioLength, baseElapsed, grandTotal, base, pcmDuration(skipped, format))
+
+		// a seek ends the section early
+		go func() {
+			defer close(watched)
+			select {
+			case d := <-seekChan:
+				skip.skip.Store(true)
+				seek <- d
+			case <-done:
 			}
 		}()
 
-		if err := play(body, &paused); err != nil {
+		err = sink.Play(section, format, &paused)
+		close(done)
+		<-reported
+		<-watched
+		if err != nil {
 			errors <- fmt.Errorf("error playing audio: %w", err)
-			close(errors)
-			close(playbackProgress)
 			return
 		}
 
-		completedSeconds += audioLength
+		select {
+		case d := <-seek:
+			pending = max(d, 0)
+		default:
+			next, from = next+1, 0
+		}
 	}
-	close(errors)
-	close(playbackProgress)
 }
 
-// prepareAudio reads the voice body, writes it to a temp file to determine its
-// duration via ffmpeg, then returns a fresh reader and duration in seconds.
-func prepareAudio(voice *s3.GetObjectOutput) (io.Reader, int, error) {
-	const tempFile = "ffmpeg-detect-length-temp-file.mp3"
+// locateSection returns the section playing at d and how far into it d is, it is false when d is
+// past the sections received so far.
+func locateSection(sections []receivedSection, d time.Duration) (int, time.Duration, bool) {
+	var start time.Duration
+	for i, s := range sections {
+		if d < start+s.length {
+			return i, d - start, true
+		}
+		start += s.length
+	}
+	return 0, 0, false
+}
 
+// readAudio reads the voice body and returns it along with how long it plays for.
+func readAudio(voice *s3.GetObjectOutput, audioFormat audioFormat) ([]byte, time.Duration, error) {
 	body, err := io.ReadAll(voice.Body)
 	if err != nil {
 		return nil, 0, fmt.Errorf("error reading voice.Body: %w", err)
 	}
-	//nolint:gosec
-	if err := os.WriteFile(tempFile, body, 0775); err != nil {
-		return nil, 0, fmt.Errorf("error writing temp file: %w", err)
-	}
-	audioLength, err := getDuration(tempFile)
+	length, err := audioFormat.duration(body)
 	if err != nil {
-		return nil, 0, fmt.Errorf("error getting length from ffmpeg: %w", err)
+		return nil, 0, fmt.Errorf("error decoding audio: %w", err)
 	}
-	if err := os.RemoveAll(tempFile); err != nil {
-		return nil, 0, fmt.Errorf("error deleting temp file: %w", err)
+	return body, length, nil
+}
+
+// pcmDuration is how long n bytes of pcm play for.
+func pcmDuration(n int64, format pcmFormat) time.Duration {
+	return time.Duration(n) * time.Second / time.Duration(format.bytesPerSecond())
+}
+
+// skipReader ends the audio early once skip is set.
+type skipReader struct {
+	r    io.Reader
+	skip atomic.Bool
+}
+
+func (s *skipReader) Read(b []byte) (int, error) {
+	if s.skip.Load() {
+		return 0, io.EOF
 	}
-	return io.NopCloser(bytes.NewBuffer(body)), audioLength, nil
+	return s.r.Read(b)
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n.Add(int64(n))
+	return n, err
 }
 
 // logOutput is used to print logs if the dashboard is not in use.
 func logOutput(playbackProgress chan PlaybackProgress, logs chan string) {
+	var lastElapsed = -1
 	for playbackProgress != nil {
 		select {
 		case progress, ok := <-playbackProgress:
@@ -291,6 +865,11 @@ func logOutput(playbackProgress chan PlaybackProgress, logs chan string) {
 				playbackProgress = nil
 				continue
 			}
+			// progress is sent several times a second, log once per second of audio
+			if progress.GrandElapsed == lastElapsed {
+				continue
+			}
+			lastElapsed = progress.GrandElapsed
 			var pct float64
 			// dont divide by 0
 			if progress.GrandElapsed > 0 && progress.GrandTotal > 0 {
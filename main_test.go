@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/polly/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/kmulvey/text2speech/internal/fakeaws"
+	"github.com/stretchr/testify/assert"
+)
+
+// longText returns text that splitInput breaks into sections sections.
+func longText(sections int) string {
+	var sentence = "The quick brown fox jumps over the lazy dog. "
+	return strings.TrimSpace(strings.Repeat(sentence, (MAX_CHAR_COUNT*(sections-1))/len(sentence)+1))
+}
+
+func TestHandleOutputPlay(t *testing.T) {
+	var srv = newFakeAWS(t)
+	// the first section takes longer to synthesize, audio must still arrive in order
+	srv.Script(fakeaws.Task{Duration: 3 * time.Second, Pending: 3}, fakeaws.Task{Duration: 1 * time.Second})
+
+	var audioChan = make(chan *s3.GetObjectOutput, 5)
+	var logs = make(chan string, 100)
+	var text = longText(2)
+	var err = handleOutput(context.Background(), srv.PollyClient(), srv.S3Client(), audioChan, logs, synthesisOpts{bucket: "bucket", voiceID: "Matthew"}, document{text: text}, "output.mp3", nil)
+	assert.NoError(t, err)
+
+	var bodies [][]byte
+	for voice := range audioChan {
+		body, err := io.ReadAll(voice.Body)
+		assert.NoError(t, err)
+		bodies = append(bodies, body)
+	}
+	assert.Equal(t, [][]byte{fakeaws.SilentMP3(3*time.Second, 0), fakeaws.SilentMP3(time.Second, 0)}, bodies)
+
+	var started = srv.Started()
+	assert.Len(t, started, 2)
+	assert.Equal(t, splitInput(text, nil), []string{started[0].Text, started[1].Text})
+
+	// everything polly wrote has been cleaned up
+	assert.Empty(t, srv.Objects())
+	assert.Len(t, srv.Deleted(), 2)
+}
+
+func TestHandleOutputFile(t *testing.T) {
+	var srv = newFakeAWS(t)
+	srv.Script(fakeaws.Task{Duration: 2 * time.Second}, fakeaws.Task{Duration: time.Second})
+
+	var outputFile = filepath.Join(t.TempDir(), "speech.mp3")
+	var audioChan = make(chan *s3.GetObjectOutput, 5)
+	var logs = make(chan string, 100)
+	var synth = synthesisOpts{bucket: "bucket", keyPrefix: "archive/", voiceID: "Matthew", keepS3: true}
+	var err = handleOutput(context.Background(), srv.PollyClient(), srv.S3Client(), audioChan, logs, synth, document{text: longText(2)}, outputFile, nil)
+	assert.NoError(t, err)
+
+	// sections are concatenated in order
+	body, err := os.ReadFile(outputFile)
+	assert.NoError(t, err)
+	assert.Equal(t, bytes.Join([][]byte{fakeaws.SilentMP3(2*time.Second, 0), fakeaws.SilentMP3(time.Second, 0)}, nil), body)
+
+	_, ok := <-audioChan
+	assert.False(t, ok, "nothing is played when writing a file")
+
+	// -keep-s3 leaves the outputs in the bucket
+	assert.Equal(t, []string{
+		"bucket/archive/00000000-0000-4000-8000-000000000001.mp3",
+		"bucket/archive/00000000-0000-4000-8000-000000000002.mp3",
+	}, srv.Objects())
+	assert.Empty(t, srv.Deleted())
+}
+
+func TestHandleOutputKMS(t *testing.T) {
+	var srv = newFakeAWS(t)
+	srv.Script(fakeaws.Task{Duration: time.Second})
+
+	var synth = synthesisOpts{bucket: "bucket", keyPrefix: "archive/", kmsKeyID: "alias/speech", voiceID: "Matthew", keepS3: true}
+	var err = handleOutput(context.Background(), srv.PollyClient(), srv.S3Client(), make(chan *s3.GetObjectOutput, 5), make(chan string, 100), synth, document{text: "Hello."}, filepath.Join(t.TempDir(), "speech.mp3"), nil)
+	assert.NoError(t, err)
+
+	// the output is copied onto itself with SSE-KMS
+	encryption, ok := srv.Encryption("bucket/archive/00000000-0000-4000-8000-000000000001.mp3")
+	assert.True(t, ok)
+	assert.Equal(t, fakeaws.Encryption{Algorithm: "aws:kms", KMSKeyID: "alias/speech"}, encryption)
+}
+
+func TestHandleOutputErrors(t *testing.T) {
+	var srv = newFakeAWS(t)
+	srv.Script(fakeaws.Task{}, fakeaws.Task{FailReason: "throttled"})
+
+	var audioChan = make(chan *s3.GetObjectOutput, 5)
+	var logs = make(chan string, 100)
+	var err = handleOutput(context.Background(), srv.PollyClient(), srv.S3Client(), audioChan, logs, synthesisOpts{bucket: "bucket", voiceID: "Matthew"}, document{text: longText(2)}, "output.mp3", nil)
+	assert.ErrorContains(t, err, "throttled")
+
+	// the first section was delivered and cleaned up before the failure, and both channels are closed
+	var played int
+	for range audioChan {
+		played++
+	}
+	assert.Equal(t, 1, played)
+	var lastLog string
+	for msg := range logs {
+		lastLog = msg
+	}
+	assert.Contains(t, lastLog, "ERROR")
+	assert.Len(t, srv.Deleted(), 1)
+
+	// a failed delete is reported
+	srv.Script(fakeaws.Task{DenyDelete: true})
+	audioChan = make(chan *s3.GetObjectOutput, 5)
+	logs = make(chan string, 100)
+	err = handleOutput(context.Background(), srv.PollyClient(), srv.S3Client(), audioChan, logs, synthesisOpts{bucket: "bucket", voiceID: "Matthew"}, document{text: "hello"}, "output.mp3", nil)
+	assert.ErrorContains(t, err, "error deleting s3 files")
+	assert.Len(t, srv.Objects(), 1)
+}
+
+func TestNewAWSClientsEndpointOverride(t *testing.T) {
+	var srv = newFakeAWS(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "fake")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "fake")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+
+	pollyClient, s3Client, err := newAWSClients(context.Background(), awsOpts{region: "us-west-2", endpointURL: srv.URL, s3PathStyle: true})
+	assert.NoError(t, err)
+
+	var logs = make(chan string, 10)
+	_, key, err := synthesizeText(context.Background(), pollyClient, s3Client, logs, synthesisOpts{bucket: "bucket", voiceID: "Matthew"}, "hello")
+	assert.NoError(t, err)
+	assert.NoError(t, deleteS3File(context.Background(), s3Client, "bucket", key))
+	assert.Empty(t, srv.Objects())
+}
+
+func TestHandleOutputWAV(t *testing.T) {
+	var srv = newFakeAWS(t)
+	srv.Script(fakeaws.Task{Duration: 2 * time.Second}, fakeaws.Task{Duration: time.Second})
+
+	var outputFile = filepath.Join(t.TempDir(), "speech.wav")
+	var audioChan = make(chan *s3.GetObjectOutput, 5)
+	var logs = make(chan string, 100)
+	var synth = synthesisOpts{bucket: "bucket", voiceID: "Matthew", format: audioFormat{output: types.OutputFormatPcm}}
+	var err = handleOutput(context.Background(), srv.PollyClient(), srv.S3Client(), audioChan, logs, synth, document{text: longText(2)}, outputFile, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "pcm", srv.Started()[0].OutputFormat)
+
+	body, err := os.ReadFile(outputFile)
+	assert.NoError(t, err)
+	var header bytes.Buffer
+	assert.NoError(t, writeWAVHeader(&header, pcmFormat{sampleRate: 16000, channels: 1}, int64(16000*2*3)))
+	assert.Equal(t, header.Bytes(), body[:44])
+	assert.Len(t, body, 44+16000*2*3)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	extast "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// parseMarkdown renders markdown as the prose it would be read as. Headings become their own
+// sentence (the first and second levels start chapters), links are read by their text rather than
+// their url, images and html are dropped and code blocks and tables follow policy.
+func parseMarkdown(source []byte, policy codePolicy) document {
+	var md = goldmark.New(goldmark.WithExtensions(extension.GFM))
+	var r = &markdownRenderer{source: source, w: &proseWriter{policy: policy}}
+	r.block(md.Parser().Parse(text.NewReader(source)))
+	return r.w.document()
+}
+
+type markdownRenderer struct {
+	source []byte
+	w      *proseWriter
+}
+
+func (r *markdownRenderer) block(n ast.Node) {
+	switch n := n.(type) {
+	case *ast.Heading:
+		r.w.heading(r.inline(n), n.Level)
+	case *ast.Paragraph:
+		r.w.paragraph(r.inline(n))
+	case *ast.ListItem:
+		for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+			switch c.(type) {
+			case *ast.TextBlock, *ast.Paragraph:
+				r.w.item(r.inline(c))
+			default:
+				r.block(c)
+			}
+		}
+	case *ast.List:
+		r.w.endList()
+		r.children(n)
+		r.w.endList()
+	case *ast.FencedCodeBlock:
+		r.w.code(string(n.Language(r.source)), r.lines(n))
+	case *ast.CodeBlock:
+		r.w.code("", r.lines(n))
+	case *extast.Table:
+		r.table(n)
+	case *ast.HTMLBlock, *ast.ThematicBreak:
+	default:
+		r.children(n)
+	}
+}
+
+func (r *markdownRenderer) children(n ast.Node) {
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		r.block(c)
+	}
+}
+
+// inline returns the text of an inline container with whitespace collapsed.
+func (r *markdownRenderer) inline(n ast.Node) string {
+	var b strings.Builder
+	var walk func(n ast.Node)
+	walk = func(n ast.Node) {
+		for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+			switch c := c.(type) {
+			case *ast.Text:
+				b.Write(c.Value(r.source))
+				if c.SoftLineBreak() || c.HardLineBreak() {
+					b.WriteByte(' ')
+				}
+			case *ast.String:
+				b.Write(c.Value)
+			case *ast.AutoLink:
+				// bare urls are not worth reading out
+				b.WriteString("link")
+			case *ast.Image, *ast.RawHTML, *extast.TaskCheckBox:
+			default:
+				walk(c)
+			}
+		}
+	}
+	walk(n)
+	return collapseSpace(b.String())
+}
+
+// lines returns the raw content of a code block.
+func (r *markdownRenderer) lines(n ast.Node) string {
+	var b strings.Builder
+	var lines = n.Lines()
+	for i := range lines.Len() {
+		var line = lines.At(i)
+		b.Write(line.Value(r.source))
+	}
+	return b.String()
+}
+
+func (r *markdownRenderer) table(n *extast.Table) {
+	var headers []string
+	var rows [][]string
+	for row := n.FirstChild(); row != nil; row = row.NextSibling() {
+		var cells []string
+		for cell := row.FirstChild(); cell != nil; cell = cell.NextSibling() {
+			cells = append(cells, r.inline(cell))
+		}
+		if _, ok := row.(*extast.TableHeader); ok {
+			headers = cells
+		} else {
+			rows = append(rows, cells)
+		}
+	}
+	r.w.table(headers, rows)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/polly/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+)
+
+const markdownInput = "# The Book\n\nSome *intro* with a [link](https://example.com) and https://example.org.\n\n" +
+	"![cover](cover.png)\n\n## Chapter One\n\n- first item\n- second item\n\n" +
+	"```go\nfunc main() {\n}\n```\n\n| Name | Age |\n| --- | --- |\n| Alice | 30 |\n| Bob | 41 |\n\n<div>html</div>\n\nThe end.\n"
+
+func TestParseMarkdown(t *testing.T) {
+	t.Parallel()
+
+	var doc = parseMarkdown([]byte(markdownInput), codeSummarize)
+	assert.Equal(t, "The Book.\n\nSome intro with a link and link.\n\nChapter One.\n\nfirst item.\nsecond item.\n\n"+
+		"Code sample in go, 2 lines.\n\nTable of Name and Age with 2 rows.\n\nThe end.", doc.text)
+	assert.Equal(t, "The Book", doc.title)
+	assert.Equal(t, []Chapter{{Title: "The Book", Start: 0}, {Title: "Chapter One", Start: strings.Index(doc.text, "Chapter One")}}, doc.chapters)
+	for _, h := range doc.headings {
+		assert.True(t, strings.HasSuffix(doc.text[h.start:h.end], "."))
+	}
+
+	doc = parseMarkdown([]byte(markdownInput), codeRead)
+	assert.Contains(t, doc.text, "func main() {\n}")
+	assert.Contains(t, doc.text, "Name: Alice, Age: 30.\nName: Bob, Age: 41.")
+
+	doc = parseMarkdown([]byte(markdownInput), codeSkip)
+	assert.Contains(t, doc.text, "second item.\n\nThe end.")
+}
+
+func TestParseDocument(t *testing.T) {
+	t.Parallel()
+
+	var opts = documentOpts{format: "auto", code: codeSkip, chapters: true}
+	doc, err := parseDocument("# The Book", "book.md", opts)
+	assert.NoError(t, err)
+	assert.Equal(t, "The Book.", doc.text)
+	doc, err = parseDocument("# The Book", "book.txt", opts)
+	assert.NoError(t, err)
+	assert.Equal(t, "# The Book", doc.text)
+	assert.Len(t, doc.chapters, 1)
+
+	opts.chapters = false
+	doc, err = parseDocument("# The Book", "book.md", opts)
+	assert.NoError(t, err)
+	assert.Empty(t, doc.chapters)
+
+	format, err := parseInputFormat("MD")
+	assert.NoError(t, err)
+	assert.Equal(t, "markdown", format)
+	_, err = parseInputFormat("pdf")
+	assert.ErrorIs(t, err, errUnknownInputFormat)
+	_, err = parseCodePolicy("run")
+	assert.ErrorIs(t, err, errUnknownCodePolicy)
+}
+
+func TestToSSML(t *testing.T) {
+	t.Parallel()
+
+	var text = "Q&A.\n\nIs 1 < 2?"
+	ssml, offsets := toSSML(text, []heading{{start: 0, end: 4, level: 1}})
+	assert.Equal(t, `<speak><emphasis level="strong">Q&amp;A.</emphasis><break time="1s"/>`+"\n\nIs 1 &lt; 2?</speak>", ssml)
+	assert.Len(t, offsets, len(ssml)+1)
+
+	var marks = ssmlSpeechMarks([]SpeechMark{
+		{Start: strings.Index(ssml, "Q&amp;A."), End: strings.Index(ssml, "</emphasis>")},
+		{Start: strings.Index(ssml, "&lt;"), End: strings.Index(ssml, " 2?")},
+	}, offsets)
+	assert.Equal(t, "Q&A.", text[marks[0].Start:marks[0].End])
+	assert.Equal(t, "<", text[marks[1].Start:marks[1].End])
+}
+
+func TestHandleOutputSSML(t *testing.T) {
+	var srv = newFakeAWS(t)
+
+	var doc = parseMarkdown([]byte("# Fish & Chips\n\nA <b>great</b> meal."), codeSkip)
+	var timeline = &Timeline{}
+	var synth = synthesisOpts{bucket: "bucket", voiceID: "Matthew", ssml: true, marks: []types.SpeechMarkType{types.SpeechMarkTypeWord}}
+	var err = handleOutput(context.Background(), srv.PollyClient(), srv.S3Client(), make(chan *s3.GetObjectOutput, 5), make(chan string, 100), synth, doc, "output.mp3", timeline)
+	assert.NoError(t, err)
+
+	for _, task := range srv.Started() {
+		assert.Equal(t, "ssml", task.TextType)
+		assert.True(t, strings.HasPrefix(task.Text, "<speak>"), task.Text)
+	}
+	// the word marks point back into the document text
+	var words []string
+	for _, m := range timeline.Marks(types.SpeechMarkTypeWord) {
+		words = append(words, doc.text[m.Start:m.End])
+	}
+	assert.Equal(t, strings.Fields(doc.text), words)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// bookInfo is the metadata written into the output file.
+type bookInfo struct {
+	title  string
+	author string
+	cover  string // path to a jpeg or png, m4b only
+}
+
+// inferTitle picks a title when -title is not set: a markdown heading on the first line of the
+// text, otherwise the name of the input file.
+func inferTitle(text, inputFile string) string {
+	var firstLine, _, _ = strings.Cut(strings.TrimSpace(text), "\n")
+	if m := markdownHeadingRegex.FindStringSubmatch(strings.TrimSpace(firstLine)); m != nil {
+		return m[1]
+	}
+	var name = filepath.Base(strings.TrimSpace(inputFile))
+	if name == "." || name == "/" {
+		return ""
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var errUnknownNormalizeRule = errors.New("unknown normalization rule")
+
+// normalizeRule rewrites the matches of a pattern into something polly reads well.
+type normalizeRule struct {
+	name    string
+	pattern *regexp.Regexp
+	replace func(match []string) string
+}
+
+// normalizeRuleNames are the rules -normalize can turn on, in the order they are best run in: urls
+// and paths first so the other rules leave them alone.
+var normalizeRuleNames = []string{"urls", "paths", "emoji", "abbreviations", "numbers"}
+
+// abbreviations are expanded by the abbreviations rule, -abbreviations adds to them.
+var abbreviations = map[string]string{
+	"e.g.":    "for example",
+	"i.e.":    "that is",
+	"etc.":    "et cetera",
+	"vs.":     "versus",
+	"approx.": "approximately",
+	"w/o":     "without",
+	"w/":      "with",
+	"cf.":     "compare",
+}
+
+// abbreviationsBeforeNames are usually followed by a name, a capital after them does not start a sentence.
+var abbreviationsBeforeNames = []string{"e.g.", "i.e.", "vs.", "cf."}
+
+// unitName is how a unit is read after one and after any other number.
+type unitName struct {
+	one, many string
+}
+
+// units are read out in full after a number and a space. Units of a single letter are left out,
+// they are read fine on their own and would turn "1990s" into years of seconds.
+var units = map[string]unitName{
+	"ms": {"millisecond", "milliseconds"}, "min": {"minute", "minutes"},
+	"KB": {"kilobyte", "kilobytes"}, "MB": {"megabyte", "megabytes"}, "GB": {"gigabyte", "gigabytes"}, "TB": {"terabyte", "terabytes"},
+	"KiB": {"kibibyte", "kibibytes"}, "MiB": {"mebibyte", "mebibytes"}, "GiB": {"gibibyte", "gibibytes"}, "TiB": {"tebibyte", "tebibytes"},
+	"Kbps": {"kilobit per second", "kilobits per second"}, "Mbps": {"megabit per second", "megabits per second"}, "Gbps": {"gigabit per second", "gigabits per second"},
+	"Hz": {"hertz", "hertz"}, "kHz": {"kilohertz", "kilohertz"}, "MHz": {"megahertz", "megahertz"}, "GHz": {"gigahertz", "gigahertz"},
+	"mm": {"millimetre", "millimetres"}, "cm": {"centimetre", "centimetres"}, "km": {"kilometre", "kilometres"},
+	"mg": {"milligram", "milligrams"}, "kg": {"kilogram", "kilograms"},
+	"°C": {"degree Celsius", "degrees Celsius"}, "°F": {"degree Fahrenheit", "degrees Fahrenheit"},
+}
+
+var (
+	urlRegex     = regexp.MustCompile(`\b(?:https?://|www\.)[^\s<>"']+`)
+	pathRegex    = regexp.MustCompile(`(?:^|[\s(])((?:~|\.{1,2})?(?:/[\w.-]+){2,}/?)`)
+	emojiRegex   = regexp.MustCompile(`[ \t]*[\x{1F000}-\x{1FAFF}\x{2600}-\x{27BF}\x{2B00}-\x{2BFF}\x{1F1E6}-\x{1F1FF}][\x{1F000}-\x{1FAFF}\x{2600}-\x{27BF}\x{2B00}-\x{2BFF}\x{1F1E6}-\x{1F1FF}\x{1F3FB}-\x{1F3FF}\x{FE0F}\x{200D}\x{20E3}]*`)
+	versionRegex = regexp.MustCompile(`\b([vV])?(\d+(?:\.\d+){2,})\b`)
+)
+
+// newNormalizeRule builds one of the named rules, abbreviations includes the extra expansions.
+func newNormalizeRule(name string, extra map[string]string) (normalizeRule, error) {
+	switch name {
+	case "urls":
+		// a link is read as its domain
+		return normalizeRule{name: name, pattern: urlRegex, replace: func(m []string) string {
+			var trimmed = strings.TrimRight(m[0], ".,;:!?)")
+			var host = strings.TrimPrefix(strings.TrimPrefix(strings.TrimPrefix(trimmed, "https://"), "http://"), "www.")
+			host, _, _ = strings.Cut(host, "/")
+			host, _, _ = strings.Cut(host, "?")
+			return host + m[0][len(trimmed):]
+		}}, nil
+	case "paths":
+		return normalizeRule{name: name, pattern: pathRegex, replace: func(m []string) string {
+			var path = strings.Trim(strings.TrimLeft(m[1], "~."), "/")
+			return strings.TrimSuffix(m[0], m[1]) + strings.ReplaceAll(path, "/", " slash ")
+		}}, nil
+	case "emoji":
+		return normalizeRule{name: name, pattern: emojiRegex, replace: func([]string) string { return "" }}, nil
+	case "abbreviations":
+		var table = make(map[string]string, len(abbreviations)+len(extra))
+		for _, m := range []map[string]string{abbreviations, extra} {
+			for k, v := range m {
+				table[k] = v
+			}
+		}
+		var keys = make([]string, 0, len(table))
+		for k := range table {
+			keys = append(keys, k)
+		}
+		// longest first so w/o wins over w/
+		slices.SortFunc(keys, func(a, b string) int { return len(b) - len(a) })
+		var alternatives = make([]string, len(keys))
+		for i, k := range keys {
+			alternatives[i] = regexp.QuoteMeta(k)
+			if isWordByte(k[len(k)-1]) {
+				alternatives[i] += `\b`
+			}
+		}
+		// an abbreviation that ends a sentence keeps its period: at the end of a line or before a capital
+		var list = strings.Join(alternatives, "|")
+		var pattern = regexp.MustCompile(`(?m)\b(?:(` + list + `)([ \t]*$|\s+\p{Lu})|(` + list + `))`)
+		return normalizeRule{name: name, pattern: pattern, replace: func(m []string) string {
+			if m[1] == "" {
+				return table[m[3]]
+			}
+			var endsSentence = strings.TrimSpace(m[2]) == "" || !slices.Contains(abbreviationsBeforeNames, m[1])
+			if strings.HasSuffix(m[1], ".") && endsSentence {
+				return table[m[1]] + "." + m[2]
+			}
+			return table[m[1]] + m[2]
+		}}, nil
+	case "numbers":
+		// versions are read point by point and units in full
+		var names = make([]string, 0, len(units))
+		for k := range units {
+			names = append(names, regexp.QuoteMeta(k))
+		}
+		slices.SortFunc(names, func(a, b string) int { return len(b) - len(a) })
+		var pattern = regexp.MustCompile(versionRegex.String() + `|\b(\d+(?:\.\d+)?) (` + strings.Join(names, "|") + `)\b`)
+		return normalizeRule{name: name, pattern: pattern, replace: func(m []string) string {
+			if m[2] != "" {
+				var version = strings.ReplaceAll(m[2], ".", " point ")
+				if m[1] != "" {
+					return "version " + version
+				}
+				return version
+			}
+			if m[3] == "1" {
+				return m[3] + " " + units[m[4]].one
+			}
+			return m[3] + " " + units[m[4]].many
+		}}, nil
+	}
+	return normalizeRule{}, fmt.Errorf("%w: %s, must be one of %v or none", errUnknownNormalizeRule, name, normalizeRuleNames)
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// parseNormalizeRules parses the comma separated -normalize flag, "none" turns normalization off.
+func parseNormalizeRules(list string, extra map[string]string) ([]normalizeRule, error) {
+	var rules []normalizeRule
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || name == "none" || slices.ContainsFunc(rules, func(r normalizeRule) bool { return r.name == name }) {
+			continue
+		}
+		rule, err := newNormalizeRule(name, extra)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// readAbbreviations reads a file of "abbreviation=expansion" lines, blank lines and lines starting
+// with # are skipped.
+func readAbbreviations(path string) (map[string]string, error) {
+	if path == "" {
+		return nil, nil //nolint:nilnil // no file
+	}
+	//nolint:gosec
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read abbreviations: %w", err)
+	}
+	defer file.Close()
+	var table = make(map[string]string)
+	var scanner = bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		var text = strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		abbreviation, expansion, ok := strings.Cut(text, "=")
+		if !ok || strings.TrimSpace(abbreviation) == "" {
+			return nil, fmt.Errorf("%s:%d: expected abbreviation=expansion", path, line)
+		}
+		table[strings.TrimSpace(abbreviation)] = strings.TrimSpace(expansion)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read abbreviations: %w", err)
+	}
+	return table, nil
+}
+
+// textEdit is a replacement made by a rule, in the offsets of the text before it.
+type textEdit struct {
+	start, end int
+	with       string
+}
+
+// normalizeText applies the rules in order and returns the result along with a function that
+// moves an offset in text to the matching offset in the result.
+func normalizeText(text string, rules []normalizeRule) (string, func(int) int) {
+	var steps [][]textEdit
+	for _, rule := range rules {
+		var edits []textEdit
+		var result strings.Builder
+		var last int
+		for _, loc := range rule.pattern.FindAllStringSubmatchIndex(text, -1) {
+			var match = make([]string, len(loc)/2)
+			for i := range match {
+				if loc[2*i] >= 0 {
+					match[i] = text[loc[2*i]:loc[2*i+1]]
+				}
+			}
+			var with = rule.replace(match)
+			if with == match[0] {
+				continue
+			}
+			edits = append(edits, textEdit{start: loc[0], end: loc[1], with: with})
+			result.WriteString(text[last:loc[0]])
+			result.WriteString(with)
+			last = loc[1]
+		}
+		if edits == nil {
+			continue
+		}
+		result.WriteString(text[last:])
+		text = result.String()
+		steps = append(steps, edits)
+	}
+
+	return text, func(offset int) int {
+		for _, edits := range steps {
+			var shift int
+			for _, e := range edits {
+				if offset < e.end {
+					if offset > e.start {
+						// inside a replacement, move to its start
+						offset = e.start
+					}
+					break
+				}
+				shift += len(e.with) - (e.end - e.start)
+			}
+			offset += shift
+		}
+		return offset
+	}
+}
+
+// normalizeDocument applies the rules to the text of doc, keeping its chapters and headings in place.
+// The cues of subtitles are normalized one by one.
+func normalizeDocument(doc document, rules []normalizeRule) document {
+	if len(rules) == 0 {
+		return doc
+	}
+	if doc.cues != nil {
+		doc.cues = slices.Clone(doc.cues)
+		var texts = make([]string, len(doc.cues))
+		for i := range doc.cues {
+			doc.cues[i].text, _ = normalizeText(doc.cues[i].text, rules)
+			texts[i] = doc.cues[i].text
+		}
+		doc.text = strings.Join(texts, "\n\n")
+		return doc
+	}
+	if doc.segments != nil {
+		doc.segments = slices.Clone(doc.segments)
+		var texts = make([]string, len(doc.segments))
+		for i := range doc.segments {
+			doc.segments[i].text, _ = normalizeText(doc.segments[i].text, rules)
+			texts[i] = doc.segments[i].text
+		}
+		doc.text = strings.Join(texts, "\n\n")
+		return doc
+	}
+
+	var text, move = normalizeText(doc.text, rules)
+	doc.text = text
+	var chapters = make([]Chapter, len(doc.chapters))
+	for i, c := range doc.chapters {
+		c.Start = move(c.Start)
+		chapters[i] = c
+	}
+	doc.chapters = chapters
+	var headings = make([]heading, len(doc.headings))
+	for i, h := range doc.headings {
+		headings[i] = heading{start: move(h.start), end: move(h.end), level: h.level}
+	}
+	doc.headings = headings
+	return doc
+}
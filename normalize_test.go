@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeText(t *testing.T) {
+	t.Parallel()
+
+	rules, err := parseNormalizeRules(strings.Join(normalizeRuleNames, ","), map[string]string{"k8s": "kubernetes"})
+	assert.NoError(t, err)
+	for in, want := range map[string]string{
+		"See https://www.example.com/docs?page=2.":            "See example.com.",
+		"Edit /etc/nginx/nginx.conf (or ~/app/config/).":      "Edit etc slash nginx slash nginx.conf (or app slash config).",
+		"Shipped 🚀🎉 today 👍🏽!":                                "Shipped today!",
+		"Tools, e.g. k8s w/o helm, etc.":                      "Tools, for example kubernetes without helm, et cetera.",
+		"Upgrade to v1.2.10, it takes 300 ms and 1.5 GB.":     "Upgrade to version 1 point 2 point 10, it takes 300 milliseconds and 1.5 gigabytes.",
+		"It takes 1 min and 1 GB, not 300ms.":                 "It takes 1 minute and 1 gigabyte, not 300ms.",
+		"In the 1990s it took 1 s. Or 2 m.":                   "In the 1990s it took 1 s. Or 2 m.",
+		"Apples, pears etc. Then Go vs. Rust, e.g. Tokio.":    "Apples, pears et cetera. Then Go versus Rust, for example Tokio.",
+		"Lists, etc.\nNext line":                              "Lists, et cetera.\nNext line",
+		"Nothing to do in 2024, 10 more items, and/or 1/2/3.": "Nothing to do in 2024, 10 more items, and/or 1/2/3.",
+	} {
+		got, _ := normalizeText(in, rules)
+		assert.Equal(t, want, got, in)
+	}
+
+	none, err := parseNormalizeRules("none", nil)
+	assert.NoError(t, err)
+	assert.Empty(t, none)
+	_, err = parseNormalizeRules("urls,typos", nil)
+	assert.ErrorIs(t, err, errUnknownNormalizeRule)
+}
+
+func TestNormalizeDocument(t *testing.T) {
+	t.Parallel()
+
+	rules, err := parseNormalizeRules("urls,paths,numbers", nil)
+	assert.NoError(t, err)
+	var doc = parseMarkdown([]byte("Installed in /usr/local/bin.\n\n# Release v2.0.1\n\nIt is 5 MB."), codeSkip)
+	var normalized = normalizeDocument(doc, rules)
+	assert.Equal(t, "Installed in usr slash local slash bin.\n\nRelease version 2 point 0 point 1.\n\nIt is 5 megabytes.", normalized.text)
+	// chapters and headings still point at the headings
+	assert.Equal(t, "Release version 2 point 0 point 1.", normalized.text[normalized.headings[0].start:normalized.headings[0].end])
+	assert.Equal(t, normalized.headings[0].start, normalized.chapters[0].Start)
+	// the original is left alone
+	assert.Contains(t, doc.text, "/usr/local/bin")
+
+	var subtitles = document{text: "See www.example.com\n\nBye", cues: []cue{{start: 0, end: time.Second, text: "See www.example.com"}, {start: time.Second, end: 2 * time.Second, text: "Bye"}}}
+	normalized = normalizeDocument(subtitles, rules)
+	assert.Equal(t, "See example.com\n\nBye", normalized.text)
+	assert.Equal(t, "See example.com", normalized.cues[0].text)
+	assert.Equal(t, "See www.example.com", subtitles.cues[0].text)
+}
+
+func TestReadAbbreviations(t *testing.T) {
+	t.Parallel()
+
+	var path = filepath.Join(t.TempDir(), "abbreviations.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("# team names\nSRE = site reliability engineering\n\nPM=product manager\n"), 0600))
+	table, err := readAbbreviations(path)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"SRE": "site reliability engineering", "PM": "product manager"}, table)
+
+	assert.NoError(t, os.WriteFile(path, []byte("SRE\n"), 0600))
+	_, err = readAbbreviations(path)
+	assert.Error(t, err)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+
+	"github.com/jfreymuth/oggvorbis"
+)
+
+// errOpusNeedsFFmpeg is returned when ogg_opus is played without ffmpeg, there is no opus decoder in go.
+var errOpusNeedsFFmpeg = errors.New("playing ogg_opus needs ffmpeg on the PATH to decode the opus")
+
+// opusSampleRate is the rate opus always decodes at.
+const opusSampleRate = 48000
+
+// decodeVorbis decodes an ogg vorbis stream to 16 bit pcm at the stream's own rate and channels.
+func decodeVorbis(body []byte) (io.Reader, pcmFormat, int, error) {
+	vorbis, err := oggvorbis.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, pcmFormat{}, 0, fmt.Errorf("%w: %w", errInvalidOgg, err)
+	}
+	frames, rate, err := oggLength(body)
+	if err != nil {
+		return nil, pcmFormat{}, 0, err
+	}
+	var format = pcmFormat{sampleRate: vorbis.SampleRate(), channels: vorbis.Channels()}
+	return &vorbisReader{vorbis: vorbis, samples: make([]float32, 4096*format.channels)}, format, int(frames / rate), nil
+}
+
+// vorbisReader converts the float samples of a vorbis stream to signed 16 bit little endian pcm.
+type vorbisReader struct {
+	vorbis  *oggvorbis.Reader
+	samples []float32
+	pending []byte
+}
+
+func (r *vorbisReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		n, err := r.vorbis.Read(r.samples)
+		r.pending = r.pending[:0]
+		for _, sample := range r.samples[:n] {
+			// the decoder clamps its samples to [-1, 1]
+			r.pending = binary.LittleEndian.AppendUint16(r.pending, uint16(int16(math.Round(float64(sample)*math.MaxInt16))))
+		}
+		if err != nil && len(r.pending) == 0 {
+			if errors.Is(err, io.EOF) {
+				return 0, io.EOF
+			}
+			return 0, fmt.Errorf("%w: %w", errInvalidOgg, err)
+		}
+	}
+	var n = copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// decodeOpus decodes an ogg opus stream to 16 bit mono pcm with ffmpeg.
+func decodeOpus(body []byte) (io.Reader, pcmFormat, int, error) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return nil, pcmFormat{}, 0, errOpusNeedsFFmpeg
+	}
+	var stdout, stderr bytes.Buffer
+	var cmd = exec.Command("ffmpeg", "-hide_banner", "-loglevel", "error", "-i", "pipe:0", "-f", "s16le", "-ac", "1", "-ar", fmt.Sprint(opusSampleRate), "pipe:1")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(body), &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, pcmFormat{}, 0, fmt.Errorf("ffmpeg: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	var format = pcmFormat{sampleRate: opusSampleRate, channels: 1}
+	return &stdout, format, stdout.Len() / format.bytesPerSecond(), nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/polly/types"
+)
+
+// defaultFileTemplate names the files written to -output-dir.
+const defaultFileTemplate = "{index:03}-{title}.{ext}"
+
+// playlistFile is the m3u playlist written to -output-dir.
+const playlistFile = "playlist.m3u"
+
+var (
+	errInvalidTemplate = errors.New("invalid -output-template")
+	errOutputAndDir    = errors.New("-output and -output-dir cannot be used together")
+)
+
+// templateFieldRegex matches {name} and {name:width} in an output template.
+var templateFieldRegex = regexp.MustCompile(`\{(\w+)(?::(\d+))?\}`)
+
+// validateFileTemplate checks the template only uses {index}, {title} and {ext} and names each file differently.
+func validateFileTemplate(template string) error {
+	var hasIndex bool
+	for _, m := range templateFieldRegex.FindAllStringSubmatch(template, -1) {
+		switch m[1] {
+		case "index":
+			hasIndex = true
+		case "title", "ext":
+		default:
+			return fmt.Errorf("%w: unknown field {%s}, use {index}, {title} or {ext}", errInvalidTemplate, m[1])
+		}
+	}
+	if !hasIndex {
+		return fmt.Errorf("%w: %s needs {index} so every file has a different name", errInvalidTemplate, template)
+	}
+	if strings.ContainsAny(templateFieldRegex.ReplaceAllString(template, ""), `/\`) {
+		return fmt.Errorf("%w: %s must be a file name, not a path", errInvalidTemplate, template)
+	}
+	return nil
+}
+
+// expandFileTemplate fills in the template for the file at index (counting from 1).
+func expandFileTemplate(template string, index int, title, ext string) string {
+	return templateFieldRegex.ReplaceAllStringFunc(template, func(field string) string {
+		var m = templateFieldRegex.FindStringSubmatch(field)
+		switch m[1] {
+		case "index":
+			var width, _ = strconv.Atoi(m[2])
+			return fmt.Sprintf("%0*d", width, index)
+		case "title":
+			return sanitizeFileName(title)
+		default:
+			return ext
+		}
+	})
+}
+
+// sanitizeFileName replaces the characters that are not allowed in file names on common filesystems.
+func sanitizeFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, strings.Join(strings.Fields(name), " "))
+	name = strings.Trim(name, ". ")
+	if name == "" {
+		return "untitled"
+	}
+	return name
+}
+
+// fileExtension is the extension of the files written for the format, pcm is wrapped in wav.
+func fileExtension(format types.OutputFormat) string {
+	switch format {
+	case types.OutputFormatOggVorbis:
+		return "ogg"
+	case types.OutputFormatOggOpus:
+		return "opus"
+	case types.OutputFormatPcm:
+		return "wav"
+	default:
+		return "mp3"
+	}
+}
+
+// outputDirSourceFile is where every section is written before it is split into -output-dir. It is
+// next to the directory, which is only created once there is audio to split into it.
+func outputDirSourceFile(dir string) string {
+	dir = filepath.Clean(dir)
+	return filepath.Join(filepath.Dir(dir), "."+filepath.Base(dir)+".text2speech.part")
+}
+
+// writeOutputDir splits the audio at source, which holds every section back to back, into a file
+// per chapter in dir and writes an m3u playlist of them in order. mp3 files are tagged with tag,
+// titled by chapter.
+func writeOutputDir(source, dir, template string, format audioFormat, markers []chapterMarker, sections []TimelineSection, tag id3Tag) error {
+	if err := os.MkdirAll(dir, 0o775); err != nil {
+		return fmt.Errorf("cannot create output dir %s: %w", dir, err)
+	}
+	//nolint:gosec
+	audio, err := os.Open(source)
+	if err != nil {
+		return fmt.Errorf("error splitting output: %w", err)
+	}
+	defer audio.Close()
+
+	// where each section starts in source
+	var offsets = make([]int64, len(sections)+1)
+	for i, s := range sections {
+		offsets[i+1] = offsets[i] + s.Size
+	}
+
+	var playlist = []string{"#EXTM3U"}
+	for i, m := range markers {
+		var name = expandFileTemplate(template, i+1, m.title, fileExtension(format.output))
+		var start, end = offsets[m.sections[0]], offsets[m.sections[1]]
+		var chapterTag *id3Tag
+		if format.output == types.OutputFormatMp3 || format.output == "" {
+			var t = tag
+			t.title, t.track, t.tracks = m.title, i+1, len(markers)
+			chapterTag = &t
+		}
+		if err := writeOutputFile(filepath.Join(dir, name), io.NewSectionReader(audio, start, end-start), format, chapterTag); err != nil {
+			return err
+		}
+		playlist = append(playlist, fmt.Sprintf("#EXTINF:%d,%s", int((m.end-m.start).Seconds()), m.title), name)
+	}
+
+	//nolint:gosec
+	if err := os.WriteFile(filepath.Join(dir, playlistFile), []byte(strings.Join(playlist, "\n")+"\n"), 0o644); err != nil {
+		return fmt.Errorf("error writing playlist: %w", err)
+	}
+	return nil
+}
+
+// writeOutputFile writes one chapter, pcm gets a wav header and mp3 the tag.
+func writeOutputFile(path string, audio *io.SectionReader, format audioFormat, tag *id3Tag) error {
+	//nolint:gosec
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating file: %w", err)
+	}
+	defer file.Close()
+
+	var w = bufio.NewWriter(file)
+	switch {
+	case format.output == types.OutputFormatPcm:
+		err = writeWAVHeader(w, format.pcmFormat(), audio.Size())
+	case tag != nil:
+		_, err = w.Write(tag.bytes())
+	}
+	if err != nil {
+		return fmt.Errorf("error writing file: %w", err)
+	}
+	if _, err := io.Copy(w, audio); err != nil {
+		return fmt.Errorf("error writing file: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("error writing file: %w", err)
+	}
+	return nil
+}
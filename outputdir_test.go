@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/polly/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/kmulvey/text2speech/internal/fakeaws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileTemplate(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, validateFileTemplate(defaultFileTemplate))
+	assert.ErrorIs(t, validateFileTemplate("{title}.mp3"), errInvalidTemplate)
+	assert.ErrorIs(t, validateFileTemplate("{index}-{chapter}.mp3"), errInvalidTemplate)
+	assert.ErrorIs(t, validateFileTemplate("parts/{index}.mp3"), errInvalidTemplate)
+
+	assert.Equal(t, "007-Chapter 1_ The Start.mp3", expandFileTemplate(defaultFileTemplate, 7, "Chapter 1: The Start", "mp3"))
+	assert.Equal(t, "12 untitled.ogg", expandFileTemplate("{index} {title}.{ext}", 12, " ... ", "ogg"))
+}
+
+func TestWriteOutputDir(t *testing.T) {
+	var srv = newFakeAWS(t)
+	srv.Script(fakeaws.Task{Duration: time.Second}, fakeaws.Task{Duration: 2 * time.Second}, fakeaws.Task{Duration: time.Second})
+
+	var text = "Intro.\n# One\nFirst chapter.\n# Two / Last\nSecond chapter."
+	var chapters = detectChapters(text, nil)
+	// the directory is created once the audio is split into it
+	var dir = filepath.Join(t.TempDir(), "book")
+	var timeline = &Timeline{}
+	var synth = synthesisOpts{bucket: "bucket", voiceID: "Matthew"}
+	var err = handleOutput(context.Background(), srv.PollyClient(), srv.S3Client(), make(chan *s3.GetObjectOutput, 5), make(chan string, 100), synth, document{text: text, chapters: chapters, sectionPerChapter: true}, outputDirSourceFile(dir), timeline)
+	assert.NoError(t, err)
+
+	var tag = newID3Tag(bookInfo{title: "Book"}, "Matthew", "", nil)
+	assert.NoDirExists(t, dir)
+	assert.NoError(t, writeOutputDir(outputDirSourceFile(dir), dir, defaultFileTemplate, synth.format, chapterMarkers(chapters, timeline), timeline.Sections(), tag))
+
+	playlist, err := os.ReadFile(filepath.Join(dir, playlistFile))
+	assert.NoError(t, err)
+	assert.Equal(t, "#EXTM3U\n#EXTINF:1,Introduction\n001-Introduction.mp3\n#EXTINF:2,One\n002-One.mp3\n#EXTINF:1,Two / Last\n003-Two _ Last.mp3\n", string(playlist))
+
+	second, err := os.ReadFile(filepath.Join(dir, "002-One.mp3"))
+	assert.NoError(t, err)
+	_, frames := readID3Frames(t, second)
+	assert.Equal(t, "\x03One", string(frames["TIT2"]))
+	assert.Equal(t, "\x03Book", string(frames["TALB"]))
+	assert.Equal(t, "\x032/3", string(frames["TRCK"]))
+	length, err := audioFormat{}.duration(second)
+	assert.NoError(t, err)
+	assert.Equal(t, timeline.Sections()[1].End-timeline.Sections()[1].Start, length)
+}
+
+func TestWriteOutputDirWAV(t *testing.T) {
+	var srv = newFakeAWS(t)
+	srv.Script(fakeaws.Task{Duration: time.Second}, fakeaws.Task{Duration: 2 * time.Second})
+
+	var text = "# One\nFirst chapter.\n# Two\nSecond chapter."
+	var chapters = detectChapters(text, nil)
+	var dir = t.TempDir()
+	var timeline = &Timeline{}
+	var synth = synthesisOpts{bucket: "bucket", voiceID: "Matthew", format: audioFormat{output: types.OutputFormatPcm}}
+	var err = handleOutput(context.Background(), srv.PollyClient(), srv.S3Client(), make(chan *s3.GetObjectOutput, 5), make(chan string, 100), synth, document{text: text, chapters: chapters, sectionPerChapter: true}, outputDirSourceFile(dir), timeline)
+	assert.NoError(t, err)
+	assert.NoError(t, writeOutputDir(outputDirSourceFile(dir), dir, "{index}.{ext}", synth.format, chapterMarkers(chapters, timeline), timeline.Sections(), id3Tag{}))
+
+	wav, err := os.ReadFile(filepath.Join(dir, "2.wav"))
+	assert.NoError(t, err)
+	assert.Equal(t, "RIFF", string(wav[:4]))
+	assert.Equal(t, uint32(len(wav)-44), binary.LittleEndian.Uint32(wav[40:44]))
+	assert.Equal(t, 2*defaultPCMSampleRate*2, len(wav)-44)
+}
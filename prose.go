@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+var errUnknownCodePolicy = errors.New("unknown code policy")
+
+// codePolicy is what is said for code blocks and tables, which do not read well.
+type codePolicy string
+
+const (
+	codeSkip      codePolicy = "skip"      // leave them out
+	codeSummarize codePolicy = "summarize" // say that there is a code block or table and how big it is
+	codeRead      codePolicy = "read"      // read code line by line and tables row by row
+)
+
+// parseCodePolicy parses the -code flag.
+func parseCodePolicy(policy string) (codePolicy, error) {
+	switch p := codePolicy(strings.ToLower(strings.TrimSpace(policy))); p {
+	case codeSkip, codeSummarize, codeRead:
+		return p, nil
+	case "":
+		return codeSummarize, nil
+	default:
+		return "", fmt.Errorf("%w: %s, must be one of %v", errUnknownCodePolicy, policy, []codePolicy{codeSkip, codeSummarize, codeRead})
+	}
+}
+
+// proseWriter builds a document out of the blocks of a structured input (markdown, html) so that
+// it reads naturally: blocks are separated by a blank line, headings and list items are sentences
+// of their own, and code and tables follow the policy.
+type proseWriter struct {
+	policy codePolicy
+	out    strings.Builder
+	sep    string // written before the next text, a blank line between blocks and a line break between list items
+	doc    document
+}
+
+// write appends text to the output after the pending separator and returns where it starts.
+func (w *proseWriter) write(text string) int {
+	if w.out.Len() > 0 {
+		w.out.WriteString(w.sep)
+	}
+	w.sep = "\n\n"
+	var start = w.out.Len()
+	w.out.WriteString(text)
+	return start
+}
+
+// document returns what has been written.
+func (w *proseWriter) document() document {
+	w.doc.text = w.out.String()
+	return w.doc
+}
+
+// paragraph writes a block of text.
+func (w *proseWriter) paragraph(text string) {
+	if text != "" {
+		w.write(text)
+	}
+}
+
+// item writes a list item, consecutive items are on consecutive lines.
+func (w *proseWriter) item(text string) {
+	if text != "" {
+		w.write(sentence(text))
+		w.sep = "\n"
+	}
+}
+
+// endList separates what follows a list from its last item.
+func (w *proseWriter) endList() {
+	w.sep = "\n\n"
+}
+
+// heading writes a heading as its own sentence, the first and second levels start chapters and the
+// first top level heading is the title.
+func (w *proseWriter) heading(title string, level int) {
+	if title == "" {
+		return
+	}
+	w.sep = "\n\n"
+	var start = w.write(sentence(title))
+	w.doc.headings = append(w.doc.headings, heading{start: start, end: w.out.Len(), level: level})
+	if level <= 2 {
+		w.doc.chapters = append(w.doc.chapters, Chapter{Title: title, Start: start})
+	}
+	if level == 1 && w.doc.title == "" {
+		w.doc.title = title
+	}
+}
+
+// code reads, summarizes or skips a code block.
+func (w *proseWriter) code(language, code string) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return
+	}
+	switch w.policy {
+	case codeSummarize:
+		var what = "Code sample"
+		if language != "" {
+			what = "Code sample in " + language
+		}
+		w.write(fmt.Sprintf("%s, %s.", what, plural(strings.Count(code, "\n")+1, "line")))
+	case codeRead:
+		w.write(code)
+	case codeSkip:
+	}
+}
+
+// table reads, summarizes or skips a table. Read tables are one sentence per row pairing each
+// cell with its column header.
+func (w *proseWriter) table(headers []string, rows [][]string) {
+	switch w.policy {
+	case codeSummarize:
+		if len(headers) == 0 {
+			w.write(fmt.Sprintf("Table with %s.", plural(len(rows), "row")))
+			return
+		}
+		w.write(fmt.Sprintf("Table of %s with %s.", joinWords(headers), plural(len(rows), "row")))
+	case codeRead:
+		for _, row := range rows {
+			var pairs []string
+			for i, cell := range row {
+				if i < len(headers) && headers[i] != "" {
+					cell = headers[i] + ": " + cell
+				}
+				pairs = append(pairs, cell)
+			}
+			w.item(strings.Join(pairs, ", "))
+		}
+		w.endList()
+	case codeSkip:
+	}
+}
+
+// sentence ends text with a full stop unless it already ends in punctuation, so polly pauses after it.
+func sentence(text string) string {
+	var last, _ = utf8.DecodeLastRuneInString(text)
+	if strings.ContainsRune(".!?:;", last) {
+		return text
+	}
+	return text + "."
+}
+
+// plural formats a count with the noun pluralized by adding an s.
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// joinWords joins words as a spoken list: "a, b and c".
+func joinWords(words []string) string {
+	if len(words) <= 1 {
+		return strings.Join(words, "")
+	}
+	return strings.Join(words[:len(words)-1], ", ") + " and " + words[len(words)-1]
+}
+
+// collapseSpace replaces every run of whitespace with a single space.
+func collapseSpace(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
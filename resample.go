@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// resampler converts 16 bit pcm from one sample rate and channel count to another as it is read.
+// Samples are linearly interpolated which is plenty for speech.
+type resampler struct {
+	r        *bufio.Reader
+	from, to pcmFormat
+	step     float64 // input frames advanced per output frame
+	pos      float64 // position of the next output frame between a (0) and b (1)
+	a, b     []int16 // the input frames either side of pos, already converted to to.channels
+	in       []byte  // one input frame
+	started  bool
+	eof      bool
+}
+
+// newResampler returns pcm converted to the to format, or pcm itself when the formats already match.
+func newResampler(pcm io.Reader, from, to pcmFormat) io.Reader {
+	if from == to {
+		return pcm
+	}
+	return &resampler{
+		r:    bufio.NewReader(pcm),
+		from: from,
+		to:   to,
+		step: float64(from.sampleRate) / float64(to.sampleRate),
+		a:    make([]int16, to.channels),
+		b:    make([]int16, to.channels),
+		in:   make([]byte, from.channels*2),
+	}
+}
+
+func (rs *resampler) Read(p []byte) (int, error) {
+	if !rs.started {
+		rs.started = true
+		if err := rs.next(rs.a); err != nil {
+			return 0, err
+		}
+		if err := rs.next(rs.b); errors.Is(err, io.EOF) {
+			copy(rs.b, rs.a)
+			rs.eof = true
+		} else if err != nil {
+			return 0, err
+		}
+	}
+
+	var frameSize = rs.to.channels * 2
+	var n int
+	for n+frameSize <= len(p) {
+		for rs.pos >= 1 {
+			if rs.eof {
+				return rs.done(n)
+			}
+			copy(rs.a, rs.b)
+			if err := rs.next(rs.b); errors.Is(err, io.EOF) {
+				rs.eof = true
+				copy(rs.b, rs.a)
+			} else if err != nil {
+				return n, err
+			}
+			rs.pos--
+		}
+		if rs.eof && rs.pos > 0 {
+			return rs.done(n)
+		}
+		for c := range rs.to.channels {
+			var sample = float64(rs.a[c]) + (float64(rs.b[c])-float64(rs.a[c]))*rs.pos
+			binary.LittleEndian.PutUint16(p[n+c*2:], uint16(int16(sample)))
+		}
+		n += frameSize
+		rs.pos += rs.step
+	}
+	return n, nil
+}
+
+// done returns the bytes produced so far, reporting EOF once there are none.
+func (rs *resampler) done(n int) (int, error) {
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// next reads one input frame into frame, mixing or duplicating channels to match the output.
+func (rs *resampler) next(frame []int16) error {
+	if _, err := io.ReadFull(rs.r, rs.in); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return io.EOF
+		}
+		return err
+	}
+	var sum int
+	for c := range rs.from.channels {
+		sum += int(int16(binary.LittleEndian.Uint16(rs.in[c*2:])))
+	}
+	for c := range frame {
+		switch {
+		case len(frame) == 1:
+			frame[c] = int16(sum / rs.from.channels)
+		case rs.from.channels == 1:
+			frame[c] = int16(binary.LittleEndian.Uint16(rs.in))
+		default:
+			frame[c] = int16(binary.LittleEndian.Uint16(rs.in[(c%rs.from.channels)*2:]))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func pcmBytes(samples ...int16) []byte {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.LittleEndian, samples)
+	return buf.Bytes()
+}
+
+func readSamples(t *testing.T, r io.Reader) []int16 {
+	t.Helper()
+	b, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	var samples = make([]int16, len(b)/2)
+	assert.NoError(t, binary.Read(bytes.NewReader(b), binary.LittleEndian, samples))
+	return samples
+}
+
+func TestResampler(t *testing.T) {
+	t.Parallel()
+
+	var mono8k = pcmFormat{sampleRate: 8000, channels: 1}
+	var mono16k = pcmFormat{sampleRate: 16000, channels: 1}
+	var stereo8k = pcmFormat{sampleRate: 8000, channels: 2}
+
+	// matching formats are passed through untouched
+	var in = bytes.NewReader(pcmBytes(1, 2, 3))
+	assert.Same(t, in, newResampler(in, mono8k, mono8k))
+
+	// upsampling interpolates between neighbouring samples
+	assert.Equal(t, []int16{0, 50, 100, 150, 200}, readSamples(t, newResampler(bytes.NewReader(pcmBytes(0, 100, 200)), mono8k, mono16k)))
+
+	// downsampling drops samples
+	assert.Equal(t, []int16{0, 200, 400}, readSamples(t, newResampler(bytes.NewReader(pcmBytes(0, 100, 200, 300, 400, 500)), mono16k, mono8k)))
+
+	// channels are duplicated and mixed
+	assert.Equal(t, []int16{7, 7, -3, -3}, readSamples(t, newResampler(bytes.NewReader(pcmBytes(7, -3)), mono8k, stereo8k)))
+	assert.Equal(t, []int16{5, -2}, readSamples(t, newResampler(bytes.NewReader(pcmBytes(4, 6, -4, 0)), stereo8k, mono8k)))
+
+	// a long stream keeps the expected length
+	var long = make([]byte, 22050*2*3)
+	var out = readSamples(t, newResampler(bytes.NewReader(long), pcmFormat{sampleRate: 22050, channels: 1}, pcmFormat{sampleRate: 24000, channels: 2}))
+	assert.InDelta(t, 24000*2*3, len(out), 4)
+}
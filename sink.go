@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/ebitengine/oto/v3"
+)
+
+var errUnknownSink = errors.New("unknown audio sink")
+
+// pcmFormat describes signed 16 bit little endian interleaved PCM.
+type pcmFormat struct {
+	sampleRate int
+	channels   int
+}
+
+func (f pcmFormat) bytesPerSecond() int {
+	return f.sampleRate * f.channels * 2
+}
+
+// AudioSink is where decoded audio ends up. Play blocks until pcm is exhausted, it must stop
+// consuming pcm while paused is true so playback progress (which is derived from how much pcm has
+// been read) freezes with it. Sections are played one after another on the same sink, sinks that
+// are opened with the format of the first section resample any later section that differs.
+type AudioSink interface {
+	Play(pcm io.Reader, format pcmFormat, paused *atomic.Bool) error
+	Close() error
+}
+
+// sinkOpts are the settings used to build an AudioSink.
+type sinkOpts struct {
+	name  string  // oto, null or wav
+	speed float64 // playback rate of the null and wav sinks relative to real-time, 0 is as fast as possible
+	file  string  // path the wav sink writes to
+}
+
+// registerFlags adds the sink flags to fs for the commands that play audio.
+func (o *sinkOpts) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.name, "sink", "oto", "where to play the audio: oto (sound card), null (discard, for headless machines) or wav (record to -sink-file)")
+	fs.Float64Var(&o.speed, "sink-speed", 1, "playback rate of the null and wav sinks relative to real-time, 0 is as fast as possible")
+	fs.StringVar(&o.file, "sink-file", "playback.wav", "path the wav sink writes to")
+}
+
+// newAudioSink returns the sink named by opts. Nothing is opened until the first Play so
+// creating a sink that is never used (e.g. when writing -output) has no side effects.
+func newAudioSink(opts sinkOpts) (AudioSink, error) {
+	switch opts.name {
+	case "", "oto":
+		return &otoSink{}, nil
+	case "null":
+		return &nullSink{speed: opts.speed}, nil
+	case "wav":
+		return &wavSink{path: opts.file, speed: opts.speed}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnknownSink, opts.name)
+	}
+}
+
+// otoSink plays through the system audio device. oto only allows one context per process so it
+// is created on the first Play, with that section's sample rate and channel count, and reused for
+// every section after that.
+type otoSink struct {
+	ctx    *oto.Context
+	format pcmFormat
+}
+
+func (s *otoSink) Play(pcm io.Reader, format pcmFormat, paused *atomic.Bool) error {
+	if s.ctx == nil {
+		var options = &oto.NewContextOptions{
+			SampleRate:   format.sampleRate,
+			ChannelCount: format.channels,
+			Format:       oto.FormatSignedInt16LE,
+		}
+		otoCtx, ready, err := oto.NewContext(options)
+		if err != nil {
+			return fmt.Errorf("oto.NewContext: %w", err)
+		}
+		<-ready
+		s.ctx = otoCtx
+		s.format = format
+	}
+
+	var player = s.ctx.NewPlayer(newResampler(pcm, format, s.format))
+	defer player.Close()
+	player.Play()
+	var isPaused bool
+	for {
+		if p := paused.Load(); p != isPaused {
+			isPaused = p
+			if isPaused {
+				player.Pause()
+			} else {
+				player.Play()
+			}
+		}
+		if !isPaused && !player.IsPlaying() {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := player.Err(); err != nil {
+		return fmt.Errorf("oto player: %w", err)
+	}
+	return nil
+}
+
+func (s *otoSink) Close() error {
+	return nil
+}
+
+// nullSink discards the audio, at real-time or an accelerated rate. It allows playback to run
+// on machines without a sound card.
+type nullSink struct {
+	speed float64
+}
+
+func (s *nullSink) Play(pcm io.Reader, format pcmFormat, paused *atomic.Bool) error {
+	if _, err := io.Copy(io.Discard, newPacedReader(pcm, format, s.speed, paused)); err != nil {
+		return fmt.Errorf("null sink: %w", err)
+	}
+	return nil
+}
+
+func (s *nullSink) Close() error {
+	return nil
+}
+
+// wavSink records every section into a single wav file.
+type wavSink struct {
+	path   string
+	speed  float64
+	file   *os.File
+	format pcmFormat
+	size   int64
+}
+
+func (s *wavSink) Play(pcm io.Reader, format pcmFormat, paused *atomic.Bool) error {
+	if s.file == nil {
+		//nolint:gosec
+		file, err := os.Create(s.path)
+		if err != nil {
+			return fmt.Errorf("wav sink: %w", err)
+		}
+		s.file = file
+		s.format = format
+		// the sizes are unknown until Close, write a placeholder header now
+		if err := writeWAVHeader(s.file, format, 0); err != nil {
+			return fmt.Errorf("wav sink: %w", err)
+		}
+	}
+
+	n, err := io.Copy(s.file, newResampler(newPacedReader(pcm, format, s.speed, paused), format, s.format))
+	s.size += n
+	if err != nil {
+		return fmt.Errorf("wav sink: %w", err)
+	}
+	return nil
+}
+
+func (s *wavSink) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("wav sink: %w", err)
+	}
+	if err := writeWAVHeader(s.file, s.format, s.size); err != nil {
+		return fmt.Errorf("wav sink: %w", err)
+	}
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("wav sink: %w", err)
+	}
+	return nil
+}
+
+// pacedReader hands out pcm no faster than speed times real-time and not at all while paused.
+type pacedReader struct {
+	r      io.Reader
+	paused *atomic.Bool
+	chunk  int           // bytes per read, 10ms of audio
+	delay  time.Duration // how long each chunk takes to play
+}
+
+func newPacedReader(r io.Reader, format pcmFormat, speed float64, paused *atomic.Bool) *pacedReader {
+	const chunkDuration = 10 * time.Millisecond
+	var frameSize = format.channels * 2
+	var p = &pacedReader{
+		r:      r,
+		paused: paused,
+		chunk:  max(format.bytesPerSecond()/100/frameSize*frameSize, frameSize),
+	}
+	if speed > 0 {
+		p.delay = time.Duration(float64(chunkDuration) / speed)
+	}
+	return p
+}
+
+func (p *pacedReader) Read(b []byte) (int, error) {
+	for p.paused.Load() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(p.delay)
+	return p.r.Read(b[:min(len(b), p.chunk)])
+}
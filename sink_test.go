@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/kmulvey/text2speech/internal/fakeaws"
+	"github.com/stretchr/testify/assert"
+)
+
+// queueAudio returns a closed audio channel holding silent mp3s of the given lengths.
+func queueAudio(lengths ...time.Duration) chan *s3.GetObjectOutput {
+	var audioChan = make(chan *s3.GetObjectOutput, len(lengths))
+	for _, l := range lengths {
+		audioChan <- &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(fakeaws.SilentMP3(l, 0)))}
+	}
+	close(audioChan)
+	return audioChan
+}
+
+func TestPlayWithProgressBar(t *testing.T) {
+	t.Parallel()
+
+	var playbackProgress = make(chan PlaybackProgress)
+	var errors = make(chan error, 1)
+	go playWithProgressBar(&nullSink{speed: 20}, audioFormat{}, queueAudio(3*time.Second, 2*time.Second), playbackProgress, errors, make(chan bool), nil)
+
+	var updates []PlaybackProgress
+	for p := range playbackProgress {
+		updates = append(updates, p)
+	}
+	assert.NoError(t, <-errors)
+
+	assert.NotEmpty(t, updates)
+	for i := 1; i < len(updates); i++ {
+		assert.GreaterOrEqual(t, updates[i].GrandElapsed, updates[i-1].GrandElapsed)
+	}
+	for i := 1; i < len(updates); i++ {
+		assert.GreaterOrEqual(t, updates[i].Position, updates[i-1].Position)
+	}
+	var last = updates[len(updates)-1]
+	// the last update is sent once the sink has consumed all of the audio
+	var total time.Duration
+	for _, d := range []time.Duration{3 * time.Second, 2 * time.Second} {
+		length, err := audioFormat{}.duration(fakeaws.SilentMP3(d, 0))
+		assert.NoError(t, err)
+		total += length
+	}
+	assert.Equal(t, total, last.Position)
+	last.Position = 0
+	assert.Equal(t, PlaybackProgress{Current: 1, Total: 2, GrandElapsed: 4, GrandTotal: 5, Section: 1}, last)
+}
+
+// countingSink counts the pcm its sink has read as it reads it.
+type countingSink struct {
+	AudioSink
+	pcm atomic.Pointer[countingReader] // the section being played
+}
+
+func (s *countingSink) Play(pcm io.Reader, format pcmFormat, paused *atomic.Bool) error {
+	var counted = &countingReader{r: pcm}
+	s.pcm.Store(counted)
+	return s.AudioSink.Play(counted, format, paused)
+}
+
+// read is how much pcm of the section being played has been read so far.
+func (s *countingSink) read() int64 {
+	if counted := s.pcm.Load(); counted != nil {
+		return counted.n.Load()
+	}
+	return 0
+}
+
+func TestPlayWithProgressBarPause(t *testing.T) {
+	t.Parallel()
+
+	var playbackProgress = make(chan PlaybackProgress)
+	var errors = make(chan error, 1)
+	var pauseChan = make(chan bool)
+	var audioChan = make(chan *s3.GetObjectOutput, 1)
+	var sink = &countingSink{AudioSink: &nullSink{}}
+	go playWithProgressBar(sink, audioFormat{}, audioChan, playbackProgress, errors, pauseChan, nil)
+
+	// the second send is only received once the first pause has been stored, so the section starts paused
+	pauseChan <- true
+	pauseChan <- true
+	audioChan <- &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(fakeaws.SilentMP3(3*time.Second, 0)))}
+	close(audioChan)
+
+	// the section has started but nothing is played while paused, even by a sink without pacing
+	var paused = <-playbackProgress
+	assert.Equal(t, PlaybackProgress{Total: 3, GrandTotal: 3}, paused)
+	// a sink without pacing would read all of it in this time
+	time.Sleep(100 * time.Millisecond)
+	assert.Zero(t, sink.read())
+
+	pauseChan <- false
+	assert.Eventually(t, func() bool { return sink.read() > 0 }, time.Second, 5*time.Millisecond)
+	var last PlaybackProgress
+	for p := range playbackProgress {
+		last = p
+	}
+	assert.NoError(t, <-errors)
+	assert.Equal(t, 2, last.GrandElapsed)
+}
+
+func TestPlayWithProgressBarSeek(t *testing.T) {
+	t.Parallel()
+
+	length, err := audioFormat{}.duration(fakeaws.SilentMP3(time.Second, 0))
+	assert.NoError(t, err)
+
+	var playbackProgress = make(chan PlaybackProgress)
+	var errors = make(chan error, 1)
+	var seekChan = make(chan time.Duration, 1)
+	go playWithProgressBar(&nullSink{speed: 2}, audioFormat{}, queueAudio(time.Second, time.Second, time.Second), playbackProgress, errors, make(chan bool), seekChan)
+
+	// jump forward to half way into the third section while the first plays, then back to the start
+	var played []int
+	var seeks = []time.Duration{2*length + length/2, 0}
+	var thirdSection time.Duration
+	for p := range playbackProgress {
+		if len(played) == 0 || played[len(played)-1] != p.Section {
+			played = append(played, p.Section)
+			if p.Section == 2 && thirdSection == 0 {
+				thirdSection = p.Position
+			}
+			if len(seeks) > 0 {
+				seekChan <- seeks[0]
+				seeks = seeks[1:]
+			}
+		}
+	}
+	assert.NoError(t, <-errors)
+	assert.Equal(t, []int{0, 2, 0, 1, 2}, played)
+
+	// positions stay on the timeline of the whole run, a seek lands on the frame at or before it
+	assert.InDelta(t, 2*length+length/2, thirdSection, float64(time.Millisecond))
+	assert.LessOrEqual(t, thirdSection, 2*length+length/2)
+}
+
+func TestWAVSink(t *testing.T) {
+	t.Parallel()
+
+	var path = filepath.Join(t.TempDir(), "playback.wav")
+	sink, err := newAudioSink(sinkOpts{name: "wav", file: path})
+	assert.NoError(t, err)
+
+	var playbackProgress = make(chan PlaybackProgress)
+	var errors = make(chan error, 1)
+	// the second section is at a different rate and gets resampled to the first
+	var audioChan = make(chan *s3.GetObjectOutput, 2)
+	audioChan <- &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(fakeaws.SilentMP3(time.Second, 22050)))}
+	audioChan <- &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(fakeaws.SilentMP3(time.Second, 16000)))}
+	close(audioChan)
+	go playWithProgressBar(sink, audioFormat{}, audioChan, playbackProgress, errors, make(chan bool), nil)
+	for range playbackProgress {
+	}
+	assert.NoError(t, <-errors)
+	assert.NoError(t, sink.Close())
+
+	wav, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("RIFF"), wav[:4])
+	assert.Equal(t, []byte("WAVE"), wav[8:12])
+	// polly's mono output is played as mono
+	assert.Equal(t, uint16(1), binary.LittleEndian.Uint16(wav[22:24]))
+	assert.Equal(t, uint32(22050), binary.LittleEndian.Uint32(wav[24:28]))
+	assert.Equal(t, uint32(len(wav)-44), binary.LittleEndian.Uint32(wav[40:44]))
+	assert.InDelta(t, 2.0, float64(len(wav)-44)/float64(22050*2), 0.1)
+}
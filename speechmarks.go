@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/polly"
+	"github.com/aws/aws-sdk-go-v2/service/polly/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+var errUnknownSpeechMark = errors.New("unknown speech mark type")
+
+// SpeechMark is one polly speech mark placed on the timeline of the whole run.
+type SpeechMark struct {
+	Time    time.Duration        // from the start of the first section
+	Type    types.SpeechMarkType // sentence, word, viseme or ssml
+	Start   int                  // byte offset of the marked text in the full input text
+	End     int                  // byte offset just past the marked text
+	Value   string               // the marked text, or the viseme / ssml mark name
+	Section int                  // index of the section the mark came from
+}
+
+// Timeline holds the speech marks of every section synthesized so far in playback order. It is
+// filled in by handleOutput while the rest of the program (captions, highlighting, seeking) reads
+// from it, so it is safe for concurrent use. A nil *Timeline is empty.
+type Timeline struct {
+	mu       sync.RWMutex
+	marks    []SpeechMark
+	sections []TimelineSection
+	end      time.Duration
+}
+
+// TimelineSection is where the audio of a section sits on the timeline.
+type TimelineSection struct {
+	Start, End         time.Duration
+	Size               int64 // bytes of encoded audio
+	TextStart, TextEnd int   // byte range of the section's text in the full input text
+}
+
+// addSection appends the marks of the next section, end is when that section's audio finishes,
+// size is how many bytes of audio polly returned for it and textStart, textEnd are where its text is.
+func (t *Timeline) addSection(marks []SpeechMark, end time.Duration, size int64, textStart, textEnd int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.marks = append(t.marks, marks...)
+	t.sections = append(t.sections, TimelineSection{Start: t.end, End: end, Size: size, TextStart: textStart, TextEnd: textEnd})
+	t.end = end
+}
+
+// Sections returns where each section added so far starts and ends.
+func (t *Timeline) Sections() []TimelineSection {
+	if t == nil {
+		return nil
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return slices.Clone(t.sections)
+}
+
+// Duration is the length of the audio of every section added so far.
+func (t *Timeline) Duration() time.Duration {
+	if t == nil {
+		return 0
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.end
+}
+
+// Marks returns a copy of the marks of the given type, or all marks when markType is empty.
+func (t *Timeline) Marks(markType types.SpeechMarkType) []SpeechMark {
+	if t == nil {
+		return nil
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var marks = make([]SpeechMark, 0, len(t.marks))
+	for _, m := range t.marks {
+		if markType == "" || m.Type == markType {
+			marks = append(marks, m)
+		}
+	}
+	return marks
+}
+
+// At returns the last mark of markType at or before d, which is what is being spoken at d.
+func (t *Timeline) At(d time.Duration, markType types.SpeechMarkType) (SpeechMark, bool) {
+	if t == nil {
+		return SpeechMark{}, false
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var found SpeechMark
+	var ok bool
+	for _, m := range t.marks {
+		if m.Time > d {
+			break
+		}
+		if m.Type == markType {
+			found, ok = m, true
+		}
+	}
+	return found, ok
+}
+
+// TextTime returns when the text at offset is spoken: at the first sentence or word mark from there
+// on in its section or, without marks, as far into the section's audio as offset is into its text.
+// It is false until the section has been added.
+func (t *Timeline) TextTime(offset int) (time.Duration, bool) {
+	if t == nil {
+		return 0, false
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for i, s := range t.sections {
+		if offset >= s.TextEnd {
+			continue
+		}
+		for _, m := range t.marks {
+			if m.Section == i && m.Start >= offset && (m.Type == types.SpeechMarkTypeSentence || m.Type == types.SpeechMarkTypeWord) {
+				return m.Time, true
+			}
+		}
+		if offset <= s.TextStart {
+			return s.Start, true
+		}
+		var fraction = float64(offset-s.TextStart) / float64(s.TextEnd-s.TextStart)
+		return s.Start + time.Duration(fraction*float64(s.End-s.Start)), true
+	}
+	return 0, false
+}
+
+// parseSpeechMarkTypes parses the comma separated -speech-marks flag.
+func parseSpeechMarkTypes(list string) ([]types.SpeechMarkType, error) {
+	var markTypes []types.SpeechMarkType
+	for _, name := range strings.Split(list, ",") {
+		var markType = types.SpeechMarkType(strings.ToLower(strings.TrimSpace(name)))
+		if markType == "" {
+			continue
+		}
+		if !slices.Contains(markType.Values(), markType) {
+			return nil, fmt.Errorf("%w: %s, must be one of %v", errUnknownSpeechMark, name, markType.Values())
+		}
+		if !slices.Contains(markTypes, markType) {
+			markTypes = append(markTypes, markType)
+		}
+	}
+	return markTypes, nil
+}
+
+type speechMarksResult struct {
+	marks []SpeechMark
+	key   string
+	err   error
+}
+
+// startSpeechMarks runs synthesizeSpeechMarks in the background, the result is always delivered.
+func startSpeechMarks(ctx context.Context, pollyClient *polly.Client, s3Client *s3.Client, logs chan string, synth synthesisOpts, text string) <-chan speechMarksResult {
+	var result = make(chan speechMarksResult, 1)
+	go func() {
+		marks, key, err := synthesizeSpeechMarks(ctx, pollyClient, s3Client, logs, synth, text)
+		result <- speechMarksResult{marks: marks, key: key, err: err}
+	}()
+	return result
+}
+
+// synthesizeSpeechMarks runs a speech marks task for the text and returns the marks relative to
+// the start of the text along with the key of the marks file polly wrote.
+func synthesizeSpeechMarks(ctx context.Context, pollyClient *polly.Client, s3Client *s3.Client, logs chan string, synth synthesisOpts, text string) ([]SpeechMark, string, error) {
+	inputTask := &polly.StartSpeechSynthesisTaskInput{OutputFormat: types.OutputFormatJson, SpeechMarkTypes: synth.marks, OutputS3BucketName: aws.String(synth.bucket), Text: aws.String(text), VoiceId: types.VoiceId(synth.voiceID)}
+	if synth.keyPrefix != "" {
+		inputTask.OutputS3KeyPrefix = aws.String(synth.keyPrefix)
+	}
+	if synth.ssml {
+		inputTask.TextType = types.TextTypeSsml
+	}
+	if len(synth.lexicons) > 0 {
+		inputTask.LexiconNames = synth.lexicons
+	}
+	if synth.languageCode != "" {
+		inputTask.LanguageCode = synth.languageCode
+	}
+	if synth.engine != "" {
+		inputTask.Engine = synth.engine
+	}
+	output, key, err := runSynthesisTask(ctx, pollyClient, s3Client, logs, synth, inputTask)
+	if err != nil {
+		return nil, "", fmt.Errorf("speech marks: %w", err)
+	}
+	defer output.Body.Close()
+
+	marks, err := parseSpeechMarks(output.Body)
+	if err != nil {
+		return nil, key, err
+	}
+	return marks, key, nil
+}
+
+// parseSpeechMarks reads polly's json lines speech marks, e.g.
+// {"time":370,"type":"word","start":5,"end":8,"value":"had"}
+func parseSpeechMarks(r io.Reader) ([]SpeechMark, error) {
+	var marks []SpeechMark
+	var scanner = bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var line = bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var mark struct {
+			Time  int64  `json:"time"`
+			Type  string `json:"type"`
+			Start int    `json:"start"`
+			End   int    `json:"end"`
+			Value string `json:"value"`
+		}
+		if err := json.Unmarshal(line, &mark); err != nil {
+			return nil, fmt.Errorf("parse speech mark %q: %w", line, err)
+		}
+		marks = append(marks, SpeechMark{
+			Time:  time.Duration(mark.Time) * time.Millisecond,
+			Type:  types.SpeechMarkType(mark.Type),
+			Start: mark.Start,
+			End:   mark.End,
+			Value: mark.Value,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read speech marks: %w", err)
+	}
+	return marks, nil
+}
+
+// offsetSpeechMarks moves section relative marks onto the timeline of the whole run.
+func offsetSpeechMarks(marks []SpeechMark, section int, start time.Duration, textOffset int) []SpeechMark {
+	for i := range marks {
+		marks[i].Time += start
+		marks[i].Start += textOffset
+		marks[i].End += textOffset
+		marks[i].Section = section
+	}
+	return marks
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/polly/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/kmulvey/text2speech/internal/fakeaws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSpeechMarks(t *testing.T) {
+	t.Parallel()
+
+	marks, err := parseSpeechMarks(strings.NewReader(`{"time":6,"type":"sentence","start":0,"end":23,"value":"Mary had a little lamb."}
+{"time":6,"type":"word","start":0,"end":4,"value":"Mary"}
+
+{"time":373,"type":"word","start":5,"end":8,"value":"had"}
+{"time":373,"type":"viseme","value":"k"}
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, []SpeechMark{
+		{Time: 6 * time.Millisecond, Type: types.SpeechMarkTypeSentence, Start: 0, End: 23, Value: "Mary had a little lamb."},
+		{Time: 6 * time.Millisecond, Type: types.SpeechMarkTypeWord, Start: 0, End: 4, Value: "Mary"},
+		{Time: 373 * time.Millisecond, Type: types.SpeechMarkTypeWord, Start: 5, End: 8, Value: "had"},
+		{Time: 373 * time.Millisecond, Type: types.SpeechMarkTypeViseme, Value: "k"},
+	}, marks)
+
+	_, err = parseSpeechMarks(strings.NewReader("{not json"))
+	assert.Error(t, err)
+}
+
+func TestParseSpeechMarkTypes(t *testing.T) {
+	t.Parallel()
+
+	markTypes, err := parseSpeechMarkTypes(" Sentence,word,,word")
+	assert.NoError(t, err)
+	assert.Equal(t, []types.SpeechMarkType{types.SpeechMarkTypeSentence, types.SpeechMarkTypeWord}, markTypes)
+
+	markTypes, err = parseSpeechMarkTypes("")
+	assert.NoError(t, err)
+	assert.Empty(t, markTypes)
+
+	_, err = parseSpeechMarkTypes("sentence,paragraph")
+	assert.ErrorIs(t, err, errUnknownSpeechMark)
+}
+
+func TestHandleOutputSpeechMarks(t *testing.T) {
+	var srv = newFakeAWS(t)
+	srv.Script(fakeaws.Task{Duration: 3 * time.Second}, fakeaws.Task{Duration: 2 * time.Second})
+
+	var text = "  " + longText(2)
+	var timeline = &Timeline{}
+	var audioChan = make(chan *s3.GetObjectOutput, 5)
+	var logs = make(chan string, 100)
+	var synth = synthesisOpts{bucket: "bucket", voiceID: "Matthew", marks: []types.SpeechMarkType{types.SpeechMarkTypeSentence, types.SpeechMarkTypeWord}}
+	var err = handleOutput(context.Background(), srv.PollyClient(), srv.S3Client(), audioChan, logs, synth, document{text: text}, "output.mp3", timeline)
+	assert.NoError(t, err)
+	assert.Len(t, audioChan, 2)
+
+	var words = timeline.Marks(types.SpeechMarkTypeWord)
+	assert.Len(t, words, len(strings.Fields(text)))
+	var sentences = timeline.Marks(types.SpeechMarkTypeSentence)
+	assert.Len(t, sentences, strings.Count(text, "."))
+
+	// every mark points into the full text
+	for _, m := range timeline.Marks("") {
+		assert.True(t, strings.HasPrefix(text[m.Start:m.End], m.Value), "%+v", m)
+	}
+
+	// the second section's marks start when the first section's audio ends
+	firstLength, err := audioFormat{}.duration(fakeaws.SilentMP3(3*time.Second, 0))
+	assert.NoError(t, err)
+	var first = splitInput(text, nil)[0]
+	var secondStart = words[len(strings.Fields(first))]
+	assert.Equal(t, 1, secondStart.Section)
+	assert.Equal(t, firstLength, secondStart.Time)
+
+	assert.Equal(t, len(text)-len(splitInput(text, nil)[1]), secondStart.Start)
+
+	mark, ok := timeline.At(firstLength+time.Millisecond, types.SpeechMarkTypeWord)
+	assert.True(t, ok)
+	assert.Equal(t, secondStart, mark)
+	mark, ok = timeline.At(firstLength-time.Millisecond, types.SpeechMarkTypeSentence)
+	assert.True(t, ok)
+	assert.Equal(t, 0, mark.Section)
+
+	// audio and marks are both cleaned up
+	assert.Len(t, srv.Deleted(), 4)
+	assert.Empty(t, srv.Objects())
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// headingBreaks is the pause after a heading by level, deeper levels use the last one.
+var headingBreaks = []time.Duration{time.Second, 750 * time.Millisecond, 500 * time.Millisecond}
+
+// toSSML escapes a section of text for polly's ssml input, wrapping its headings (relative to the
+// section) in strong emphasis followed by a pause. It also returns, for every byte of the ssml, the
+// offset in text it came from so speech marks can be moved back onto the text.
+func toSSML(text string, headings []heading) (string, []int) {
+	var b strings.Builder
+	var offsets []int
+	var write = func(s string, offset int) {
+		b.WriteString(s)
+		for range len(s) {
+			offsets = append(offsets, offset)
+		}
+	}
+
+	write("<speak>", 0)
+	var next int
+	for i := 0; i < len(text); i++ {
+		for next < len(headings) && headings[next].end <= i {
+			next++
+		}
+		if next < len(headings) && headings[next].start == i {
+			write(`<emphasis level="strong">`, i)
+		}
+		switch c := text[i]; c {
+		case '&':
+			write("&amp;", i)
+		case '<':
+			write("&lt;", i)
+		case '>':
+			write("&gt;", i)
+		case '"':
+			write("&quot;", i)
+		case '\'':
+			write("&apos;", i)
+		default:
+			b.WriteByte(c)
+			offsets = append(offsets, i)
+		}
+		if next < len(headings) && headings[next].end == i+1 {
+			var pause = headingBreaks[min(headings[next].level, len(headingBreaks))-1]
+			write(`</emphasis><break time="`+pause.String()+`"/>`, i+1)
+			next++
+		}
+	}
+	write("</speak>", len(text))
+	// the end of the last mark can point just past the ssml
+	offsets = append(offsets, len(text))
+	return b.String(), offsets
+}
+
+// sectionHeadings returns the headings within section, which starts at offset in the document,
+// relative to the start of the section.
+func sectionHeadings(headings []heading, offset int, section string) []heading {
+	var result []heading
+	for _, h := range headings {
+		var start, end = max(h.start-offset, 0), min(h.end-offset, len(section))
+		if start < end {
+			result = append(result, heading{start: start, end: end, level: h.level})
+		}
+	}
+	return result
+}
+
+// ssmlSpeechMarks moves the start and end of marks from the ssml onto the text it was built from.
+func ssmlSpeechMarks(marks []SpeechMark, offsets []int) []SpeechMark {
+	var at = func(i int) int {
+		return offsets[min(max(i, 0), len(offsets)-1)]
+	}
+	for i := range marks {
+		marks[i].Start, marks[i].End = at(marks[i].Start), at(marks[i].End)
+	}
+	return marks
+}
+
+// withLeadingBreak starts the content of the ssml from toSSML with a pause, keeping its offsets in step.
+func withLeadingBreak(ssml string, offsets []int, pause time.Duration) (string, []int) {
+	const open = "<speak>"
+	var tag = `<break time="` + pause.String() + `"/>`
+	return open + tag + ssml[len(open):], slices.Concat(offsets[:len(open)], slices.Repeat([]int{0}, len(tag)), offsets[len(open):])
+}
+
+// withProsodyRate wraps the content of the ssml from toSSML in a prosody rate, a percentage of
+// normal speed, keeping its offsets in step.
+func withProsodyRate(ssml string, offsets []int, rate int) (string, []int) {
+	const open, end = "<speak>", "</speak>"
+	var body = ssml[len(open) : len(ssml)-len(end)]
+	var tag = fmt.Sprintf(`<prosody rate="%d%%">`, rate)
+	var last = offsets[len(offsets)-1]
+
+	var result = make([]int, 0, len(offsets)+len(tag)+len("</prosody>"))
+	result = append(result, offsets[:len(open)]...)
+	result = append(result, slices.Repeat([]int{0}, len(tag))...)
+	result = append(result, offsets[len(open):len(ssml)-len(end)]...)
+	result = append(result, slices.Repeat([]int{last}, len("</prosody>"))...)
+	result = append(result, offsets[len(ssml)-len(end):]...)
+	return open + tag + body + "</prosody>" + end, result
+}
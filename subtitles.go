@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/polly/types"
+)
+
+const (
+	maxSubtitleLineChars = 42              // common broadcast guideline for a single caption line
+	maxSubtitleLines     = 2               // lines per cue
+	maxCueDuration       = 7 * time.Second // longer cues are split even if they would fit
+	maxCueLinger         = 2 * time.Second // how long a cue stays up after its last word when there is a pause
+)
+
+var (
+	errUnknownSubtitleFormat = errors.New("unknown subtitle format")
+	errSubtitlesNeedOutput   = errors.New("subtitles are written next to -output, set -output")
+)
+
+// subtitleFormats are the values accepted by -subtitles.
+var subtitleFormats = []string{"srt", "vtt"}
+
+// cue is one caption.
+type cue struct {
+	start, end time.Duration
+	text       string
+}
+
+// parseSubtitleFormats parses the comma separated -subtitles flag.
+func parseSubtitleFormats(list string) ([]string, error) {
+	var formats []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || slices.Contains(formats, name) {
+			continue
+		}
+		if !slices.Contains(subtitleFormats, name) {
+			return nil, fmt.Errorf("%w: %s, must be one of %v", errUnknownSubtitleFormat, name, subtitleFormats)
+		}
+		formats = append(formats, name)
+	}
+	return formats, nil
+}
+
+// writeSubtitleFiles writes a subtitle file in each format next to outputFile, e.g. book.mp3 -> book.srt.
+func writeSubtitleFiles(outputFile string, formats []string, timeline *Timeline, text string) error {
+	var cues = buildCues(timeline, text)
+	for _, format := range formats {
+		var path = strings.TrimSuffix(outputFile, filepath.Ext(outputFile)) + "." + format
+		if err := writeSubtitleFile(path, format, cues); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeSubtitleFile(path, format string, cues []cue) error {
+	//nolint:gosec
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating subtitles: %w", err)
+	}
+	defer file.Close()
+
+	var w = bufio.NewWriter(file)
+	switch format {
+	case "srt":
+		err = writeSRT(w, cues)
+	case "vtt":
+		err = writeVTT(w, cues)
+	default:
+		err = fmt.Errorf("%w: %s", errUnknownSubtitleFormat, format)
+	}
+	if err != nil {
+		return fmt.Errorf("error writing subtitles: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("error writing subtitles: %w", err)
+	}
+	return nil
+}
+
+func writeSRT(w io.Writer, cues []cue) error {
+	for i, c := range cues {
+		if _, err := fmt.Fprintf(w, "%d\n%s --> %s\n%s\n\n", i+1, subtitleTimestamp(c.start, ','), subtitleTimestamp(c.end, ','), c.text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeVTT(w io.Writer, cues []cue) error {
+	if _, err := io.WriteString(w, "WEBVTT\n\n"); err != nil {
+		return err
+	}
+	for _, c := range cues {
+		if _, err := fmt.Fprintf(w, "%s --> %s\n%s\n\n", subtitleTimestamp(c.start, '.'), subtitleTimestamp(c.end, '.'), c.text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subtitleTimestamp formats d as hh:mm:ss,mmm (srt) or hh:mm:ss.mmm (vtt).
+func subtitleTimestamp(d time.Duration, sep byte) string {
+	var ms = d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d%c%03d", ms/3_600_000, ms/60_000%60, ms/1000%60, sep, ms%1000)
+}
+
+// buildCues groups the word marks into cues of at most maxSubtitleLines lines of
+// maxSubtitleLineChars, preferring to break at the end of a sentence. Cues never span two sections,
+// each section is synthesized on its own and its first word starts the next cue. Without word marks each sentence becomes a cue.
+func buildCues(timeline *Timeline, text string) []cue {
+	var words = timeline.Marks(types.SpeechMarkTypeWord)
+	if len(words) == 0 {
+		words = timeline.Marks(types.SpeechMarkTypeSentence)
+	}
+	var sentenceEnds = make(map[int]bool)
+	for _, s := range timeline.Marks(types.SpeechMarkTypeSentence) {
+		sentenceEnds[s.End] = true
+	}
+
+	var cues []cue
+	var group []SpeechMark
+	var flush = func(next time.Duration) {
+		if len(group) == 0 {
+			return
+		}
+		var last = group[len(group)-1]
+		cues = append(cues, cue{
+			start: group[0].Time,
+			end:   min(next, last.Time+maxCueLinger),
+			text:  wrapCueText(cueText(text, group)),
+		})
+		group = group[:0]
+	}
+
+	for _, w := range words {
+		if len(group) > 0 {
+			var current = cueText(text, group)
+			var endsSentence = sentenceEnds[group[len(group)-1].End] || strings.ContainsAny(current[len(current)-1:], ".!?")
+			switch {
+			case !cueFits(cueText(text, append(group, w))),
+				w.Time-group[0].Time > maxCueDuration,
+				w.Section != group[0].Section,
+				endsSentence && len(current) >= maxSubtitleLineChars:
+				flush(w.Time)
+			}
+		}
+		group = append(group, w)
+	}
+	// the last cue stays up until the audio ends
+	var end = timeline.Duration()
+	if end == 0 {
+		end = math.MaxInt64
+	}
+	flush(end)
+	return cues
+}
+
+// cueText is the source text spanned by the marks with whitespace collapsed.
+func cueText(text string, marks []SpeechMark) string {
+	var first, last = marks[0], marks[len(marks)-1]
+	if first.Start >= 0 && last.End <= len(text) && first.Start < last.End {
+		return strings.Join(strings.Fields(text[first.Start:last.End]), " ")
+	}
+	var values = make([]string, len(marks))
+	for i, m := range marks {
+		values[i] = m.Value
+	}
+	return strings.Join(strings.Fields(strings.Join(values, " ")), " ")
+}
+
+// wrapCueText breaks text that is too long for one line at the space closest to the middle that
+// keeps both lines within maxSubtitleLineChars, or closest to the middle when there is none.
+func wrapCueText(text string) string {
+	if len(text) <= maxSubtitleLineChars {
+		return text
+	}
+	var mid = len(text) / 2
+	var best, bestFits = -1, false
+	for i, r := range text {
+		if r != ' ' {
+			continue
+		}
+		var fits = i <= maxSubtitleLineChars && len(text)-i-1 <= maxSubtitleLineChars
+		if best < 0 || fits && !bestFits || fits == bestFits && abs(i-mid) < abs(best-mid) {
+			best, bestFits = i, fits
+		}
+	}
+	if best < 0 {
+		return text
+	}
+	return text[:best] + "\n" + text[best+1:]
+}
+
+// cueFits reports whether text wraps onto maxSubtitleLines lines of maxSubtitleLineChars.
+func cueFits(text string) bool {
+	var lines = strings.Split(wrapCueText(text), "\n")
+	for _, line := range lines {
+		if len(line) > maxSubtitleLineChars {
+			return false
+		}
+	}
+	return len(lines) <= maxSubtitleLines
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/polly/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/kmulvey/text2speech/internal/fakeaws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubtitleTimestamp(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "00:00:00,000", subtitleTimestamp(0, ','))
+	assert.Equal(t, "01:02:03.456", subtitleTimestamp(time.Hour+2*time.Minute+3456*time.Millisecond, '.'))
+}
+
+func TestParseSubtitleFormats(t *testing.T) {
+	t.Parallel()
+
+	formats, err := parseSubtitleFormats("SRT, vtt,srt")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"srt", "vtt"}, formats)
+
+	_, err = parseSubtitleFormats("ass")
+	assert.ErrorIs(t, err, errUnknownSubtitleFormat)
+}
+
+func TestBuildCues(t *testing.T) {
+	t.Parallel()
+
+	var text = "Hi. This sentence is long enough that it has to be split across more than one cue on screen. Bye."
+	var timeline = &Timeline{}
+	var marks []SpeechMark
+	var words int
+	for _, s := range []string{"Hi.", "This sentence is long enough that it has to be split across more than one cue on screen.", "Bye."} {
+		var start = strings.Index(text, s)
+		marks = append(marks, SpeechMark{Time: time.Duration(words) * 300 * time.Millisecond, Type: types.SpeechMarkTypeSentence, Start: start, End: start + len(s), Value: s})
+		for _, w := range strings.Fields(s) {
+			var wordStart = strings.Index(text[start:], w) + start
+			marks = append(marks, SpeechMark{Time: time.Duration(words) * 300 * time.Millisecond, Type: types.SpeechMarkTypeWord, Start: wordStart, End: wordStart + len(w), Value: w})
+			start = wordStart + len(w)
+			words++
+		}
+	}
+	timeline.addSection(marks, time.Duration(words)*300*time.Millisecond, 0, 0, len(text))
+
+	var cues = buildCues(timeline, text)
+	assert.Len(t, cues, 2)
+	// short sentences are merged into the next one
+	assert.True(t, strings.HasPrefix(cues[0].text, "Hi. This sentence"), cues[0].text)
+	assert.Equal(t, time.Duration(0), cues[0].start)
+	for i, c := range cues {
+		assert.Less(t, c.start, c.end)
+		if i > 0 {
+			assert.LessOrEqual(t, cues[i-1].end, c.start)
+		}
+		var lines = strings.Split(c.text, "\n")
+		assert.LessOrEqual(t, len(lines), maxSubtitleLines)
+		for _, line := range lines {
+			assert.LessOrEqual(t, len(line), maxSubtitleLineChars, line)
+		}
+	}
+	// as is the short tail of the long sentence, the last cue stays up until the audio ends
+	assert.True(t, strings.HasSuffix(cues[1].text, "screen. Bye."), cues[1].text)
+	assert.Equal(t, timeline.Duration(), cues[1].end)
+
+	assert.Empty(t, buildCues(&Timeline{}, text))
+}
+
+func TestWriteSubtitleFiles(t *testing.T) {
+	var srv = newFakeAWS(t)
+	srv.Script(fakeaws.Task{Duration: 3 * time.Second}, fakeaws.Task{Duration: 2 * time.Second})
+
+	var text = longText(2)
+	var timeline = &Timeline{}
+	var audioChan = make(chan *s3.GetObjectOutput, 5)
+	var logs = make(chan string, 100)
+	var outputFile = filepath.Join(t.TempDir(), "talk.mp3")
+	var synth = synthesisOpts{bucket: "bucket", voiceID: "Matthew", marks: []types.SpeechMarkType{types.SpeechMarkTypeSentence, types.SpeechMarkTypeWord}}
+	assert.NoError(t, handleOutput(context.Background(), srv.PollyClient(), srv.S3Client(), audioChan, logs, synth, document{text: text}, outputFile, timeline))
+	assert.NoError(t, writeSubtitleFiles(outputFile, []string{"srt", "vtt"}, timeline, text))
+
+	srt, err := os.ReadFile(strings.TrimSuffix(outputFile, ".mp3") + ".srt")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(srt), "1\n00:00:00,000 --> "), string(srt[:40]))
+
+	vtt, err := os.ReadFile(strings.TrimSuffix(outputFile, ".mp3") + ".vtt")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(vtt), "WEBVTT\n\n00:00:00.000 --> "))
+
+	// a cue starts exactly where the second section starts, after the first section's audio
+	firstLength, err := audioFormat{}.duration(fakeaws.SilentMP3(3*time.Second, 0))
+	assert.NoError(t, err)
+	assert.Contains(t, string(vtt), "\n"+subtitleTimestamp(firstLength, '.')+" --> ")
+
+	// every word of the text made it into the captions
+	var captions strings.Builder
+	for _, block := range strings.Split(strings.TrimSpace(string(srt)), "\n\n") {
+		var lines = strings.SplitN(block, "\n", 3)
+		captions.WriteString(strings.ReplaceAll(lines[2], "\n", " ") + " ")
+	}
+	assert.Equal(t, strings.Fields(text), strings.Fields(captions.String()))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+var errInvalidWAV = errors.New("invalid wav file")
+
+// writeWAVHeader writes the canonical 44 byte RIFF/WAVE header for dataSize bytes of pcm.
+func writeWAVHeader(w io.Writer, format pcmFormat, dataSize int64) error {
+	var size = uint32(min(max(dataSize, 0), math.MaxUint32-36))
+	//nolint:gosec // channels and sample rate are small
+	var header = struct {
+		RiffID        [4]byte
+		RiffSize      uint32
+		WaveID        [4]byte
+		FmtID         [4]byte
+		FmtSize       uint32
+		AudioFormat   uint16
+		Channels      uint16
+		SampleRate    uint32
+		ByteRate      uint32
+		BlockAlign    uint16
+		BitsPerSample uint16
+		DataID        [4]byte
+		DataSize      uint32
+	}{
+		RiffID:        [4]byte{'R', 'I', 'F', 'F'},
+		RiffSize:      36 + size,
+		WaveID:        [4]byte{'W', 'A', 'V', 'E'},
+		FmtID:         [4]byte{'f', 'm', 't', ' '},
+		FmtSize:       16,
+		AudioFormat:   1, // PCM
+		Channels:      uint16(format.channels),
+		SampleRate:    uint32(format.sampleRate),
+		ByteRate:      uint32(format.bytesPerSecond()),
+		BlockAlign:    uint16(format.channels * 2),
+		BitsPerSample: 16,
+		DataID:        [4]byte{'d', 'a', 't', 'a'},
+		DataSize:      size,
+	}
+	if err := binary.Write(w, binary.LittleEndian, header); err != nil {
+		return fmt.Errorf("write wav header: %w", err)
+	}
+	return nil
+}
+
+// readWAV returns the format and the pcm of a wav file of 16 bit pcm, as written by writeWAVHeader.
+// Chunks other than fmt and data are skipped.
+func readWAV(body []byte) (pcmFormat, []byte, error) {
+	if len(body) < 12 || !bytes.Equal(body[:4], []byte("RIFF")) || !bytes.Equal(body[8:12], []byte("WAVE")) {
+		return pcmFormat{}, nil, fmt.Errorf("%w: no RIFF/WAVE header", errInvalidWAV)
+	}
+	var format pcmFormat
+	for chunk := body[12:]; len(chunk) >= 8; {
+		var id, size = string(chunk[:4]), int(binary.LittleEndian.Uint32(chunk[4:8]))
+		var data = chunk[8:]
+		if size > len(data) {
+			// the data chunk of a file that is still being written can be cut short
+			size = len(data)
+		}
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return format, nil, fmt.Errorf("%w: short fmt chunk", errInvalidWAV)
+			}
+			if binary.LittleEndian.Uint16(data[0:]) != 1 || binary.LittleEndian.Uint16(data[14:]) != 16 {
+				return format, nil, fmt.Errorf("%w: only 16 bit pcm is supported", errInvalidWAV)
+			}
+			format = pcmFormat{sampleRate: int(binary.LittleEndian.Uint32(data[4:])), channels: int(binary.LittleEndian.Uint16(data[2:]))}
+		case "data":
+			if format.sampleRate == 0 {
+				return format, nil, fmt.Errorf("%w: data before fmt", errInvalidWAV)
+			}
+			return format, data[:size], nil
+		}
+		// chunks are padded to an even size
+		chunk = data[min(size+size%2, len(data)):]
+	}
+	return format, nil, fmt.Errorf("%w: no data chunk", errInvalidWAV)
+}